@@ -2,7 +2,7 @@ package apis
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"runtime"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,6 +15,7 @@ import (
 	. "auth_next/models"
 	"auth_next/utils"
 	"auth_next/utils/auth"
+	"auth_next/utils/i18n"
 	"auth_next/utils/kong"
 )
 
@@ -29,6 +30,7 @@ import (
 // @Param json body RegisterRequest true "json"
 // @Success 201 {object} TokenResponse
 // @Failure 400 {object} common.MessageResponse "验证码错误、用户已注册"
+// @Failure 429 {object} common.MessageResponse "请求过于频繁"
 // @Failure 500 {object} common.MessageResponse
 func Register(c *fiber.Ctx) (err error) {
 	scope := "register"
@@ -38,10 +40,15 @@ func Register(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	err = checkEmailBlocked(body.Email, "register")
+	if err != nil {
+		return err
+	}
+
 	// check verification code
-	ok := auth.CheckVerificationCode(body.Email, scope, string(body.Verification))
-	if !ok {
-		return common.BadRequest("验证码错误，请多次尝试或者重新获取验证码")
+	err = auth.CheckVerificationCode(body.Email, scope, string(body.Verification))
+	if err != nil {
+		return err
 	}
 
 	defer func() {
@@ -117,10 +124,17 @@ func RegisterDebugInBatch(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	for _, data := range body.Data {
+		err = checkEmailBlocked(data.Email, "register_batch")
+		if err != nil {
+			return err
+		}
+	}
+
 	// check registered
 	var emailHashes []string
 	for _, data := range body.Data {
-		emailHashes = append(emailHashes, auth.MakeIdentifier(data.Email))
+		emailHashes = append(emailHashes, auth.IdentifierCandidates(data.Email)...)
 	}
 
 	var exists bool
@@ -130,7 +144,7 @@ func RegisterDebugInBatch(c *fiber.Ctx) (err error) {
 	}
 
 	if exists {
-		return common.BadRequest("用户已注册")
+		return i18n.BadRequest(i18n.CodeUserRegistered)
 	}
 
 	err = DB.Session(&gorm.Session{
@@ -207,21 +221,26 @@ func RegisterDebugInBatch(c *fiber.Ctx) (err error) {
 		if config.Config.ShamirFeature {
 
 			// create shamir emails
-			var shamirEmailResultChan = make(chan []ShamirEmail, 100)
+			type shamirEmailResult struct {
+				shamirEmails []ShamirEmail
+				commitments  []ShamirCommitment
+			}
+			var shamirEmailResultChan = make(chan shamirEmailResult, 100)
 			defer close(shamirEmailResultChan)
 
 			var shamirEmails []ShamirEmail
+			var commitments []ShamirCommitment
 
 			// task sender
 			go func() {
 				for _, user := range users {
 					user := user
 					tasksChan <- func() {
-						innerShamirEmails, err := GenerateShamirEmails(user.ID, user.Email)
+						innerShamirEmails, innerCommitments, err := GenerateShamirEmails(user.ID, user.Email)
 						if err != nil {
 							errChan <- err
 						}
-						shamirEmailResultChan <- innerShamirEmails
+						shamirEmailResultChan <- shamirEmailResult{innerShamirEmails, innerCommitments}
 					}
 					if len(errChan) > 0 {
 						return
@@ -234,16 +253,17 @@ func RegisterDebugInBatch(c *fiber.Ctx) (err error) {
 				select {
 				case err = <-errChan:
 					return err
-				case innerShamirEmails := <-shamirEmailResultChan:
-					shamirEmails = append(shamirEmails, innerShamirEmails...)
+				case result := <-shamirEmailResultChan:
+					shamirEmails = append(shamirEmails, result.shamirEmails...)
+					commitments = append(commitments, result.commitments...)
 				}
 				if len(shamirEmails)%1000 == 0 {
 					log.Info().Str("scope", taskScope).Msgf("prepare shamir emails: %d", len(shamirEmails))
 				}
 			}
 
-			// create shamir emails in batch
-			err = tx.Create(&shamirEmails).Error
+			// create shamir emails and commitments in batch
+			err = SaveShamirEmails(tx, shamirEmails, commitments)
 			if err != nil {
 				return err
 			}
@@ -288,9 +308,9 @@ func register(c *fiber.Ctx, email, password string, batch bool) error {
 	var user User
 	if registered {
 		if deleted {
-			return common.BadRequest("注销账号后禁止注册")
+			return i18n.BadRequest(i18n.CodeRegisterAfterDeleted)
 		} else {
-			return common.BadRequest("该用户已注册，如果忘记密码，请使用忘记密码功能找回")
+			return i18n.BadRequest(i18n.CodeUserRegisteredUseReset)
 		}
 	}
 
@@ -312,6 +332,11 @@ func register(c *fiber.Ctx, email, password string, batch bool) error {
 			return err
 		}
 
+		err = EmitWebhookEvent(tx, WebhookEventUserCreated, WebhookUserData{UserID: user.ID, Nickname: user.Nickname})
+		if err != nil {
+			return err
+		}
+
 		// create shamir emails
 		if config.Config.ShamirFeature {
 			return CreateShamirEmails(tx, user.ID, email)
@@ -357,6 +382,7 @@ func register(c *fiber.Ctx, email, password string, batch bool) error {
 // @Param json body RegisterRequest true "json"
 // @Success 200 {object} TokenResponse
 // @Failure 400 {object} common.MessageResponse "验证码错误"
+// @Failure 429 {object} common.MessageResponse "请求过于频繁"
 // @Failure 500 {object} common.MessageResponse
 func ChangePassword(c *fiber.Ctx) error {
 	scope := "reset"
@@ -376,22 +402,22 @@ func ChangePassword(c *fiber.Ctx) error {
 	}
 
 	if !registered {
-		return common.BadRequest("该用户未注册")
+		return i18n.BadRequest(i18n.CodeUserNotRegistered)
 	}
 	if deleted {
-		return common.BadRequest("账户已注销，禁止修改密码")
+		return i18n.BadRequest(i18n.CodeAccountDeletedNoChange)
 	}
 
-	ok := auth.CheckVerificationCode(body.Email, scope, string(body.Verification))
-	if !ok {
-		return common.BadRequest("验证码错误，请多次尝试或者重新获取验证码")
+	err = auth.CheckVerificationCode(body.Email, scope, string(body.Verification))
+	if err != nil {
+		return err
 	}
 
 	var user User
 	err = DB.Transaction(func(tx *gorm.DB) error {
 		err = tx.
 			Clauses(clause.Locking{Strength: "UPDATE"}).
-			Where("identifier = ?", auth.MakeIdentifier(body.Email)).
+			Where("identifier IN ?", auth.IdentifierCandidates(body.Email)).
 			Take(&user).Error
 		if err != nil {
 			return err
@@ -402,7 +428,21 @@ func ChangePassword(c *fiber.Ctx) error {
 		if err != nil {
 			return err
 		}
-		return tx.Save(&user).Error
+		err = tx.Save(&user).Error
+		if err != nil {
+			return err
+		}
+
+		err = UpgradeIdentifier(tx, &user, body.Email)
+		if err != nil {
+			if !errors.Is(err, ErrIdentifierCollision) {
+				return err
+			}
+			// a duplicate account, the password is changed anyway
+			log.Warn().Err(err).Int("user_id", user.ID).Msg("upgrade identifier failed")
+		}
+
+		return EmitWebhookEvent(tx, WebhookEventUserPasswordChanged, WebhookUserData{UserID: user.ID})
 	})
 	if err != nil {
 		return err
@@ -445,6 +485,7 @@ func ChangePassword(c *fiber.Ctx) error {
 // @Param email path string true "email"
 // @Success 200 {object} EmailVerifyResponse
 // @Failure 400 {object} common.MessageResponse “email不在白名单中”
+// @Failure 429 {object} common.MessageResponse "请求过于频繁"
 // @Failure 500 {object} common.MessageResponse
 func VerifyWithEmailOld(c *fiber.Ctx) error {
 	email := c.Params("email")
@@ -461,22 +502,31 @@ func VerifyWithEmailOld(c *fiber.Ctx) error {
 // @Router /verify/email [get]
 // @Param email query string true "email"
 // @Param check query bool false "check"
+// @Param scope query string false "elevate: send code for step-up authentication of the current user" Enums(elevate)
+// @Param lang query string false "locale of the email, default to Accept-Language" Enums(zh, en)
 // @Success 200 {object} EmailVerifyResponse
 // @Failure 400 {object} common.MessageResponse
 // @Failure 403 {object} common.MessageResponse “email不在白名单中”
+// @Failure 429 {object} common.MessageResponse "请求过于频繁"
 // @Failure 500 {object} common.MessageResponse
 func VerifyWithEmail(c *fiber.Ctx) error {
 	email := c.Query("email")
-	// scope := c.Query("scope")
+	if c.Query("scope") == utils.EmailPurposeElevate {
+		return verifyElevateWithEmail(c, email)
+	}
 	check := c.QueryBool("check")
 	return verifyWithEmail(c, email, check)
 }
 
 func verifyWithEmail(c *fiber.Ctx, email string, check bool) error {
 	if !utils.ValidateEmail(email) {
-		return common.BadRequest("email invalid")
+		return i18n.BadRequest(i18n.CodeEmailInvalid)
 	}
-	err := utils.ValidateEmailFudan(email)
+	err := checkEmailBlocked(email, "verify")
+	if err != nil {
+		return err
+	}
+	err = utils.CheckEmailPolicy(email)
 	if err != nil {
 		return err
 	}
@@ -485,7 +535,7 @@ func verifyWithEmail(c *fiber.Ctx, email string, check bool) error {
 		return err
 	}
 	if deleted {
-		return common.BadRequest("注销账号后禁止注册")
+		return i18n.BadRequest(i18n.CodeRegisterAfterDeleted)
 	}
 	registered, err := HasRegisteredEmail(DB, email)
 	if err != nil {
@@ -498,9 +548,9 @@ func verifyWithEmail(c *fiber.Ctx, email string, check bool) error {
 	}
 
 	if check {
-		message := "该邮箱已注册"
+		message := i18n.T(i18n.GetLocale(c), i18n.CodeEmailRegistered)
 		if !registered {
-			message = "该邮箱未注册"
+			message = i18n.T(i18n.GetLocale(c), i18n.CodeEmailNotRegistered)
 		}
 		return c.Status(400).JSON(EmailVerifyResponse{
 			Message:    message,
@@ -514,31 +564,27 @@ func verifyWithEmail(c *fiber.Ctx, email string, check bool) error {
 		return err
 	}
 
-	baseContent := fmt.Sprintf(`
-您的验证码是: %v
-验证码的有效期为 %d 分钟
-如果您意外地收到了此邮件，请忽略它
-`,
-		code, config.Config.VerificationCodeExpires)
-
-	var subject, content string
-	if !registered {
-		subject = fmt.Sprintf("%v 注册验证", config.Config.SiteName)
-		content = fmt.Sprintf("欢迎注册 %v, %v", config.Config.SiteName, baseContent)
-	} else {
-		subject = fmt.Sprintf("%v 重置密码", config.Config.SiteName)
-		content = fmt.Sprintf("您正在重置密码, %v", baseContent)
+	rendered, err := utils.RenderEmail(scope, i18n.GetLocale(c), utils.EmailTemplateData{
+		SiteName: config.Config.SiteName,
+		Email:    email,
+		Code:     code,
+		Expires:  config.Config.VerificationCodeExpires,
+	})
+	if err != nil {
+		return err
 	}
 
-	err = utils.SendEmail(subject, content, []string{email})
+	outbox, err := EnqueueEmail(DB, scope, rendered.Subject, rendered.Text, rendered.HTML, []string{email})
 	if err != nil {
 		return err
 	}
 
 	return c.JSON(EmailVerifyResponse{
-		Message:    "验证邮件已发送，请查收\n如未收到，请检查邮件地址是否正确，检查垃圾箱，或重试",
-		Registered: registered,
-		Scope:      scope,
+		Message:     i18n.T(i18n.GetLocale(c), i18n.CodeVerificationEmailSent),
+		Registered:  registered,
+		Scope:       scope,
+		EmailID:     outbox.ID,
+		EmailStatus: outbox.Status,
 	})
 }
 
@@ -557,7 +603,7 @@ func verifyWithEmail(c *fiber.Ctx, email string, check bool) error {
 // @Failure 409 {object} common.MessageResponse "用户已注册"
 // @Failure 500 {object} common.MessageResponse
 func VerifyWithApikey(_ *fiber.Ctx) error {
-	return common.Forbidden("快捷登录/注册已停用，请返回并使用旦挞账户直接登录。注册账户请前往 https://auth.fduhole.com")
+	return i18n.Forbidden(i18n.CodeQuickLoginDisabled)
 
 	//var query ApikeyRequest
 	//err := common.ValidateQuery(c, &query)
@@ -600,10 +646,12 @@ func VerifyWithApikey(_ *fiber.Ctx) error {
 // @Summary delete user
 // @Description delete user and related jwt credentials
 // @Tags account
+// @Description requires an elevated token, see POST /elevate
 // @Router /users/me [delete]
 // @Param json body LoginRequest true "email, password"
 // @Success 204
 // @Failure 400 {object} common.MessageResponse "密码错误"
+// @Failure 403 {object} common.MessageResponse "需要二次验证"
 // @Failure 404 {object} common.MessageResponse "用户不存在"
 // @Failure 500 {object} common.MessageResponse
 func DeleteUser(c *fiber.Ctx) error {
@@ -613,18 +661,32 @@ func DeleteUser(c *fiber.Ctx) error {
 		return err
 	}
 
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
+
 	var user User
 	err = DB.Transaction(func(tx *gorm.DB) error {
 		err = tx.
 			Clauses(clause.Locking{Strength: "UPDATE"}).
-			Where("identifier = ?", auth.MakeIdentifier(body.Email)).
+			Where("identifier IN ?", auth.IdentifierCandidates(body.Email)).
 			Take(&user).Error
 		if err != nil {
 			return err
 		}
 
+		if user.ID != userID {
+			return i18n.Forbidden(i18n.CodeEmailNotMatch)
+		}
+
 		if !user.Identifier.Valid {
-			return common.BadRequest("账户已注销")
+			return i18n.BadRequest(i18n.CodeAccountDeleted)
 		}
 
 		ok, err := auth.CheckPassword(body.Password, user.Password)
@@ -632,10 +694,11 @@ func DeleteUser(c *fiber.Ctx) error {
 			return err
 		}
 		if !ok {
-			return common.Forbidden("密码错误")
+			return i18n.Forbidden(i18n.CodePasswordIncorrect)
 		}
 
-		return DeleteUserService(tx, user.ID, user.Identifier.String)
+		// ban the canonical identifier, so that aliases of the email can't register again
+		return DeleteUserService(tx, user.ID, auth.MakeIdentifier(body.Email))
 	})
 
 	if err != nil {
@@ -662,8 +725,10 @@ func DeleteUser(c *fiber.Ctx) error {
 // @Description delete user and related jwt credentials
 // @Tags account
 // @Router /users/{id} [delete]
+// @Description requires an elevated token, see POST /elevate
 // @Param id path int true "user id"
 // @Success 204
+// @Failure 403 {object} common.MessageResponse "非管理员或需要二次验证"
 // @Failure 404 {object} common.MessageResponse "用户不存在"
 // @Failure 500 {object} common.MessageResponse
 func DeleteUserByID(c *fiber.Ctx) error {
@@ -675,13 +740,18 @@ func DeleteUserByID(c *fiber.Ctx) error {
 		return common.Forbidden()
 	}
 
+	err = CheckElevated(c, operatorID)
+	if err != nil {
+		return err
+	}
+
 	userID, err := c.ParamsInt("id")
 	if err != nil {
 		return err
 	}
 
 	if operatorID == userID {
-		return common.Forbidden("不能注销自己")
+		return i18n.Forbidden(i18n.CodeCannotDeleteSelf)
 	}
 
 	var user User
@@ -695,7 +765,7 @@ func DeleteUserByID(c *fiber.Ctx) error {
 		}
 
 		if !user.Identifier.Valid {
-			return common.BadRequest("账户已注销")
+			return i18n.BadRequest(i18n.CodeAccountDeleted)
 		}
 
 		return DeleteUserService(tx, user.ID, user.Identifier.String)
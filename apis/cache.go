@@ -13,18 +13,24 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"auth_next/config"
-	"auth_next/models"
-	"auth_next/utils/shamir"
+	"auth_next/utils"
 )
 
-var GlobalUploadShamirStatus struct {
-	sync.Mutex
-	ShamirStatusResponse
-}
-
 func Init() {
-	InitShamirStatus()
-	InitUserSharesStatus()
+	err := utils.InitEmailTemplates()
+	if err != nil {
+		log.Fatal().Err(err).Msg("init email templates failed")
+	}
+
+	err = utils.InitEmailPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("init email policy failed")
+	}
+
+	err = utils.InitEmailBlocklist()
+	if err != nil {
+		log.Fatal().Err(err).Msg("init email blocklist failed")
+	}
 
 	if config.Config.EnableRegisterQuestions {
 		err := InitQuestions()
@@ -34,15 +40,6 @@ func Init() {
 	}
 }
 
-func InitShamirStatus() {
-	GlobalUploadShamirStatus.ShamirStatusResponse = ShamirStatusResponse{
-		UploadedShares:              make(map[int]shamir.Shares, 0),
-		CurrentPublicKeys:           models.ShamirPublicKeys,
-		UploadedSharesIdentityNames: make([]string, 0, 7),
-		NewPublicKeys:               make([]models.ShamirPublicKey, 0, 7),
-	}
-}
-
 var GlobalQuestionConfig struct {
 	sync.RWMutex
 	Questions      map[int]QuestionConfig
@@ -231,16 +228,3 @@ LOAD_FILES:
 
 	return nil
 }
-
-var GlobalUserSharesStatus struct {
-	sync.Mutex
-	ShamirUsersSharesResponse
-}
-
-func InitUserSharesStatus() {
-	GlobalUserSharesStatus.ShamirUsersSharesResponse = ShamirUsersSharesResponse{
-		UploadedShares:              make(map[int]shamir.Shares, 0),
-		UploadedSharesIdentityNames: make(map[int][]string, 0),
-		ShamirUploadReady:           make(map[int]bool, 0),
-	}
-}
@@ -0,0 +1,132 @@
+package apis
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"auth_next/config"
+	. "auth_next/models"
+	"auth_next/utils"
+	"auth_next/utils/auth"
+	"auth_next/utils/i18n"
+)
+
+// Elevate godoc
+//
+// @Summary step-up authentication
+// @Description Confirm the identity of the current user again with password or email code,
+// @Description return a short-lived access token required by sensitive operations,
+// @Description such as deleting account and shamir decryption.
+// @Description Request the email code by GET /verify/email?scope=elevate with the access token.
+// @Tags token
+// @Accept json
+// @Produce json
+// @Router /elevate [post]
+// @Param json body ElevateRequest true "json"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} common.MessageResponse "验证码错误"
+// @Failure 401 {object} common.MessageResponse "密码错误"
+// @Failure 500 {object} common.MessageResponse
+func Elevate(c *fiber.Ctx) error {
+	var body ElevateRequest
+	err := common.ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := LoadUserFromDB(userID)
+	if err != nil {
+		return err
+	}
+
+	switch body.Method {
+	case ElevationMethodPassword:
+		ok, err := auth.CheckPassword(body.Password, user.Password)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Info().Int("user_id", userID).Msg("elevation failed: wrong password")
+			return i18n.Unauthorized(i18n.CodePasswordIncorrect)
+		}
+	case ElevationMethodEmail:
+		if !user.Identifier.Valid || !slices.Contains(auth.IdentifierCandidates(body.Email), user.Identifier.String) {
+			return i18n.BadRequest(i18n.CodeEmailNotMatch)
+		}
+		err = auth.CheckVerificationCode(body.Email, utils.EmailPurposeElevate, string(body.Verification))
+		if err != nil {
+			log.Info().Int("user_id", userID).Msg("elevation failed: wrong verification code")
+			return err
+		}
+		err = auth.DeleteVerificationCode(body.Email, utils.EmailPurposeElevate)
+		if err != nil {
+			return err
+		}
+	case ElevationMethodTOTP:
+		// there is no two-factor authentication yet
+		return i18n.BadRequest(i18n.CodeTOTPNotEnabled)
+	}
+
+	access, err := user.CreateElevatedJWTToken(body.Method)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Int("user_id", userID).Str("method", body.Method).Msg("user elevated")
+	return c.JSON(TokenResponse{
+		Access:  access,
+		Message: "elevate successful",
+	})
+}
+
+// verifyElevateWithEmail 向当前用户的邮箱发送二次验证的验证码
+func verifyElevateWithEmail(c *fiber.Ctx, email string) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := LoadUserFromDB(userID)
+	if err != nil {
+		return err
+	}
+	if !user.Identifier.Valid || !slices.Contains(auth.IdentifierCandidates(email), user.Identifier.String) {
+		return i18n.BadRequest(i18n.CodeEmailNotMatch)
+	}
+
+	scope := utils.EmailPurposeElevate
+	code, err := auth.SetVerificationCode(email, scope)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := utils.RenderEmail(scope, i18n.GetLocale(c), utils.EmailTemplateData{
+		SiteName: config.Config.SiteName,
+		Email:    email,
+		Code:     code,
+		Expires:  config.Config.VerificationCodeExpires,
+	})
+	if err != nil {
+		return err
+	}
+
+	outbox, err := EnqueueEmail(DB, scope, rendered.Subject, rendered.Text, rendered.HTML, []string{email})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(EmailVerifyResponse{
+		Message:     i18n.T(i18n.GetLocale(c), i18n.CodeVerificationEmailSent),
+		Registered:  true,
+		Scope:       scope,
+		EmailID:     outbox.ID,
+		EmailStatus: outbox.Status,
+	})
+}
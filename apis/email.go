@@ -0,0 +1,258 @@
+package apis
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
+
+	. "auth_next/models"
+	"auth_next/utils"
+	"auth_next/utils/i18n"
+	"auth_next/utils/metrics"
+)
+
+// ReloadEmailTemplates godoc
+// @summary Reload email templates
+// @description Reload email templates from EMAIL_TEMPLATE_DIR, admin only
+// @tags email
+// @produce json
+// @router /email/templates/_reload [post]
+// @success 204
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func ReloadEmailTemplates(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	err = utils.InitEmailTemplates()
+	if err != nil {
+		return i18n.InternalServerError(i18n.CodeReloadFailed, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetEmailStatus godoc
+// @summary Get email status
+// @description Get sending status of an email in the outbox, the id is returned by /verify/email
+// @tags email
+// @produce json
+// @router /emails/{id} [get]
+// @param id path string true "email id"
+// @success 200 {object} EmailOutbox
+// @failure 404 {object} common.HttpError "not found"
+// @failure 500 {string} common.HttpError "internal server error"
+func GetEmailStatus(c *fiber.Ctx) (err error) {
+	var outbox EmailOutbox
+	err = DB.Take(&outbox, "id = ?", c.Params("id")).Error
+	if err != nil {
+		return
+	}
+
+	return c.JSON(outbox)
+}
+
+// ListEmails godoc
+// @summary List emails in outbox
+// @description List emails in outbox by status, failed by default, admin only
+// @tags email
+// @produce json
+// @router /emails [get]
+// @param query query ListEmailsRequest false "query"
+// @success 200 {array} EmailOutbox
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func ListEmails(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	var query ListEmailsRequest
+	err = common.ValidateQuery(c, &query)
+	if err != nil {
+		return
+	}
+
+	outboxes := make([]EmailOutbox, 0, query.Size)
+	err = DB.Where("status = ?", query.Status).
+		Order("created_at desc").
+		Offset(query.Offset).Limit(query.Size).
+		Find(&outboxes).Error
+	if err != nil {
+		return
+	}
+
+	return c.JSON(outboxes)
+}
+
+// ReloadEmailPolicy godoc
+// @summary Reload email policy
+// @description Reload email domain policy from EMAIL_POLICY_FILE, admin only
+// @tags email
+// @produce json
+// @router /email/policy/_reload [post]
+// @success 204
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func ReloadEmailPolicy(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	err = utils.InitEmailPolicy()
+	if err != nil {
+		return i18n.InternalServerError(i18n.CodeReloadFailed, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// checkEmailBlocked 检查邮箱是否在黑名单中，source 用于日志和监控
+func checkEmailBlocked(email, source string) error {
+	blocked, entry := utils.IsEmailBlocked(email)
+	if !blocked {
+		return nil
+	}
+	metrics.EmailBlockedTotal.WithLabelValues(source).Inc()
+	log.Info().Str("source", source).Str("entry", entry).Msg("email blocked")
+	return i18n.BadRequest(i18n.CodeEmailDomainBlocked)
+}
+
+// ListEmailBlocklist godoc
+// @summary List email blocklist
+// @description List email blocklist entries, admin only
+// @tags email
+// @produce json
+// @router /email/blocklist [get]
+// @success 200 {object} EmailBlocklistRequest
+// @failure 403 {object} common.HttpError "forbidden"
+func ListEmailBlocklist(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	return c.JSON(EmailBlocklistRequest{Entries: utils.ListEmailBlocklist()})
+}
+
+// AddEmailBlocklist godoc
+// @summary Add email blocklist entries
+// @description Add domains, globs (*.example.*) or regexps (re:...) to email blocklist, admin only
+// @tags email
+// @accept json
+// @produce json
+// @router /email/blocklist [post]
+// @param json body EmailBlocklistRequest true "entries"
+// @success 200 {object} EmailBlocklistRequest
+// @failure 400 {object} common.HttpError "invalid entry"
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func AddEmailBlocklist(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	var body EmailBlocklistRequest
+	err = common.ValidateBody(c, &body)
+	if err != nil {
+		return
+	}
+
+	err = utils.AddEmailBlocklistEntries(body.Entries)
+	if err != nil {
+		return i18n.BadRequest(i18n.CodeEmailBlocklistEntryError, err)
+	}
+
+	log.Info().Int("user_id", userID).Strs("entries", body.Entries).Msg("add email blocklist entries")
+
+	return c.JSON(EmailBlocklistRequest{Entries: utils.ListEmailBlocklist()})
+}
+
+// DeleteEmailBlocklist godoc
+// @summary Delete email blocklist entries
+// @description Delete entries from email blocklist, admin only
+// @tags email
+// @accept json
+// @produce json
+// @router /email/blocklist [delete]
+// @param json body EmailBlocklistRequest true "entries"
+// @success 200 {object} EmailBlocklistRequest
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func DeleteEmailBlocklist(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	var body EmailBlocklistRequest
+	err = common.ValidateBody(c, &body)
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveEmailBlocklistEntries(body.Entries)
+	if err != nil {
+		return
+	}
+
+	log.Info().Int("user_id", userID).Strs("entries", body.Entries).Msg("delete email blocklist entries")
+
+	return c.JSON(EmailBlocklistRequest{Entries: utils.ListEmailBlocklist()})
+}
+
+// ReloadEmailBlocklist godoc
+// @summary Reload email blocklist
+// @description Reload email blocklist from EMAIL_BLOCKLIST_FILE, admin only
+// @tags email
+// @produce json
+// @router /email/blocklist/_reload [post]
+// @success 204
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func ReloadEmailBlocklist(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	err = utils.InitEmailBlocklist()
+	if err != nil {
+		return i18n.InternalServerError(i18n.CodeReloadFailed, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
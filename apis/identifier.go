@@ -0,0 +1,91 @@
+package apis
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
+
+	"auth_next/config"
+	. "auth_next/models"
+	"auth_next/utils/auth"
+	"auth_next/utils/i18n"
+)
+
+// newIdentifierLimiter 限制每个 IP 每分钟计算 identifier 的公开请求数.
+// identifier 可能使用 argon2，每次计算都需要大量内存和 CPU，在查询数据库之前限制
+func newIdentifierLimiter() fiber.Handler {
+	if config.Config.IdentifierRateLimit <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+	return limiter.New(limiter.Config{
+		Max:        config.Config.IdentifierRateLimit,
+		Expiration: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			ip := c.IP()
+			if ip == "" {
+				// proxy header not set, e.g. requests not from kong
+				ip = c.Context().RemoteIP().String()
+			}
+			return ip
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			log.Warn().Str("ip", c.IP()).Str("path", c.Path()).Msg("identifier rate limit reached")
+			return i18n.TooManyRequests(i18n.CodeTooManyRequests)
+		},
+	})
+}
+
+// GetIdentifierStatus godoc
+// @summary Get identifier salt rotation progress
+// @description Count users and deleted identifiers by salt version, admin only.
+// @description Users are re-hashed to the current version on their next login or password change.
+// @tags account
+// @produce json
+// @router /identifiers/status [get]
+// @success 200 {object} IdentifierStatusResponse
+// @failure 403 {object} common.HttpError "forbidden"
+// @failure 500 {string} common.HttpError "internal server error"
+func GetIdentifierStatus(c *fiber.Ctx) (err error) {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	users, err := IdentifierVersionCount(DB, &User{})
+	if err != nil {
+		return
+	}
+	deletedIdentifiers, err := IdentifierVersionCount(DB, &DeleteIdentifier{})
+	if err != nil {
+		return
+	}
+
+	versions := auth.IdentifierVersions()
+	response := IdentifierStatusResponse{
+		CurrentVersion:     versions[0],
+		Versions:           versions,
+		Users:              users,
+		DeletedIdentifiers: deletedIdentifiers,
+	}
+
+	var total int64
+	for _, count := range users {
+		total += count
+	}
+	if total > 0 {
+		response.Progress = float64(users[response.CurrentVersion]) / float64(total)
+	} else {
+		response.Progress = 1
+	}
+
+	return c.JSON(response)
+}
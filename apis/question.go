@@ -2,10 +2,10 @@ package apis
 
 import (
 	"encoding/json"
-	"fmt"
 	"sort"
 
 	. "auth_next/models"
+	"auth_next/utils/i18n"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/opentreehole/go-common"
@@ -47,7 +47,7 @@ func RetrieveQuestions(c *fiber.Ctx) (err error) {
 	questionConfig, ok := GlobalQuestionConfig.Questions[version]
 	GlobalQuestionConfig.RUnlock()
 	if !ok {
-		return common.NotFound("question version not found")
+		return i18n.NotFound(i18n.CodeQuestionVersionNotFound)
 	}
 
 	var (
@@ -67,18 +67,18 @@ func RetrieveQuestions(c *fiber.Ctx) (err error) {
 	} else if numberOfOptionalQuestions >= 0 {
 		number += numberOfOptionalQuestions
 	} else {
-		return common.InternalServerError("[retrieve questions]: number of optional questions invalid")
+		return i18n.InternalServerError(i18n.CodeQuestionConfigInvalid, "number of optional questions invalid")
 	}
 	if numberOfCampusQuestions == -1 {
 		number += len(campusQuestions)
 	} else if numberOfCampusQuestions >= 0 {
 		number += numberOfCampusQuestions
 	} else {
-		return common.InternalServerError("[retrieve questions]: number of campus questions invalid")
+		return i18n.InternalServerError(i18n.CodeQuestionConfigInvalid, "number of campus questions invalid")
 	}
 
 	if number == 0 {
-		return common.InternalServerError("[retrieve questions]: number of questions too small")
+		return i18n.InternalServerError(i18n.CodeQuestionConfigInvalid, "number of questions too small")
 	}
 
 	var questionsResponse = QuestionConfig{
@@ -204,7 +204,7 @@ func AnswerQuestions(c *fiber.Ctx) (err error) {
 	questionConfig, ok := GlobalQuestionConfig.Questions[version]
 	GlobalQuestionConfig.RUnlock()
 	if !ok {
-		return common.NotFound("question version not found")
+		return i18n.NotFound(i18n.CodeQuestionVersionNotFound)
 	}
 
 	var (
@@ -224,7 +224,7 @@ func AnswerQuestions(c *fiber.Ctx) (err error) {
 			return q.ID - t.ID
 		})
 		if !ok {
-			return common.BadRequest(fmt.Sprintf("question id %d not found", answer.ID))
+			return i18n.BadRequest(i18n.CodeQuestionNotFound, answer.ID)
 		}
 
 		questionMap[answer.ID] = questions[id]
@@ -238,9 +238,9 @@ func AnswerQuestions(c *fiber.Ctx) (err error) {
 	// check if submitted question number match
 	submittedQuestionNumber = submittedRequiredQuestionNumber + submittedOptionalQuestionNumber
 	if submittedQuestionNumber != number {
-		return common.BadRequest(fmt.Sprintf("question number %d not match", submittedQuestionNumber))
+		return i18n.BadRequest(i18n.CodeQuestionNumberMismatch, submittedQuestionNumber)
 	} else if submittedRequiredQuestionNumber != len(requiredQuestions) {
-		return common.BadRequest(fmt.Sprintf("required question number %d not match", submittedRequiredQuestionNumber))
+		return i18n.BadRequest(i18n.CodeRequiredQuestionMismatch, submittedRequiredQuestionNumber)
 	}
 
 	// check if submitted question answer is correct
@@ -311,12 +311,12 @@ func ReloadQuestions(c *fiber.Ctx) (err error) {
 	}
 
 	if !IsAdmin(userID) {
-		return common.Forbidden("only admin can reload questions")
+		return i18n.Forbidden(i18n.CodeAdminRequired)
 	}
 
 	err = InitQuestions()
 	if err != nil {
-		return common.InternalServerError("reload question failed: " + err.Error())
+		return i18n.InternalServerError(i18n.CodeReloadFailed, err)
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
@@ -21,20 +21,27 @@ func RegisterRoutes(app *fiber.App) {
 	routes := app.Group("/api")
 	routes.Get("/", Index)
 
+	// public endpoints computing identifiers of emails
+	identifierLimiter := newIdentifierLimiter()
+
 	// token
-	routes.Post("/login", Login)
+	routes.Post("/login", identifierLimiter, Login)
 	routes.Get("/logout", Logout)
 	routes.Post("/refresh", Refresh)
+	routes.Post("/elevate", Elevate)
 
 	// account management
-	routes.Get("/verify/email", VerifyWithEmail)
-	routes.Get("/verify/email/:email", VerifyWithEmailOld)
+	routes.Get("/verify/email", identifierLimiter, VerifyWithEmail)
+	routes.Get("/verify/email/:email", identifierLimiter, VerifyWithEmailOld)
 	routes.Get("/verify/apikey", VerifyWithApikey)
-	routes.Post("/register", Register)
-	routes.Put("/register", ChangePassword)
-	routes.Patch("/register/_webvpn", ChangePassword)
+	routes.Post("/register", identifierLimiter, Register)
+	routes.Put("/register", identifierLimiter, ChangePassword)
+	routes.Patch("/register/_webvpn", identifierLimiter, ChangePassword)
 	routes.Delete("/users/me", DeleteUser)
 	routes.Delete("/users/:id", DeleteUserByID)
+	routes.Post("/users/_lookup", LookupUsers)
+	routes.Get("/identifiers/status", GetIdentifierStatus)
+	routes.Get("/audit_logs", GetAuditLogs)
 
 	// register questions
 	if config.Config.EnableRegisterQuestions {
@@ -43,6 +50,16 @@ func RegisterRoutes(app *fiber.App) {
 		routes.Post("/register/questions/_reload", ReloadQuestions)
 	}
 
+	// email
+	routes.Post("/email/templates/_reload", ReloadEmailTemplates)
+	routes.Post("/email/policy/_reload", ReloadEmailPolicy)
+	routes.Get("/email/blocklist", ListEmailBlocklist)
+	routes.Post("/email/blocklist", AddEmailBlocklist)
+	routes.Delete("/email/blocklist", DeleteEmailBlocklist)
+	routes.Post("/email/blocklist/_reload", ReloadEmailBlocklist)
+	routes.Get("/emails", ListEmails)
+	routes.Get("/emails/:id", GetEmailStatus)
+
 	// account management debug only
 	routes.Post("/debug/register", RegisterDebug)
 	routes.Post("/debug/register/_batch", RegisterDebugInBatch)
@@ -55,6 +72,14 @@ func RegisterRoutes(app *fiber.App) {
 	routes.Put("/users/:id", ModifyUser)
 	routes.Patch("/users/:id<int>/_webvpn", ModifyUser)
 
+	// webhook
+	routes.Get("/webhooks", ListWebhooks)
+	routes.Post("/webhooks", CreateWebhook)
+	routes.Put("/webhooks/:id", ModifyWebhook)
+	routes.Delete("/webhooks/:id", DeleteWebhook)
+	routes.Get("/webhooks/:id/deliveries", ListWebhookDeliveries)
+	routes.Post("/webhooks/deliveries/:id/_redeliver", RedeliverWebhook)
+
 	// shamir
 	routes.Get("/shamir/status", GetShamirStatus)
 	routes.Get("/shamir/:id", GetPGPMessageByUserID)
@@ -64,6 +89,10 @@ func RegisterRoutes(app *fiber.App) {
 	routes.Post("/shamir/update", UpdateShamir)
 	routes.Put("/shamir/refresh", RefreshShamir)
 	routes.Patch("/shamir/refresh/_webvpn", RefreshShamir)
+	routes.Post("/shamir/decrypt/requests", CreateDecryptRequest)
+	routes.Get("/shamir/decrypt/requests", ListDecryptRequests)
+	routes.Get("/shamir/decrypt/requests/:id", GetDecryptRequest)
+	routes.Delete("/shamir/decrypt/requests/:id", CancelDecryptRequest)
 	routes.Post("/shamir/decrypt", UploadUserShares)
 	routes.Get("/shamir/decrypt/:id", GetDecryptedUserEmail)
 	routes.Get("/shamir/decrypt/status/:id", GetDecryptStatusbyUserID)
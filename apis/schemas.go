@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"auth_next/models"
 	"auth_next/utils/shamir"
@@ -68,7 +69,11 @@ func (v *VerificationType) UnmarshalText(data []byte) error {
 type EmailVerifyResponse struct {
 	Message    string `json:"message"`
 	Registered bool   `json:"registered"`
-	Scope      string `json:"scope" enums:"register,reset"`
+	Scope      string `json:"scope" enums:"register,reset,elevate"`
+
+	// 邮件在发件箱中的 ID，可通过 /emails/{id} 查询发送状态
+	EmailID     string `json:"email_id,omitempty"`
+	EmailStatus string `json:"email_status,omitempty" enums:"pending,sending,sent,failed"`
 }
 
 type ApikeyRequest struct {
@@ -208,6 +213,23 @@ type ModifyUserRequest struct {
 	Nickname *string `json:"nickname" validate:"omitempty,min=1"`
 }
 
+type LookupUsersRequest struct {
+	// 一次最多 500 个，每个邮箱需要为每个 salt 版本计算 identifier
+	Emails []string `json:"emails" validate:"required,min=1,max=500,dive,email"`
+
+	// 查找的理由，例如举报或学校来函的编号，记录在审计日志中；CSV 请求通过 query 传递
+	Reason string `json:"reason" query:"reason" validate:"required,min=10,max=2000"`
+}
+
+type ListAuditLogsRequest struct {
+	ActorID      int    `json:"actor_id" query:"actor_id" validate:"min=0"`
+	Action       string `json:"action" query:"action" validate:"max=64"`
+	ResourceID   string `json:"resource_id" query:"resource_id" validate:"max=64"`
+	TargetUserID int    `json:"target_user_id" query:"target_user_id" validate:"min=0"`
+	Offset       int    `json:"offset" query:"offset" validate:"min=0"`
+	Size         int    `json:"size" query:"size" default:"30" validate:"min=1,max=100"`
+}
+
 /* shamir */
 
 type PGPMessageRequest struct {
@@ -226,16 +248,23 @@ type UserShare struct {
 
 type UploadSharesRequest struct {
 	PGPMessageRequest
-	Shares []UserShare `json:"shares" query:"shares"`
+	Shares    []UserShare `json:"shares" query:"shares"`
+	SessionID string      `json:"session_id"` // optional, must be the current session if set
+
+	// 分块上传：Chunk 从 0 开始，除最后一块外 More 为 true；不分块时都不填
+	Chunk int  `json:"chunk" validate:"min=0"`
+	More  bool `json:"more"`
 }
 
 type UploadShareRequest struct {
 	PGPMessageRequest
 	UserShare
+	SessionID string `json:"session_id" validate:"required"` // the decrypt request being approved
 }
 
 type UploadPublicKeyRequest struct {
-	Data []string `json:"data" validate:"required,len=7"` // all standalone public keys
+	Data      []string `json:"data" validate:"required,min=1"` // all standalone public keys, SHAMIR_KEY_COUNT in total
+	SessionID string   `json:"session_id"`                     // optional, must be the current session if set
 }
 
 type IdentityNameResponse struct {
@@ -243,30 +272,131 @@ type IdentityNameResponse struct {
 }
 
 type ShamirStatusResponse struct {
-	ShamirUpdateReady           bool                     `json:"shamir_update_ready"`
-	ShamirUpdating              bool                     `json:"shamir_updating"`
-	UploadedSharesIdentityNames []string                 `json:"uploaded_shares_identity_names"`
-	UploadedShares              map[int]shamir.Shares    `json:"-"`
-	CurrentPublicKeys           []models.ShamirPublicKey `json:"current_public_keys"`
-	NewPublicKeys               []models.ShamirPublicKey `json:"new_public_keys"`
-	NowUserID                   int                      `json:"now_user_id,omitempty"`
-	FailMessage                 string                   `json:"fail_message,omitempty"`
-	WarningMessage              string                   `json:"warning_message,omitempty"`
+	ShamirUpdateReady           bool                       `json:"shamir_update_ready"`
+	ShamirUpdating              bool                       `json:"shamir_updating"`
+	Stalled                     bool                       `json:"stalled,omitempty"` // update interrupted, trigger update again to resume
+	UploadedSharesIdentityNames []string                   `json:"uploaded_shares_identity_names"`
+	UploadedShares              []models.ShamirSharesBatch `json:"uploaded_shares"` // uploaded shares of each identity and when they expire
+	CurrentPublicKeys           []models.ShamirPublicKey   `json:"current_public_keys"`
+	NewPublicKeys               []models.ShamirPublicKey   `json:"new_public_keys"`
+	KeySetID                    int                        `json:"key_set_id"`
+	KeyCount                    int                        `json:"key_count"`     // number of current public keys
+	Threshold                   int                        `json:"threshold"`     // shares required to decrypt with current public keys
+	NewKeyCount                 int                        `json:"new_key_count"` // number of new public keys required
+	NewThreshold                int                        `json:"new_threshold"` // threshold of the new public keys
+	NowUserID                   int                        `json:"now_user_id,omitempty"`
+	NewKeySetID                 int                        `json:"new_key_set_id,omitempty"`     // key set being built by the update
+	CheckpointUserID            int                        `json:"checkpoint_user_id,omitempty"` // users with id not greater than it are re-encrypted
+	FailMessage                 string                     `json:"fail_message,omitempty"`
+	WarningMessage              string                     `json:"warning_message,omitempty"`
+	SessionID                   string                     `json:"session_id,omitempty"`
+	SessionStatus               string                     `json:"session_status,omitempty" enums:"pending,updating,success,failed,cancelled,expired"`
+	OwnerID                     int                        `json:"owner_id,omitempty"`
+	ExpiresAt                   *time.Time                 `json:"expires_at,omitempty"`
 }
 
-type ShamirUsersSharesResponse struct {
-	ShamirUploadReady           map[int]bool          `json:"shamir_upload_ready"`
-	UploadedSharesIdentityNames map[int][]string      `json:"uploaded_shares_identity_names"`
-	UploadedShares              map[int]shamir.Shares `json:"-"`
+type ShamirUserSharesResponse struct {
+	ShamirUploadReady           bool                       `json:"shamir_upload_ready"`
+	UploadedSharesIdentityNames []string                   `json:"uploaded_shares_identity_names"`
+	UploadedShares              []models.ShamirSharesBatch `json:"uploaded_shares"` // shares expire after SHAMIR_SHARE_TTL minutes
+	Threshold                   int                        `json:"threshold"`
+	SessionID                   string                     `json:"session_id,omitempty"`
+	OwnerID                     int                        `json:"owner_id,omitempty"`
+	Reason                      string                     `json:"reason,omitempty"`
+	ExpiresAt                   *time.Time                 `json:"expires_at,omitempty"`
 }
 
-type ShamirUserSharesResponse struct {
-	ShamirUploadReady           bool     `json:"shamir_upload_ready"`
-	UploadedSharesIdentityNames []string `json:"uploaded_shares_identity_names"`
+type CreateDecryptRequestRequest struct {
+	UserID int    `json:"user_id" validate:"required,min=1"`
+	Reason string `json:"reason" validate:"required,min=10,max=2000"` // legal basis, e.g. the case number
+}
+
+type ListDecryptRequestsRequest struct {
+	UserID int    `json:"user_id" query:"user_id" validate:"min=0"`
+	Status string `json:"status" query:"status" validate:"omitempty,oneof=pending updating success failed cancelled expired"`
+	Offset int    `json:"offset" query:"offset" validate:"min=0"`
+	Size   int    `json:"size" query:"size" default:"30" validate:"min=1,max=100"`
+}
+
+// DecryptRequestResponse 解密申请，即目标用户的解密会话
+type DecryptRequestResponse struct {
+	ID                    string            `json:"id"`
+	TargetUserID          int               `json:"target_user_id"`
+	RequesterID           int               `json:"requester_id"`
+	Reason                string            `json:"reason"`
+	Status                string            `json:"status"` // pending, updating (decrypting), success, failed, cancelled or expired
+	Threshold             int               `json:"threshold"`
+	ApprovedIdentityNames []string          `json:"approved_identity_names"`
+	ExpiresAt             time.Time         `json:"expires_at"`
+	CreatedAt             time.Time         `json:"created_at"`
+	DecryptedAt           *time.Time        `json:"decrypted_at,omitempty"`
+	History               []models.AuditLog `json:"history,omitempty"` // only in GET /shamir/decrypt/requests/{id}
 }
 
 type DecryptedUserEmailResponse struct {
-	UserID        int      `json:"user_id"`
-	UserEmail     string   `json:"user_email" validate:"required,email"`
-	IdentityNames []string `json:"identity_names"`
+	UserID                    int      `json:"user_id"`
+	UserEmail                 string   `json:"user_email" validate:"required,email"`
+	IdentityNames             []string `json:"identity_names"`
+	InconsistentIdentityNames []string `json:"inconsistent_identity_names,omitempty"` // trustees whose shares disagree with the recovered email
+}
+
+/* webhook */
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=1024"`
+	Secret     string   `json:"secret" validate:"required,min=16,max=256"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,oneof=* user.created user.deleted user.nickname_changed user.password_changed"`
+}
+
+type ModifyWebhookRequest struct {
+	URL        *string  `json:"url" validate:"omitempty,url,max=1024"`
+	Secret     *string  `json:"secret" validate:"omitempty,min=16,max=256"`
+	EventTypes []string `json:"event_types" validate:"omitempty,min=1,dive,oneof=* user.created user.deleted user.nickname_changed user.password_changed"`
+	IsActive   *bool    `json:"is_active"`
+}
+
+type ListWebhookDeliveriesRequest struct {
+	Status string `json:"status" query:"status" validate:"omitempty,oneof=pending success failed"`
+	Offset int    `json:"offset" query:"offset" validate:"min=0"`
+	Size   int    `json:"size" query:"size" default:"30" validate:"min=1,max=100"`
+}
+
+/* email */
+
+type EmailBlocklistRequest struct {
+	Entries []string `json:"entries" validate:"required,min=1,dive,required,max=256"`
+}
+
+type ListEmailsRequest struct {
+	Status string `json:"status" query:"status" default:"failed" validate:"oneof=pending sending sent failed"`
+	Offset int    `json:"offset" query:"offset" validate:"min=0"`
+	Size   int    `json:"size" query:"size" default:"30" validate:"min=1,max=100"`
+}
+
+type IdentifierStatusResponse struct {
+	// 新 identifier 使用的 salt 版本
+	CurrentVersion int `json:"current_version"`
+
+	// 所有可用的 salt 版本，当前版本在最前面
+	Versions []int `json:"versions"`
+
+	// 每个版本的用户数量，0 为已停用或无法识别的版本
+	Users map[int]int64 `json:"users"`
+
+	// 每个版本的已注销 identifier 数量，这些 identifier 无法迁移，停用版本后对应的邮箱可以重新注册
+	DeletedIdentifiers map[int]int64 `json:"deleted_identifiers"`
+
+	// 使用当前版本的用户比例
+	Progress float64 `json:"progress"`
+}
+
+type ElevateRequest struct {
+	Method string `json:"method" validate:"required,oneof=password email totp" enums:"password,email,totp"`
+
+	// method 为 password 时必填
+	Password string `json:"password" validate:"required_if=Method password"`
+
+	// method 为 email 时必填，验证码通过 GET /verify/email?scope=elevate 获取
+	Email        string           `json:"email" validate:"required_if=Method email,omitempty,email"`
+	Verification VerificationType `json:"verification" swaggertype:"string" validate:"required_if=Method email"`
 }
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/go-playground/validator/v10"
@@ -21,6 +23,7 @@ import (
 	"auth_next/config"
 	. "auth_next/models"
 	"auth_next/utils"
+	"auth_next/utils/i18n"
 	"auth_next/utils/shamir"
 )
 
@@ -51,7 +54,7 @@ func GetPGPMessageByUserID(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can get pgp message")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
 	// get target user id
@@ -66,7 +69,7 @@ func GetPGPMessageByUserID(c *fiber.Ctx) error {
 	// get related pgp message key
 	var key string
 	result := DB.Model(&ShamirEmail{}).Select("key").
-		Where("encrypted_by = ? AND user_id = ?", query.IdentityName, targetUserID).
+		Where("key_set_id = ? AND encrypted_by = ? AND user_id = ? AND carried_from_key_set_id = 0", CurrentShamirKeySet().ID, query.IdentityName, targetUserID).
 		Take(&key)
 	// DB.Take raise error when take nothing
 	if result.Error != nil {
@@ -109,19 +112,19 @@ func ListPGPMessages(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can get pgp message")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
 	// list pgp messages
 	messages := make([]PGPMessageResponse, 0, 10)
 	result := DB.Table("shamir_email").Order("user_id asc").
-		Where("encrypted_by = ?", query.IdentityName).
+		Where("key_set_id = ? AND encrypted_by = ? AND carried_from_key_set_id = 0", CurrentShamirKeySet().ID, query.IdentityName).
 		Find(&messages)
 	if result.Error != nil {
 		return result.Error
 	}
 	if len(messages) == 0 {
-		return c.Status(404).JSON(common.Message("获取Shamir信息失败"))
+		return i18n.NotFound(i18n.CodeShamirInfoNotFound)
 	}
 
 	// log
@@ -130,9 +133,102 @@ func ListPGPMessages(c *fiber.Ctx) error {
 	return c.JSON(messages)
 }
 
+// getShamirSession 获取进行中的会话，create 为 true 时不存在则以当前用户为所有者创建；
+// 请求中指定了 session_id 时，必须与进行中的会话一致
+func getShamirSession(sessionType string, targetUserID int, sessionID string, create bool, userID int) (*ShamirSession, error) {
+	var session *ShamirSession
+	var err error
+	if create {
+		session, err = GetOrCreateActiveShamirSession(sessionType, targetUserID, userID)
+	} else {
+		session, err = GetActiveShamirSession(sessionType, targetUserID)
+	}
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionNotFound) {
+			return nil, i18n.NotFound(i18n.CodeShamirSessionNotFound)
+		}
+		return nil, err
+	}
+	if sessionID != "" && sessionID != session.ID {
+		return nil, i18n.NotFound(i18n.CodeShamirSessionNotFound)
+	}
+	return session, nil
+}
+
+// newShamirStatusResponse 进行中的会话不存在时，返回最近一次会话的结果
+func newShamirStatusResponse(session *ShamirSession) (*ShamirStatusResponse, error) {
+	var err error
+	if session == nil {
+		session, err = GetLatestShamirSession(ShamirSessionTypeUpdate, 0)
+		if err != nil && !errors.Is(err, ErrShamirSessionNotFound) {
+			return nil, err
+		}
+	}
+
+	keySet, publicKeys := CurrentShamirKeys()
+	status := &ShamirStatusResponse{
+		UploadedSharesIdentityNames: []string{},
+		UploadedShares:              []ShamirSharesBatch{},
+		CurrentPublicKeys:           publicKeys,
+		NewPublicKeys:               []ShamirPublicKey{},
+	}
+	status.setKeySet(keySet)
+	if session == nil {
+		return status, nil
+	}
+
+	status.SessionID = session.ID
+	status.SessionStatus = session.Status
+	status.OwnerID = session.OwnerID
+	status.ExpiresAt = &session.ExpiresAt
+	status.NowUserID = session.NowUserID
+	status.NewKeySetID = session.NewKeySetID
+	status.CheckpointUserID = session.CheckpointUserID
+	status.FailMessage = session.FailMessage
+	status.WarningMessage = session.WarningMessage
+	if !session.Active() {
+		return status, nil
+	}
+
+	status.ShamirUpdating = session.Status == ShamirSessionUpdating
+	status.Stalled = session.Stalled()
+	status.UploadedSharesIdentityNames, err = session.IdentityNames()
+	if err != nil {
+		return nil, err
+	}
+	status.UploadedShares, err = session.UploadedBatches()
+	if err != nil {
+		return nil, err
+	}
+	if session.NewPublicKeys != nil {
+		status.NewPublicKeys = session.NewPublicKeys
+	}
+	status.ShamirUpdateReady = session.Status == ShamirSessionPending && status.updateReady()
+	return status, nil
+}
+
+// setKeySet 更新当前和新 key set 的公钥数量与门限
+func (status *ShamirStatusResponse) setKeySet(keySet ShamirKeySet) {
+	status.KeySetID = keySet.ID
+	status.KeyCount = keySet.KeyCount
+	status.Threshold = keySet.Threshold
+	status.NewKeyCount = config.Config.ShamirKeyCount
+	status.NewThreshold = config.Config.ShamirThreshold
+}
+
+// updateReady 已上传足够解密当前邮箱的坐标点，且已上传全部新公钥
+func (status *ShamirStatusResponse) updateReady() bool {
+	return len(status.UploadedSharesIdentityNames) >= status.Threshold &&
+		len(status.NewPublicKeys) == config.Config.ShamirKeyCount
+}
+
 // UploadAllShares godoc
 //
 // @Summary upload all shares of all users, cached
+// @Description shares are saved in the current update session, encrypted with SHAMIR_SESSION_KEY.
+// @Description Large uploads can be split into chunks numbered from 0, set more=true on all chunks but the last;
+// @Description the identity counts as uploaded after the last chunk. Chunks are sorted by user ID: every user ID of a chunk
+// @Description must be larger than those of the previous chunks.
 // @Tags shamir
 // @Produce json
 // @Router /shamir/shares [post]
@@ -140,7 +236,8 @@ func ListPGPMessages(c *fiber.Ctx) error {
 // @Success 200 {object} common.MessageResponse{data=IdentityNameResponse}
 // @Success 201 {object} common.MessageResponse{data=IdentityNameResponse}
 // @Failure 400 {object} common.MessageResponse
-// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 403 {object} common.MessageResponse "非管理员或需要二次验证"
+// @Failure 404 {object} common.MessageResponse "会话不存在"
 // @Failure 500 {object} common.MessageResponse
 func UploadAllShares(c *fiber.Ctx) error {
 	// get shares
@@ -157,39 +254,70 @@ func UploadAllShares(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can upload shares")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
-	// lock
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
-	status := &GlobalUploadShamirStatus
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
 
-	if status.ShamirUpdating {
-		return common.BadRequest("正在重新加解密，请不要上传")
+	session, err := getShamirSession(ShamirSessionTypeUpdate, 0, body.SessionID, true, userID)
+	if err != nil {
+		return err
 	}
 
-	if utils.InUnorderedSlice(status.UploadedSharesIdentityNames, body.IdentityName) {
-		return common.BadRequest("您已经上传过，请不要重复上传")
+	if session.Status == ShamirSessionUpdating {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
 	}
-	status.UploadedSharesIdentityNames = append(status.UploadedSharesIdentityNames, body.IdentityName)
 
-	// save shares
+	// verify and save shares
+	shares := make(map[int]shamir.Share, len(body.Shares))
 	for _, userShare := range body.Shares {
-		userID := userShare.UserID
-		// also correct if status.UploadedShares[userID] = nil
-		status.UploadedShares[userID] = append(status.UploadedShares[userID], userShare.Share)
+		shares[userShare.UserID] = userShare.Share
+	}
+	defer func() {
+		for _, userShare := range body.Shares {
+			userShare.Share.Zero()
+		}
+	}()
+	err = verifyShamirShares(session, body.IdentityName, shares)
+	if err != nil {
+		return err
+	}
+	err = session.AddSharesChunk(body.IdentityName, userID, body.Chunk, !body.More, shares)
+	if err != nil {
+		if errors.Is(err, ErrShamirSharesUploaded) {
+			return i18n.BadRequest(i18n.CodeShamirAlreadyUploaded)
+		}
+		var outOfOrder *ErrShamirChunkOutOfOrder
+		if errors.As(err, &outOfOrder) {
+			return i18n.BadRequest(i18n.CodeShamirChunkOutOfOrder, outOfOrder.Next)
+		}
+		var userOrder *ErrShamirChunkUserOrder
+		if errors.As(err, &userOrder) {
+			return i18n.BadRequest(i18n.CodeShamirChunkUserOrder, userOrder.After)
+		}
+		return err
 	}
 
-	if len(status.UploadedSharesIdentityNames) >= 4 && len(status.NewPublicKeys) == 7 {
-		status.ShamirUpdateReady = true
+	identityNames, err := session.IdentityNames()
+	if err != nil {
+		return err
+	}
+	uploadedChunks, complete, err := session.UploadedChunks(body.IdentityName)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(common.MessageResponse{
 		Message: "上传成功",
 		Data: Map{
+			"session_id":         session.ID,
 			"identity_name":      body.IdentityName,
-			"now_updated_shares": GlobalUploadShamirStatus.UploadedSharesIdentityNames,
+			"uploaded_chunks":    uploadedChunks,
+			"complete":           complete,
+			"now_updated_shares": identityNames,
 		},
 	})
 }
@@ -201,9 +329,10 @@ func UploadAllShares(c *fiber.Ctx) error {
 // @Produce json
 // @Router /shamir/key [post]
 // @Param public_keys body UploadPublicKeyRequest true "public keys"
-// @Success 200 {array} common.MessageResponse{data=IdentityNameResponse}
+// @Success 200 {array} common.MessageResponse{data=ShamirStatusResponse}
 // @Failure 400 {object} common.MessageResponse
 // @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 404 {object} common.MessageResponse "会话不存在"
 // @Failure 500 {object} common.MessageResponse
 func UploadPublicKey(c *fiber.Ctx) error {
 	var body UploadPublicKeyRequest
@@ -219,48 +348,69 @@ func UploadPublicKey(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can upload public keys")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
-	status := &GlobalUploadShamirStatus
+	if len(body.Data) != config.Config.ShamirKeyCount {
+		return i18n.BadRequest(i18n.CodeShamirPublicKeyCount, config.Config.ShamirKeyCount)
+	}
 
-	// save public keys
-	status.NewPublicKeys = nil
+	// parse public keys
+	newPublicKeys := make([]ShamirPublicKey, 0, len(body.Data))
 	for i, armoredPublicKey := range body.Data {
 		// try parse
 		publicKey, err := crypto.NewKeyFromArmored(armoredPublicKey)
 		if err != nil {
-			return common.BadRequest(fmt.Sprintf("load public key error: %v\n", armoredPublicKey))
-		}
-		publicKeyRing, err := crypto.NewKeyRing(publicKey)
-		if err != nil {
-			return common.BadRequest(fmt.Sprintf("load public key error: %v\n", armoredPublicKey))
+			return i18n.BadRequest(i18n.CodeShamirPublicKeyInvalid, err)
 		}
 
 		// save new public keys with assigned id, for save to database
-		status.NewPublicKeys = append(status.NewPublicKeys, ShamirPublicKey{
+		newPublicKeys = append(newPublicKeys, ShamirPublicKey{
 			ID:               i + 1,
 			IdentityName:     publicKey.GetEntity().PrimaryIdentity().Name,
 			ArmoredPublicKey: armoredPublicKey,
-			PublicKey:        publicKeyRing,
 		})
 	}
+	err = ParseShamirPublicKeys(newPublicKeys)
+	if err != nil {
+		return i18n.BadRequest(i18n.CodeShamirPublicKeyInvalid, err)
+	}
+	if config.Config.Mode == "production" {
+		err = CheckShamirDemoKeys(newPublicKeys)
+		if err != nil {
+			return i18n.BadRequest(i18n.CodeShamirPublicKeyInvalid, err)
+		}
+	}
 
-	if len(status.UploadedSharesIdentityNames) >= 4 && len(status.NewPublicKeys) == 7 {
-		status.ShamirUpdateReady = true
+	session, err := getShamirSession(ShamirSessionTypeUpdate, 0, body.SessionID, true, userID)
+	if err != nil {
+		return err
+	}
+
+	if session.Status == ShamirSessionUpdating {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
+	}
+
+	err = session.SaveNewPublicKeys(newPublicKeys)
+	if err != nil {
+		return err
+	}
+
+	status, err := newShamirStatusResponse(session)
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(common.MessageResponse{
 		Message: "上传公钥成功",
-		Data:    &status.ShamirStatusResponse,
+		Data:    status,
 	})
 }
 
 // GetShamirStatus godoc
 //
 // @Summary get shamir info
+// @Description status of the current update session, or the result of the last session
 // @Tags shamir
 // @Produce json
 // @Router /shamir/status [get]
@@ -276,24 +426,33 @@ func GetShamirStatus(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can get shamir status")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	session, err := GetActiveShamirSession(ShamirSessionTypeUpdate, 0)
+	if err != nil && !errors.Is(err, ErrShamirSessionNotFound) {
+		return err
 	}
 
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
+	status, err := newShamirStatusResponse(session)
+	if err != nil {
+		return err
+	}
 
-	return c.JSON(GlobalUploadShamirStatus.ShamirStatusResponse)
+	return c.JSON(status)
 }
 
 // UpdateShamir godoc
 //
 // @Summary trigger for updating shamir
+// @Description re-encryption runs in batches and saves progress, trigger again to resume a stalled update
 // @Tags shamir
 // @Produce json
 // @Router /shamir/update [post]
 // @Success 200 {object} common.MessageResponse
 // @Failure 400 {object} common.MessageResponse
 // @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 404 {object} common.MessageResponse "会话不存在"
 // @Failure 500 {object} common.MessageResponse
 func UpdateShamir(c *fiber.Ctx) error {
 	// identify shamir admin
@@ -303,34 +462,63 @@ func UpdateShamir(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can update shamir")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
-	status := &GlobalUploadShamirStatus
+	session, err := getShamirSession(ShamirSessionTypeUpdate, 0, c.Query("session_id"), false, userID)
+	if err != nil {
+		return err
+	}
+
+	if session.Status == ShamirSessionUpdating {
+		// resume from the checkpoint if the instance running the update was interrupted
+		if !session.Stalled() {
+			return i18n.BadRequest(i18n.CodeShamirUpdating)
+		}
+		ok, err := session.TakeOver()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return i18n.BadRequest(i18n.CodeShamirUpdating)
+		}
+
+		go updateShamir(session)
+		return c.JSON(common.Message(i18n.T(i18n.GetLocale(c), i18n.CodeShamirUpdateResumed)))
+	}
 
-	if status.ShamirUpdating {
-		return common.BadRequest("正在重新加解密，请不要重复触发")
+	status, err := newShamirStatusResponse(session)
+	if err != nil {
+		return err
 	}
 	if !status.ShamirUpdateReady {
-		if len(status.UploadedSharesIdentityNames) < 4 {
-			return common.BadRequest("坐标点数量不够，无法解密")
-		} else if len(status.NewPublicKeys) < 7 {
-			return common.BadRequest("公钥数量不够，无法重新加密")
+		if len(status.UploadedSharesIdentityNames) < status.Threshold {
+			return i18n.BadRequest(i18n.CodeShamirSharesNotEnough)
+		} else if len(status.NewPublicKeys) != config.Config.ShamirKeyCount {
+			return i18n.BadRequest(i18n.CodeShamirPublicKeysNotEnough)
 		} else {
-			return common.BadRequest("无法解密")
+			return i18n.BadRequest(i18n.CodeShamirDecryptFailed)
 		}
 	}
 
+	// only one instance can start the update
+	ok, err := session.StartUpdating()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
+	}
+
 	// trigger update
-	go updateShamir()
-	return c.JSON(common.Message("触发成功，正在尝试更新shamir信息，请访问/shamir/status获取更多信息"))
+	go updateShamir(session)
+	return c.JSON(common.Message(i18n.T(i18n.GetLocale(c), i18n.CodeShamirUpdateStarted)))
 }
 
 // RefreshShamir godoc
 //
 // @Summary trigger for refresh uploaded shares
+// @Description cancel the current update session and delete uploaded shares
 // @Tags shamir
 // @Router /shamir/refresh [put]
 // @Router /shamir/refresh/_webvpn [patch]
@@ -345,248 +533,378 @@ func RefreshShamir(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can refresh shamir")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
-	status := &GlobalUploadShamirStatus
-
-	if status.ShamirUpdating {
-		return common.BadRequest("正在重新加解密，请不要触发刷新")
+	session, err := GetActiveShamirSession(ShamirSessionTypeUpdate, 0)
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionNotFound) {
+			return c.SendStatus(204)
+		}
+		return err
 	}
 
-	status.UploadedSharesIdentityNames = []string{}
-	status.UploadedShares = make(map[int]shamir.Shares, 0)
+	if session.Status == ShamirSessionUpdating {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
+	}
 
-	status.ShamirUpdateReady = false
-	status.ShamirUpdating = false
+	err = session.Finish(ShamirSessionCancelled)
+	if errors.Is(err, ErrShamirSessionSuperseded) {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
+	}
+	if err != nil {
+		return err
+	}
 
+	log.Info().Str("session_id", session.ID).Int("user_id", userID).Msg("shamir session cancelled")
 	return c.SendStatus(204)
 }
 
+// shamirUpdateBatchSize 重新加密时每批处理的用户数，每批在一个事务中写入并保存进度
+const shamirUpdateBatchSize = 500
+
 // only background running in goroutine
-func updateShamir() {
-	var err error
+//
+// 新的 shamir_email 按批写入新的 key set，与当前 key set 的数据并存；
+// 每批写入后保存进度，实例中断后可以从进度继续；全部完成后切换 active key set，再分批删除旧数据
+func updateShamir(session *ShamirSession) {
 	const taskScope = "shamir update"
 
 	defer func() {
 		panicErr := recover()
 		if panicErr != nil {
-			GlobalUploadShamirStatus.Lock()
-			defer GlobalUploadShamirStatus.Unlock()
-			status := &GlobalUploadShamirStatus
-
-			status.FailMessage = fmt.Sprintf("recover from panic: %v", panicErr)
+			session.FailMessage = fmt.Sprintf("recover from panic: %v", panicErr)
+			err := session.Finish(ShamirSessionFailed)
+			if errors.Is(err, ErrShamirSessionSuperseded) {
+				// the new key set is used by the instance which took over
+				return
+			}
+			if err != nil {
+				log.Err(err).Str("scope", taskScope).Msg("finish shamir session failed")
+			}
+			err = session.DiscardNewKeySet()
+			if err != nil {
+				log.Err(err).Str("scope", taskScope).Msg("discard shamir key set failed")
+			}
 		}
 	}()
 
-	// prepare
-	GlobalUploadShamirStatus.Lock()
-
-	// shamir updating status
-	GlobalUploadShamirStatus.ShamirUpdating = true
-
-	// backup old public keys
-	oldShamirPublicKey := ShamirPublicKeys
-
-	// copy new public keys
-	ShamirPublicKeys = GlobalUploadShamirStatus.NewPublicKeys
-	GlobalUploadShamirStatus.CurrentPublicKeys = ShamirPublicKeys
-
-	// all the shares for decrypt
-	allShares := GlobalUploadShamirStatus.UploadedShares
-
-	if len(allShares) == 0 {
-		log.Error().Str("scope", taskScope).Msg("no shares uploaded")
-		GlobalUploadShamirStatus.Unlock()
-		return
-	}
-
-	// get all userID
-	userIDs := make([]int, 0, len(allShares))
-	for userID := range allShares {
-		userIDs = append(userIDs, userID)
-	}
-	slices.Sort(userIDs)
-
-	GlobalUploadShamirStatus.Unlock()
-
 	var warningMessage strings.Builder
+	warningMessage.WriteString(session.WarningMessage)
 
-	const shamirTableName = "shamir_email"
-
-	shamirEmails := make([]ShamirEmail, 0, len(ShamirPublicKeys)*len(userIDs))
-
-	err = func() (err error) {
-		// concurrently compute
-		taskChan := make(chan func(), 100)
-		errChan := make(chan error)
-		warningMessageChan := make(chan string, 1000)
-		shamirEmailResultChan := make(chan []ShamirEmail, 100)
-		defer func() {
-			close(taskChan)
-			close(errChan)
-			close(warningMessageChan)
-			close(shamirEmailResultChan)
-		}()
-
-		// task executor
-		for i := 0; i < runtime.NumCPU(); i++ {
-			go func() {
-				for task := range taskChan {
-					task()
-				}
-			}()
-		}
-
-		// task sender
-		go func() {
-
-			// main loop
-			for _, userID := range userIDs {
+	var newKeySet *ShamirKeySet
+	var newPublicKeys []ShamirPublicKey
+	var retiredKeySets []ShamirKeySet
 
-				userID := userID
-				// get shares
-				shares := allShares[userID]
-				if len(shares) < 4 {
-					warningMessageChan <- fmt.Sprintf("user %v don't have enough shares\n", userID)
-					continue
-				}
-
-				taskChan <- func() {
-
-					// decrypt email
-					email := shamir.Decrypt(shares)
-					if !utils.ValidateEmail(email) {
-						if !utils.IsEmail(email) {
-							// decrypt error
-							errChan <- fmt.Errorf("[email decrypt error] invalid email, user_id = %d, email: %v", userID, email)
-							return
-						} else {
-							// filter invalid emails
-							warningMessageChan <- fmt.Sprintf("user %v don't have valid email: %v\n", userID, email)
-							return
-						}
-					}
-
-					// generate shamir emails
-					var innerShamirEmails []ShamirEmail
-					innerShamirEmails, err = GenerateShamirEmails(userID, email)
-					if err != nil {
-						errChan <- err
-						return
-					}
-
-					shamirEmailResultChan <- innerShamirEmails
-				}
+	err := func() (err error) {
+		if session.NewKeySetID != 0 {
+			// resume with the key set created before interruption
+			newKeySet, err = GetShamirKeySet(session.NewKeySetID)
+			if err != nil {
+				return err
 			}
-		}()
-
-		// receive task results
-		taskCount := 0
-		for range userIDs {
-			select {
-			case err = <-errChan:
+			if newKeySet.Status != ShamirKeySetBuilding {
+				return fmt.Errorf("key set %d is %s, cannot resume", newKeySet.ID, newKeySet.Status)
+			}
+			newPublicKeys, err = LoadShamirKeySetPublicKeys(newKeySet)
+			if err != nil {
 				return err
-			case innerWarningMessage := <-warningMessageChan:
-				warningMessage.WriteString(innerWarningMessage)
-			case innerShamirEmails := <-shamirEmailResultChan:
-				shamirEmails = append(shamirEmails, innerShamirEmails...)
 			}
-			taskCount++
-			if taskCount%1000 == 0 {
-				log.Info().Str("scope", taskScope).Msgf("processed %v users", taskCount)
+		} else {
+			err = session.LoadNewPublicKeys()
+			if err != nil {
+				return err
 			}
-			GlobalUploadShamirStatus.Lock()
-			GlobalUploadShamirStatus.NowUserID = taskCount
-			GlobalUploadShamirStatus.Unlock()
-		}
-
-		return DB.Session(&gorm.Session{
-			Logger:            DB.Logger.LogMode(logger.Warn),
-			NewDB:             true,
-			AllowGlobalUpdate: true,
-			CreateBatchSize:   1000,
-		}).Transaction(func(tx *gorm.DB) error {
-
-			// delete old table
-			if tx.Dialector.Name() == "sqlite" {
-				//goland:noinspection SqlWithoutWhere
-				err = tx.Exec(`DELETE FROM ` + shamirTableName).Error
-			} else {
-				err = tx.Exec(`TRUNCATE ` + shamirTableName).Error
+
+			// users registered from now on are also encrypted with the new key set
+			newKeySet, newPublicKeys, err = CreateBuildingShamirKeySet(session.NewPublicKeys, config.Config.ShamirThreshold)
+			if err != nil {
+				return err
 			}
+			err = session.SaveNewKeySet(newKeySet.ID)
 			if err != nil {
 				return err
 			}
+		}
+
+		// all the shares for decrypt
+		allShares, err := session.LoadShares()
+		if err != nil {
+			return err
+		}
+		defer ZeroShamirUserShares(allShares)
+
+		if len(allShares) == 0 {
+			return errors.New("no shares uploaded")
+		}
+
+		// check all users before re-encrypting anything, users without any share are carried forward on switch
+		oldThreshold, err := sessionKeySetThreshold(session)
+		if err != nil {
+			return err
+		}
+		err = checkShamirSharesComplete(allShares, oldThreshold)
+		if err != nil {
+			return err
+		}
+
+		// users not processed yet, in ascending order
+		userIDs := make([]int, 0, len(allShares))
+		for userID := range allShares {
+			if userID > session.CheckpointUserID {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		slices.Sort(userIDs)
+
+		db := DB.Session(&gorm.Session{
+			Logger:          DB.Logger.LogMode(logger.Warn),
+			NewDB:           true,
+			CreateBatchSize: 1000,
+		})
+
+		for start := 0; start < len(userIDs); start += shamirUpdateBatchSize {
+			end := start + shamirUpdateBatchSize
+			if end > len(userIDs) {
+				end = len(userIDs)
+			}
+			batch := userIDs[start:end]
 
-			// insert new shamir emails
-			err = tx.Create(shamirEmails).Error
+			shamirEmails, commitments, err := reencryptShamirEmails(newKeySet, newPublicKeys, oldThreshold, batch, allShares, &warningMessage)
 			if err != nil {
 				return err
 			}
 
-			// save new public keys
-			err = tx.Save(ShamirPublicKeys).Error
+			err = db.Transaction(func(tx *gorm.DB) error {
+				err := ReplaceShamirEmails(tx, newKeySet.ID, batch, shamirEmails, commitments)
+				if err != nil {
+					return err
+				}
+				return session.SaveCheckpoint(tx, batch[len(batch)-1], session.NowUserID+len(batch), warningMessage.String())
+			})
 			if err != nil {
 				return err
 			}
+			log.Info().Str("scope", taskScope).Int("checkpoint_user_id", session.CheckpointUserID).Msgf("processed %v users", session.NowUserID)
+		}
 
-			return nil
-		})
+		// switch to the new key set atomically
+		retiredKeySets, err = session.ActivateNewKeySet(newKeySet)
+		return err
 	}()
 
-	GlobalUploadShamirStatus.Lock()
-	defer GlobalUploadShamirStatus.Unlock()
-	status := &GlobalUploadShamirStatus
-
-	status.ShamirUpdating = false
-	status.ShamirUpdateReady = false
-	status.WarningMessage = warningMessage.String()
-	for userID := range status.UploadedShares {
-		delete(status.UploadedShares, userID)
+	if errors.Is(err, ErrShamirSessionSuperseded) {
+		// another instance took over the stalled update, leave the session and the new key set to it
+		log.Warn().Err(err).Str("scope", taskScope).Str("session_id", session.ID).Msg("shamir update stopped")
+		return
 	}
-	status.UploadedSharesIdentityNames = []string{}
-	status.NewPublicKeys = []ShamirPublicKey{}
-	status.NowUserID = 0
 
-	var subject string
-	var content []byte
+	session.WarningMessage = warningMessage.String()
 
+	var subject string
+	var sessionStatus string
 	if err != nil {
-		// rollback
-		status.FailMessage = err.Error()
-		status.NewPublicKeys = ShamirPublicKeys
-		status.CurrentPublicKeys = oldShamirPublicKey
-		ShamirPublicKeys = oldShamirPublicKey
-
+		session.FailMessage = err.Error()
+		sessionStatus = ShamirSessionFailed
 		subject = "shamir update failed"
 	} else {
+		// other instances load new public keys in ShamirPublicKeyTask
+		SetCurrentShamirKeys(*newKeySet, newPublicKeys)
+		sessionStatus = ShamirSessionSuccess
 		subject = "shamir update success"
 	}
 
-	content, _ = json.Marshal(&status)
+	err = session.Finish(sessionStatus)
+	if errors.Is(err, ErrShamirSessionSuperseded) {
+		log.Warn().Err(err).Str("scope", taskScope).Str("session_id", session.ID).Msg("shamir update stopped")
+		return
+	}
+	if err != nil {
+		log.Err(err).Str("scope", taskScope).Msg("finish shamir session failed")
+	}
+
+	if sessionStatus == ShamirSessionFailed {
+		err = session.DiscardNewKeySet()
+		if err != nil {
+			log.Err(err).Str("scope", taskScope).Msg("discard shamir key set failed")
+		}
+	}
+
+	// delete shamir emails of the old key sets, remaining rows are not used anymore
+	for _, keySet := range retiredKeySets {
+		err = PurgeShamirKeySet(keySet.ID, ShamirKeySetRetired)
+		if err != nil {
+			log.Err(err).Str("scope", taskScope).Int("key_set_id", keySet.ID).Msg("purge retired shamir key set failed")
+		}
+	}
+
+	status, err := newShamirStatusResponse(session)
+	if err != nil {
+		log.Err(err).Str("scope", taskScope).Msg("get shamir status failed")
+		return
+	}
+	content, _ := json.Marshal(status)
 
 	// send email to update
-	err = utils.SendEmail(subject, string(content), []string{config.Config.EmailDev})
+	_, err = EnqueueEmail(DB, EmailPurposeShamirUpdate, subject, string(content), "", []string{config.Config.EmailDev})
 	if err != nil {
-		log.Warn().Err(err).Str("scope", taskScope).Str("subject", subject).Msg("sending email failed")
+		log.Warn().Err(err).Str("scope", taskScope).Str("subject", subject).Msg("enqueue email failed")
 	}
 
 	log.Info().Str("scope", taskScope).Msg("updateShamir function finished")
 }
 
+// checkShamirSharesComplete 有坐标点的用户都需要达到门限，否则返回列出这些用户的错误
+func checkShamirSharesComplete(allShares map[int]*ShamirUserShares, threshold int) error {
+	incomplete := make([]int, 0)
+	for userID, userShares := range allShares {
+		if len(userShares.Shares) < threshold {
+			incomplete = append(incomplete, userID)
+		}
+	}
+	if len(incomplete) == 0 {
+		return nil
+	}
+	slices.Sort(incomplete)
+	return fmt.Errorf("%d users don't have enough shares, %d required, user IDs: %v", len(incomplete), threshold, firstUserIDs(incomplete))
+}
+
+// reencryptShamirEmails 并发解密一批用户的邮箱，并使用新的 key set 重新加密，坐标点需已通过 checkShamirSharesComplete；
+// 已有的邮箱即使不符合当前的白名单也重新加密，只写入 warningMessage
+func reencryptShamirEmails(
+	keySet *ShamirKeySet,
+	publicKeys []ShamirPublicKey,
+	oldThreshold int,
+	userIDs []int,
+	allShares map[int]*ShamirUserShares,
+	warningMessage *strings.Builder,
+) ([]ShamirEmail, []ShamirCommitment, error) {
+	type result struct {
+		shamirEmails []ShamirEmail
+		commitment   *ShamirCommitment
+		warning      string
+		err          error
+	}
+
+	results := make([]result, len(userIDs))
+	userIndexChan := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range userIndexChan {
+				userID := userIDs[index]
+				userShares := allShares[userID]
+
+				// decrypt email, tolerating faulty shares
+				email, inconsistent, err := userShares.Decrypt(oldThreshold)
+				if err != nil {
+					results[index].err = fmt.Errorf("[email decrypt error] inconsistent shares, user_id = %d, identity_names: %v", userID, userShares.IdentityNames)
+					continue
+				}
+				if len(inconsistent) > 0 {
+					results[index].warning = fmt.Sprintf("user %v has inconsistent shares from %v\n", userID, inconsistent)
+				}
+				if !utils.IsEmail(email) {
+					// decrypt error
+					results[index].err = fmt.Errorf("[email decrypt error] invalid email, user_id = %d, email: %v", userID, email)
+					continue
+				}
+				if !utils.ValidateEmail(email) {
+					// the email is registered already, keep it decryptable
+					results[index].warning += fmt.Sprintf("user %v has an email not allowed now: %v\n", userID, email)
+				}
+
+				// generate shamir emails
+				results[index].shamirEmails, results[index].commitment, results[index].err = GenerateShamirEmailsWithKeys(keySet, publicKeys, userID, email)
+			}
+		}()
+	}
+	for index := range userIDs {
+		userIndexChan <- index
+	}
+	close(userIndexChan)
+	wg.Wait()
+
+	shamirEmails := make([]ShamirEmail, 0, len(publicKeys)*len(userIDs))
+	commitments := make([]ShamirCommitment, 0, len(userIDs))
+	for _, result := range results {
+		if result.err != nil {
+			return nil, nil, result.err
+		}
+		warningMessage.WriteString(result.warning)
+		shamirEmails = append(shamirEmails, result.shamirEmails...)
+		if result.commitment != nil {
+			commitments = append(commitments, *result.commitment)
+		}
+	}
+	return shamirEmails, commitments, nil
+}
+
+// maxInvalidSharesShown 坐标点验证失败时，错误信息中最多列出的用户数
+const maxInvalidSharesShown = 20
+
+// verifyShamirShares 逐个验证 identityName 上传的坐标点，不一致时返回指出该管理员的错误.
+// 信封格式的坐标点先检查用户、key set 和门限是否匹配，再使用承诺验证
+func verifyShamirShares(session *ShamirSession, identityName string, shares map[int]shamir.Share) error {
+	keySet, err := GetShamirKeySet(session.KeySetID)
+	if err != nil {
+		return err
+	}
+	mismatched := make([]int, 0)
+	for userID, share := range shares {
+		if share.Meta == nil {
+			continue
+		}
+		if share.Meta.UserID != userID || share.Meta.KeySetID != keySet.ID || share.Meta.Threshold != keySet.Threshold {
+			mismatched = append(mismatched, userID)
+		}
+	}
+	if len(mismatched) > 0 {
+		slices.Sort(mismatched)
+		return i18n.BadRequest(i18n.CodeShamirShareMismatch, identityName, len(mismatched), firstUserIDs(mismatched))
+	}
+
+	invalid, err := VerifyShamirShares(session.KeySetID, shares)
+	if err != nil {
+		return err
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	log.Warn().
+		Str("session_id", session.ID).
+		Str("identity_name", identityName).
+		Ints("user_ids", invalid).
+		Msg("shamir shares inconsistent with commitments")
+
+	return i18n.BadRequest(i18n.CodeShamirShareInvalid, identityName, len(invalid), firstUserIDs(invalid))
+}
+
+// firstUserIDs 错误信息中只列出前 maxInvalidSharesShown 个用户
+func firstUserIDs(userIDs []int) []int {
+	if len(userIDs) > maxInvalidSharesShown {
+		return userIDs[:maxInvalidSharesShown]
+	}
+	return userIDs
+}
+
 // UploadUserShares godoc
 //
 // @Summary upload shares of one user
+// @Description approve a decrypt request by uploading the share of the target user, session_id is the request ID.
+// @Description shares are saved in the request, encrypted with SHAMIR_SESSION_KEY.
+// @Description the requester can't approve their own request, and every admin approves at most once
 // @Tags shamir
 // @Produce json
 // @Router /shamir/decrypt [post]
 // @Param shares body UploadShareRequest true "shares"
 // @Success 200 {object} common.MessageResponse{data=IdentityNameResponse}
-// @Failure 400 {object} common.MessageResponse
-// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 400 {object} common.MessageResponse "已批准过"
+// @Failure 403 {object} common.MessageResponse "非管理员、申请人或需要二次验证"
+// @Failure 404 {object} common.MessageResponse "会话不存在"
 // @Failure 500 {object} common.MessageResponse
 func UploadUserShares(c *fiber.Ctx) error {
 	var body UploadShareRequest
@@ -602,32 +920,66 @@ func UploadUserShares(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can upload user shares")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
-	GlobalUserSharesStatus.Lock()
-	defer GlobalUserSharesStatus.Unlock()
-	status := &GlobalUserSharesStatus
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
 
-	// save Identity Names for User
-	if utils.InUnorderedSlice(status.UploadedSharesIdentityNames[body.UserID], body.IdentityName) {
-		return common.BadRequest("您已经上传过，请不要重复上传")
+	// shares are bound to a pending decrypt request of the user
+	session, err := getShamirSession(ShamirSessionTypeDecrypt, body.UserID, body.SessionID, false, userID)
+	if err != nil {
+		return err
+	}
+	if session.Status == ShamirSessionUpdating {
+		return i18n.BadRequest(i18n.CodeShamirUpdating)
 	}
-	status.UploadedSharesIdentityNames[body.UserID] = append(status.UploadedSharesIdentityNames[body.UserID], body.IdentityName)
 
-	// save shares
-	status.UploadedShares[body.UserID] = append(status.UploadedShares[body.UserID], body.Share)
+	// verify and save shares
+	shares := map[int]shamir.Share{body.UserID: body.Share}
+	defer body.Share.Zero()
+	err = verifyShamirShares(session, body.IdentityName, shares)
+	if err != nil {
+		return err
+	}
+	err = session.AddShares(body.IdentityName, userID, shares)
+	if err != nil {
+		if errors.Is(err, ErrShamirSharesUploaded) {
+			return i18n.BadRequest(i18n.CodeShamirAlreadyUploaded)
+		}
+		if errors.Is(err, ErrShamirRequesterApproval) {
+			return i18n.Forbidden(i18n.CodeShamirRequesterApproval)
+		}
+		if errors.Is(err, ErrShamirAlreadyApproved) {
+			return i18n.BadRequest(i18n.CodeShamirAlreadyApproved)
+		}
+		return err
+	}
+	err = CreateAuditLog(nil, &AuditLog{
+		ActorID:      userID,
+		Action:       AuditShamirDecryptApprove,
+		ResourceID:   session.ID,
+		TargetUserID: body.UserID,
+		Detail:       Map{"identity_name": body.IdentityName},
+	})
+	if err != nil {
+		return err
+	}
 
-	if len(status.UploadedSharesIdentityNames[body.UserID]) >= 4 {
-		status.ShamirUploadReady[body.UserID] = true
+	identityNames, err := session.IdentityNames()
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(common.MessageResponse{
 		Message: "上传成功",
 		Data: Map{
+			"session_id":         session.ID,
 			"identity_name":      body.IdentityName,
 			"user_id":            body.UserID,
-			"now_updated_shares": status.UploadedSharesIdentityNames[body.UserID],
+			"now_updated_shares": identityNames,
 		},
 	})
 }
@@ -635,13 +987,16 @@ func UploadUserShares(c *fiber.Ctx) error {
 // GetDecryptedUserEmail godoc
 //
 // @Summary get decrypted email of one user
+// @Description only the requester of the pending decrypt request can get the email, exactly once;
+// @Description the request is finished and uploaded shares are deleted after decryption
+// @Description faulty shares are tolerated if enough shares agree, their uploaders are listed in inconsistent_identity_names
 // @Tags shamir
 // @Produce json
 // @Router /shamir/decrypt/{user_id} [get]
 // @Param user_id path int true "Target UserID"
 // @Success 200 {object} DecryptedUserEmailResponse
 // @Failure 400 {object} common.MessageResponse
-// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 403 {object} common.MessageResponse "非管理员、非申请人或需要二次验证"
 // @Failure 500 {object} common.MessageResponse
 func GetDecryptedUserEmail(c *fiber.Ctx) error {
 	// identify shamir admin
@@ -651,7 +1006,12 @@ func GetDecryptedUserEmail(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can decrypt email")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
 	}
 
 	// get target user id
@@ -663,41 +1023,118 @@ func GetDecryptedUserEmail(c *fiber.Ctx) error {
 		return errors.New("user_id at least 1")
 	}
 
-	GlobalUserSharesStatus.Lock()
-	defer GlobalUserSharesStatus.Unlock()
-	status := &GlobalUserSharesStatus
-
-	if !status.ShamirUploadReady[targetUserID] {
-		if len(status.UploadedSharesIdentityNames[targetUserID]) < 4 {
-			return common.BadRequest("坐标点数量不够，无法解密")
-		} else {
-			return common.BadRequest("无法解密")
+	session, err := GetActiveShamirSession(ShamirSessionTypeDecrypt, targetUserID)
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionNotFound) {
+			latest, latestErr := GetLatestShamirSession(ShamirSessionTypeDecrypt, targetUserID)
+			if latestErr == nil && latest.DecryptedAt != nil {
+				return i18n.BadRequest(i18n.CodeShamirAlreadyDecrypted)
+			}
+			return i18n.BadRequest(i18n.CodeShamirSharesNotEnough)
 		}
+		return err
+	}
+	if session.OwnerID != userID {
+		return i18n.Forbidden(i18n.CodeShamirRequesterOnly)
 	}
 
-	email := shamir.Decrypt(status.UploadedShares[targetUserID])
-	identityName := status.UploadedSharesIdentityNames[targetUserID]
-
-	delete(status.UploadedShares, targetUserID)
-	delete(status.UploadedSharesIdentityNames, targetUserID)
-	status.ShamirUploadReady[targetUserID] = false
+	threshold, err := sessionKeySetThreshold(session)
+	if err != nil {
+		return err
+	}
+	identityNames, err := session.IdentityNames()
+	if err != nil {
+		return err
+	}
+	if len(identityNames) < threshold {
+		return i18n.BadRequest(i18n.CodeShamirSharesNotEnough)
+	}
 
-	response := DecryptedUserEmailResponse{
-		UserID:        targetUserID,
-		UserEmail:     email,
-		IdentityNames: identityName,
+	// claim the request, the email is decrypted only once
+	ok, err := session.StartUpdating()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return i18n.BadRequest(i18n.CodeShamirAlreadyDecrypted)
 	}
 
-	// validate email
-	validate := validator.New()
-	err = validate.Struct(response)
+	// from now on the request must be finished on every path, otherwise it stays updating and blocks the user
+	response, err := decryptRequestedEmail(session, targetUserID, threshold)
+	response.IdentityNames = identityNames
+	inconsistent := response.InconsistentIdentityNames
+
+	sessionStatus := ShamirSessionSuccess
+	detail := Map{
+		"identity_names":              identityNames,
+		"inconsistent_identity_names": inconsistent,
+	}
+	if err != nil {
+		sessionStatus = ShamirSessionFailed
+		detail["error"] = err.Error()
+	} else {
+		now := time.Now()
+		session.DecryptedAt = &now
+	}
+	detail["status"] = sessionStatus
+
+	// shares are used only once
+	finishErr := session.FinishWithAudit(sessionStatus, &AuditLog{
+		ActorID:      userID,
+		Action:       AuditShamirDecryptResult,
+		ResourceID:   session.ID,
+		TargetUserID: targetUserID,
+		Detail:       detail,
+	})
+	if finishErr != nil {
+		return finishErr
+	}
 	if err != nil {
-		return common.BadRequest("解密失败，请重新输入坐标点")
+		log.Warn().Err(err).Str("session_id", session.ID).Int("target_user_id", targetUserID).Msg("decrypt user email failed")
+		if errors.Is(err, errShamirDecryptFailed) {
+			return i18n.BadRequest(i18n.CodeShamirDecryptRetry)
+		}
+		return err
 	}
 
+	if len(inconsistent) > 0 {
+		log.Warn().
+			Str("session_id", session.ID).
+			Int("target_user_id", targetUserID).
+			Strs("identity_names", inconsistent).
+			Msg("inconsistent shamir shares")
+	}
+	log.Info().Str("session_id", session.ID).Int("user_id", userID).Int("target_user_id", targetUserID).Msg("user email decrypted")
 	return c.JSON(response)
 }
 
+var errShamirDecryptFailed = errors.New("decrypt failed")
+
+// decryptRequestedEmail 容忍错误的坐标点恢复目标用户的邮箱并验证，返回的结果总是非 nil；
+// 坐标点无法恢复出合法邮箱时返回 errShamirDecryptFailed
+func decryptRequestedEmail(session *ShamirSession, targetUserID, threshold int) (*DecryptedUserEmailResponse, error) {
+	response := &DecryptedUserEmailResponse{UserID: targetUserID}
+
+	allShares, err := session.LoadShares()
+	if err != nil {
+		return response, err
+	}
+	defer ZeroShamirUserShares(allShares)
+	userShares, ok := allShares[targetUserID]
+	if !ok {
+		return response, i18n.BadRequest(i18n.CodeShamirSharesNotEnough)
+	}
+
+	response.UserEmail, response.InconsistentIdentityNames, err = userShares.Decrypt(threshold)
+	if err == nil {
+		err = validator.New().StructPartial(response, "UserEmail")
+	}
+	if err != nil {
+		return response, fmt.Errorf("%w: %v", errShamirDecryptFailed, err)
+	}
+	return response, nil
+}
+
 // GetDecryptStatusbyUserID godoc
 //
 // @Summary get decrypt status by userID
@@ -716,7 +1153,7 @@ func GetDecryptStatusbyUserID(c *fiber.Ctx) error {
 	}
 
 	if !IsShamirAdmin(userID) {
-		return common.Forbidden("only shamir admin can get decrypt status")
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
 	}
 
 	// get target user id
@@ -728,11 +1165,37 @@ func GetDecryptStatusbyUserID(c *fiber.Ctx) error {
 		return errors.New("user_id at least 1")
 	}
 
-	GlobalUserSharesStatus.Lock()
-	defer GlobalUserSharesStatus.Unlock()
+	response := ShamirUserSharesResponse{
+		UploadedSharesIdentityNames: []string{},
+		UploadedShares:              []ShamirSharesBatch{},
+		Threshold:                   CurrentShamirKeySet().Threshold,
+	}
 
-	return c.JSON(ShamirUserSharesResponse{
-		ShamirUploadReady:           GlobalUserSharesStatus.ShamirUploadReady[targetUserID],
-		UploadedSharesIdentityNames: GlobalUserSharesStatus.UploadedSharesIdentityNames[targetUserID],
-	})
+	session, err := GetActiveShamirSession(ShamirSessionTypeDecrypt, targetUserID)
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionNotFound) {
+			return c.JSON(response)
+		}
+		return err
+	}
+
+	response.Threshold, err = sessionKeySetThreshold(session)
+	if err != nil {
+		return err
+	}
+	response.UploadedSharesIdentityNames, err = session.IdentityNames()
+	if err != nil {
+		return err
+	}
+	response.UploadedShares, err = session.UploadedBatches()
+	if err != nil {
+		return err
+	}
+	response.SessionID = session.ID
+	response.OwnerID = session.OwnerID
+	response.Reason = session.Reason
+	response.ExpiresAt = &session.ExpiresAt
+	response.ShamirUploadReady = len(response.UploadedSharesIdentityNames) >= response.Threshold
+
+	return c.JSON(response)
 }
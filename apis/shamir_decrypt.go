@@ -0,0 +1,264 @@
+package apis
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+
+	. "auth_next/models"
+	"auth_next/utils/i18n"
+)
+
+// maxDecryptRequestHistory 解密申请详情中最多返回的审计日志条数
+const maxDecryptRequestHistory = 100
+
+// CreateDecryptRequest godoc
+//
+// @Summary request to decrypt the email of one user
+// @Description a shamir admin files a request with a legal reason, trustees approve it by uploading shares
+// @Description to POST /shamir/decrypt with session_id set to the request ID; only the requester can get the email, once.
+// @Description the request expires after SHAMIR_SESSION_EXPIRES minutes
+// @Tags shamir
+// @Produce json
+// @Router /shamir/decrypt/requests [post]
+// @Param json body CreateDecryptRequestRequest true "json"
+// @Success 201 {object} DecryptRequestResponse
+// @Failure 400 {object} common.MessageResponse "已有进行中的申请"
+// @Failure 403 {object} common.MessageResponse "非管理员或需要二次验证"
+// @Failure 404 {object} common.MessageResponse "用户没有 shamir 信息"
+// @Failure 500 {object} common.MessageResponse
+func CreateDecryptRequest(c *fiber.Ctx) error {
+	var body CreateDecryptRequestRequest
+	err := common.ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsShamirAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = DB.Model(&ShamirEmail{}).
+		Where("key_set_id = ? AND user_id = ? AND carried_from_key_set_id = 0", CurrentShamirKeySet().ID, body.UserID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return i18n.NotFound(i18n.CodeShamirInfoNotFound)
+	}
+
+	session, err := CreateShamirDecryptRequest(body.UserID, userID, body.Reason)
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionExists) {
+			return i18n.BadRequest(i18n.CodeShamirRequestExists, body.UserID, session.ID)
+		}
+		return err
+	}
+
+	response, err := newDecryptRequestResponse(session)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// ListDecryptRequests godoc
+//
+// @Summary list decrypt requests
+// @Description newest first, filtered by target user and status
+// @Tags shamir
+// @Produce json
+// @Router /shamir/decrypt/requests [get]
+// @Param query query ListDecryptRequestsRequest false "query"
+// @Success 200 {array} DecryptRequestResponse
+// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 500 {object} common.MessageResponse
+func ListDecryptRequests(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsShamirAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	var query ListDecryptRequestsRequest
+	err = common.ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := ListShamirDecryptRequests(query.UserID, query.Status, query.Offset, query.Size)
+	if err != nil {
+		return err
+	}
+
+	responses := make([]*DecryptRequestResponse, 0, len(sessions))
+	for i := range sessions {
+		response, err := newDecryptRequestResponse(&sessions[i])
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
+	}
+	return c.JSON(responses)
+}
+
+// GetDecryptRequest godoc
+//
+// @Summary get a decrypt request and its history
+// @Tags shamir
+// @Produce json
+// @Router /shamir/decrypt/requests/{id} [get]
+// @Param id path string true "request ID"
+// @Success 200 {object} DecryptRequestResponse
+// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 404 {object} common.MessageResponse "申请不存在"
+// @Failure 500 {object} common.MessageResponse
+func GetDecryptRequest(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsShamirAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	session, err := getDecryptRequest(c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	response, err := newDecryptRequestResponse(session)
+	if err != nil {
+		return err
+	}
+	response.History, err = ListAuditLogs(AuditLog{ResourceID: session.ID}, 0, maxDecryptRequestHistory)
+	if err != nil {
+		return err
+	}
+	return c.JSON(response)
+}
+
+// CancelDecryptRequest godoc
+//
+// @Summary cancel a pending decrypt request
+// @Description any shamir admin can cancel a pending request, uploaded shares are deleted
+// @Tags shamir
+// @Produce json
+// @Router /shamir/decrypt/requests/{id} [delete]
+// @Param id path string true "request ID"
+// @Success 200 {object} DecryptRequestResponse
+// @Failure 403 {object} common.MessageResponse "非管理员或需要二次验证"
+// @Failure 404 {object} common.MessageResponse "申请不存在或已结束"
+// @Failure 500 {object} common.MessageResponse
+func CancelDecryptRequest(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsShamirAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeShamirAdminRequired)
+	}
+
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
+
+	session, err := getDecryptRequest(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if session.Status != ShamirSessionPending || !session.Active() {
+		return i18n.NotFound(i18n.CodeShamirSessionNotFound)
+	}
+
+	err = session.FinishWithAudit(ShamirSessionCancelled, &AuditLog{
+		ActorID:      userID,
+		Action:       AuditShamirDecryptCancel,
+		ResourceID:   session.ID,
+		TargetUserID: session.TargetUserID,
+	})
+	if errors.Is(err, ErrShamirSessionSuperseded) {
+		// an approver started decrypting at the same time
+		return i18n.BadRequest(i18n.CodeShamirAlreadyDecrypted)
+	}
+	if err != nil {
+		return err
+	}
+
+	response, err := newDecryptRequestResponse(session)
+	if err != nil {
+		return err
+	}
+	return c.JSON(response)
+}
+
+func getDecryptRequest(requestID string) (*ShamirSession, error) {
+	session, err := GetShamirSession(requestID)
+	if err != nil {
+		if errors.Is(err, ErrShamirSessionNotFound) {
+			return nil, i18n.NotFound(i18n.CodeShamirSessionNotFound)
+		}
+		return nil, err
+	}
+	if session.Type != ShamirSessionTypeDecrypt {
+		return nil, i18n.NotFound(i18n.CodeShamirSessionNotFound)
+	}
+	return session, nil
+}
+
+// sessionKeySetThreshold 会话创建时的 key set 的门限，解密申请进行中轮换公钥不影响申请
+func sessionKeySetThreshold(session *ShamirSession) (int, error) {
+	currentKeySet := CurrentShamirKeySet()
+	if session.KeySetID == 0 || session.KeySetID == currentKeySet.ID {
+		return currentKeySet.Threshold, nil
+	}
+	keySet, err := GetShamirKeySet(session.KeySetID)
+	if err != nil {
+		return 0, err
+	}
+	return keySet.Threshold, nil
+}
+
+func newDecryptRequestResponse(session *ShamirSession) (*DecryptRequestResponse, error) {
+	threshold, err := sessionKeySetThreshold(session)
+	if err != nil {
+		return nil, err
+	}
+
+	approved, err := session.ApprovedIdentityNames()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecryptRequestResponse{
+		ID:                    session.ID,
+		TargetUserID:          session.TargetUserID,
+		RequesterID:           session.OwnerID,
+		Reason:                session.Reason,
+		Status:                session.EffectiveStatus(),
+		Threshold:             threshold,
+		ApprovedIdentityNames: approved,
+		ExpiresAt:             session.ExpiresAt,
+		CreatedAt:             session.CreatedAt,
+		DecryptedAt:           session.DecryptedAt,
+	}, nil
+}
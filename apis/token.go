@@ -1,12 +1,16 @@
 package apis
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
 
 	"auth_next/config"
 	. "auth_next/models"
 	"auth_next/utils/auth"
+	"auth_next/utils/i18n"
 	"auth_next/utils/kong"
 )
 
@@ -22,6 +26,7 @@ import (
 //	@Success		200		{object}	TokenResponse
 //	@Failure		400		{object}	common.MessageResponse
 //	@Failure		404		{object}	common.MessageResponse	"User Not Found"
+//	@Failure		429		{object}	common.MessageResponse	"请求过于频繁"
 //	@Failure		500		{object}	common.MessageResponse
 func Login(c *fiber.Ctx) error {
 	var body LoginRequest
@@ -32,10 +37,10 @@ func Login(c *fiber.Ctx) error {
 
 	var user User
 	err = DB.
-		Where("identifier = ? AND is_active = true", auth.MakeIdentifier(body.Email)).
+		Where("identifier IN ? AND is_active = true", auth.IdentifierCandidates(body.Email)).
 		Take(&user).Error
 	if err != nil {
-		return common.Forbidden("账号未注册")
+		return i18n.Forbidden(i18n.CodeUserNotRegistered)
 	}
 
 	ok, err := auth.CheckPassword(body.Password, user.Password)
@@ -43,17 +48,25 @@ func Login(c *fiber.Ctx) error {
 		return err
 	}
 	if !ok {
-		return common.Unauthorized("密码错误")
+		return i18n.Unauthorized(i18n.CodePasswordIncorrect)
+	}
+
+	err = UpgradeIdentifier(DB, &user, body.Email)
+	if err != nil {
+		if !errors.Is(err, ErrIdentifierCollision) {
+			return err
+		}
+		// a duplicate account, keep the old identifier so that the user can still log in
+		log.Warn().Err(err).Int("user_id", user.ID).Msg("upgrade identifier failed")
 	}
 
 	if config.Config.ShamirFeature {
-		// if no shamir email, insert it
-		var hasShamir int64
-		err = DB.Model(&ShamirEmail{}).Where("user_id = ?", user.ID).Count(&hasShamir).Error
+		// if no shamir email, or only rows carried forward from an old key set, encrypt it again
+		hasShamir, err := HasActiveShamirEmails(DB, user.ID)
 		if err != nil {
 			return err
 		}
-		if hasShamir == 0 {
+		if !hasShamir {
 			err = CreateShamirEmails(DB, user.ID, body.Email)
 			if err != nil {
 				return err
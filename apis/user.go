@@ -3,8 +3,10 @@ package apis
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/opentreehole/go-common"
+	"gorm.io/gorm"
 
 	. "auth_next/models"
+	"auth_next/utils/i18n"
 )
 
 // GetCurrentUser godoc
@@ -108,7 +110,7 @@ func ModifyUser(c *fiber.Ctx) error {
 	}
 
 	if body.Nickname == nil {
-		return common.BadRequest("无效请求")
+		return i18n.BadRequest(i18n.CodeInvalidRequest)
 	}
 
 	userID, ok := c.Locals("user_id").(int)
@@ -129,9 +131,24 @@ func ModifyUser(c *fiber.Ctx) error {
 		return err
 	}
 
+	oldNickname := user.Nickname
 	user.Nickname = *body.Nickname
 
-	err = DB.Model(&user).Omit("LastLogin").Updates(&user).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err = tx.Model(&user).Omit("LastLogin").Updates(&user).Error
+		if err != nil {
+			return err
+		}
+
+		if oldNickname == user.Nickname {
+			return nil
+		}
+		return EmitWebhookEvent(tx, WebhookEventUserNicknameChanged, WebhookUserData{
+			UserID:      user.ID,
+			Nickname:    user.Nickname,
+			OldNickname: oldNickname,
+		})
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,171 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
+
+	. "auth_next/models"
+	"auth_next/utils/i18n"
+)
+
+// LookupUsers godoc
+//
+// @Summary find users by email, admin only
+// @Description resolve emails to user IDs through their identifiers, without decrypting any email or returning other data.
+// @Description the body is json, or text/csv with the emails in the first column (a header row is skipped) and the reason
+// @Description in the query, then the response is csv too. every email is recorded in the audit log with the same
+// @Description resource_id. requires an elevated token, see POST /elevate
+// @Tags account
+// @Accept json
+// @Accept text/csv
+// @Produce json
+// @Produce text/csv
+// @Router /users/_lookup [post]
+// @Param json body LookupUsersRequest true "json"
+// @Param reason query string false "reason for text/csv"
+// @Success 200 {array} EmailLookupResult
+// @Failure 400 {object} common.MessageResponse "邮箱或 CSV 格式错误"
+// @Failure 403 {object} common.MessageResponse "非管理员或需要二次验证"
+// @Failure 500 {object} common.MessageResponse
+func LookupUsers(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	err = CheckElevated(c, userID)
+	if err != nil {
+		return err
+	}
+
+	isCSV := c.Is("csv")
+	var body LookupUsersRequest
+	if isCSV {
+		body.Reason = c.Query("reason")
+		body.Emails, err = parseLookupCSV(c.Body())
+		if err != nil {
+			return i18n.BadRequest(i18n.CodeEmailLookupCSVInvalid, err)
+		}
+		err = common.ValidateStruct(&body)
+	} else {
+		err = common.ValidateBody(c, &body)
+	}
+	if err != nil {
+		return err
+	}
+
+	results, err := LookupUsersByEmail(userID, uniqueEmails(body.Emails), body.Reason)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("user_id", userID).Int("emails", len(results)).Msg("lookup users by email")
+
+	if !isCSV {
+		return c.JSON(results)
+	}
+	data, err := formatLookupCSV(results)
+	if err != nil {
+		return err
+	}
+	c.Type("csv")
+	return c.Send(data)
+}
+
+// parseLookupCSV 读取每行第一列的邮箱，跳过空行和第一行的表头
+func parseLookupCSV(data []byte) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(records))
+	for i, record := range records {
+		email := strings.TrimSpace(record[0])
+		if email == "" || i == 0 && !strings.Contains(email, "@") {
+			continue
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+func formatLookupCSV(results []EmailLookupResult) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"email", "user_id", "status"})
+	for _, result := range results {
+		userID := ""
+		if result.UserID != 0 {
+			userID = strconv.Itoa(result.UserID)
+		}
+		_ = writer.Write([]string{result.Email, userID, result.Status})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// uniqueEmails 去除重复的邮箱，保持原来的顺序
+func uniqueEmails(emails []string) []string {
+	seen := make(map[string]bool, len(emails))
+	unique := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		unique = append(unique, email)
+	}
+	return unique
+}
+
+// GetAuditLogs godoc
+//
+// @Summary list audit logs, admin only
+// @Description newest first, filtered by actor, action, resource and target user
+// @Tags account
+// @Produce json
+// @Router /audit_logs [get]
+// @Param query query ListAuditLogsRequest false "query"
+// @Success 200 {array} AuditLog
+// @Failure 403 {object} common.MessageResponse "非管理员"
+// @Failure 500 {object} common.MessageResponse
+func GetAuditLogs(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	var query ListAuditLogsRequest
+	err = common.ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	auditLogs, err := ListAuditLogs(AuditLog{
+		ActorID:      query.ActorID,
+		Action:       query.Action,
+		ResourceID:   query.ResourceID,
+		TargetUserID: query.TargetUserID,
+	}, query.Offset, query.Size)
+	if err != nil {
+		return err
+	}
+	return c.JSON(auditLogs)
+}
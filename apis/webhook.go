@@ -0,0 +1,271 @@
+package apis
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+
+	. "auth_next/models"
+	"auth_next/utils/i18n"
+)
+
+// ListWebhooks godoc
+//
+//	@Summary		list webhook subscriptions, admin only
+//	@Tags			webhook
+//	@Produce		json
+//	@Router			/webhooks [get]
+//	@Success		200	{array}		WebhookSubscription
+//	@Failure		403	{object}	common.MessageResponse	"不是管理员"
+//	@Failure		500	{object}	common.MessageResponse
+func ListWebhooks(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	subscriptions := make([]WebhookSubscription, 0, 10)
+	err = DB.Order("id asc").Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(subscriptions)
+}
+
+// CreateWebhook godoc
+//
+//	@Summary		create webhook subscription, admin only
+//	@Description	payloads are signed with HMAC-SHA256 of "{X-Webhook-Timestamp}.{body}" using the secret, see header X-Webhook-Signature
+//	@Tags			webhook
+//	@Accept			json
+//	@Produce		json
+//	@Router			/webhooks [post]
+//	@Param			json	body		CreateWebhookRequest	true	"json"
+//	@Success		201		{object}	WebhookSubscription
+//	@Failure		400		{object}	common.MessageResponse
+//	@Failure		403		{object}	common.MessageResponse	"不是管理员"
+//	@Failure		500		{object}	common.MessageResponse
+func CreateWebhook(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	var body CreateWebhookRequest
+	err = common.ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	subscription := WebhookSubscription{
+		URL:        body.URL,
+		Secret:     body.Secret,
+		EventTypes: body.EventTypes,
+		IsActive:   true,
+	}
+	err = DB.Create(&subscription).Error
+	if err != nil {
+		return err
+	}
+
+	return c.Status(201).JSON(subscription)
+}
+
+// ModifyWebhook godoc
+//
+//	@Summary		modify webhook subscription, admin only
+//	@Tags			webhook
+//	@Accept			json
+//	@Produce		json
+//	@Router			/webhooks/{id} [put]
+//	@Param			id		path		int						true	"subscription id"
+//	@Param			json	body		ModifyWebhookRequest	true	"json"
+//	@Success		200		{object}	WebhookSubscription
+//	@Failure		400		{object}	common.MessageResponse
+//	@Failure		403		{object}	common.MessageResponse	"不是管理员"
+//	@Failure		404		{object}	common.MessageResponse
+//	@Failure		500		{object}	common.MessageResponse
+func ModifyWebhook(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	subscriptionID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var body ModifyWebhookRequest
+	err = common.ValidateBody(c, &body)
+	if err != nil {
+		return err
+	}
+
+	var subscription WebhookSubscription
+	err = DB.Take(&subscription, subscriptionID).Error
+	if err != nil {
+		return err
+	}
+
+	if body.URL != nil {
+		subscription.URL = *body.URL
+	}
+	if body.Secret != nil {
+		subscription.Secret = *body.Secret
+	}
+	if body.EventTypes != nil {
+		subscription.EventTypes = body.EventTypes
+	}
+	if body.IsActive != nil {
+		subscription.IsActive = *body.IsActive
+	}
+
+	err = DB.Save(&subscription).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(subscription)
+}
+
+// DeleteWebhook godoc
+//
+//	@Summary		delete webhook subscription, admin only
+//	@Description	delete subscription, pending deliveries of it will be marked failed
+//	@Tags			webhook
+//	@Router			/webhooks/{id} [delete]
+//	@Param			id	path	int	true	"subscription id"
+//	@Success		204
+//	@Failure		403	{object}	common.MessageResponse	"不是管理员"
+//	@Failure		500	{object}	common.MessageResponse
+func DeleteWebhook(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	subscriptionID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	err = DB.Model(&WebhookDelivery{}).
+		Where("subscription_id = ? AND status = ?", subscriptionID, WebhookDeliveryPending).
+		Updates(Map{"status": WebhookDeliveryFailed, "last_error": "subscription deleted"}).Error
+	if err != nil {
+		return err
+	}
+
+	err = DB.Delete(&WebhookSubscription{}, subscriptionID).Error
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
+
+// ListWebhookDeliveries godoc
+//
+//	@Summary		list delivery log of a webhook subscription, admin only
+//	@Tags			webhook
+//	@Produce		json
+//	@Router			/webhooks/{id}/deliveries [get]
+//	@Param			id		path		int								true	"subscription id"
+//	@Param			query	query		ListWebhookDeliveriesRequest	false	"query"
+//	@Success		200		{array}		WebhookDelivery
+//	@Failure		403		{object}	common.MessageResponse	"不是管理员"
+//	@Failure		500		{object}	common.MessageResponse
+func ListWebhookDeliveries(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	subscriptionID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var query ListWebhookDeliveriesRequest
+	err = common.ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	tx := DB.Where("subscription_id = ?", subscriptionID)
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+
+	deliveries := make([]WebhookDelivery, 0, query.Size)
+	err = tx.Order("id desc").Offset(query.Offset).Limit(query.Size).Find(&deliveries).Error
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(deliveries)
+}
+
+// RedeliverWebhook godoc
+//
+//	@Summary		redeliver a webhook delivery, admin only
+//	@Description	reset the delivery to pending and send it immediately
+//	@Tags			webhook
+//	@Produce		json
+//	@Router			/webhooks/deliveries/{id}/_redeliver [post]
+//	@Param			id	path		int	true	"delivery id"
+//	@Success		200	{object}	WebhookDelivery
+//	@Failure		403	{object}	common.MessageResponse	"不是管理员"
+//	@Failure		404	{object}	common.MessageResponse
+//	@Failure		409	{object}	common.MessageResponse	"正在发送中"
+//	@Failure		500	{object}	common.MessageResponse
+func RedeliverWebhook(c *fiber.Ctx) error {
+	userID, err := common.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if !IsAdmin(userID) {
+		return i18n.Forbidden(i18n.CodeAdminRequired)
+	}
+
+	deliveryID, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var delivery WebhookDelivery
+	err = DB.Take(&delivery, deliveryID).Error
+	if err != nil {
+		return err
+	}
+
+	// claim the delivery, so that the delivery task or another admin won't send it concurrently
+	ok, err := delivery.ClaimRedelivery()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return i18n.Conflict(i18n.CodeWebhookDeliveryInProgress)
+	}
+
+	// send immediately, failures will be retried by the delivery task
+	_ = DeliverWebhook(&delivery)
+
+	return c.JSON(delivery)
+}
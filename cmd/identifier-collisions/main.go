@@ -0,0 +1,124 @@
+// Command identifier-collisions finds accounts that collide after email canonicalization.
+//
+// Identifiers are hashes, so collisions can't be found from the database alone. The input is
+// a list of emails, one per line, e.g. exported by shamir decryption. Emails are grouped by
+// their canonical identifier and every group that matches more than one account, or matches
+// both an active account and a deleted identifier, is reported as one JSON line.
+//
+// It uses the same environment variables as the server, e.g. MODE, DB_URL, IDENTIFIER_SALT(S),
+// EMAIL_DOMAIN_ALIASES and EMAIL_STRIP_PLUS_TAG.
+//
+//	identifier-collisions -i emails.txt > collisions.jsonl
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"auth_next/config"
+	"auth_next/models"
+	"auth_next/utils/auth"
+)
+
+type Collision struct {
+	CanonicalEmail   string   `json:"canonical_email"`
+	Emails           []string `json:"emails"`
+	UserIDs          []int    `json:"user_ids"`
+	DeletedUserIDs   []int    `json:"deleted_user_ids,omitempty"`
+	NeedUpgradeCount int      `json:"need_upgrade_count"`
+}
+
+func main() {
+	input := flag.String("i", "-", "file of emails, one per line, - for stdin")
+	flag.Parse()
+
+	config.InitConfig()
+	models.ConnectDB()
+
+	var reader io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatal().Err(err).Msg("open input failed")
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		reader = file
+	}
+
+	// group emails by canonical form
+	groups := make(map[string][]string)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		email := strings.TrimSpace(scanner.Text())
+		if email == "" {
+			continue
+		}
+		canonicalEmail := auth.CanonicalizeEmail(email)
+		if !slices.Contains(groups[canonicalEmail], email) {
+			groups[canonicalEmail] = append(groups[canonicalEmail], email)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal().Err(err).Msg("read input failed")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	var collisionCount, needUpgradeCount int
+	for canonicalEmail, emails := range groups {
+		// identifiers of all salt versions, the current canonical identifier first
+		identifiers := []string{auth.MakeIdentifier(canonicalEmail)}
+		for _, email := range emails {
+			for _, identifier := range auth.IdentifierCandidates(email) {
+				if !slices.Contains(identifiers, identifier) {
+					identifiers = append(identifiers, identifier)
+				}
+			}
+		}
+
+		var users []models.User
+		err := models.DB.Where("identifier IN ?", identifiers).Find(&users).Error
+		if err != nil {
+			log.Fatal().Err(err).Msg("load users failed")
+		}
+
+		var deletedUserIDs []int
+		err = models.DB.Model(&models.DeleteIdentifier{}).
+			Where("identifier IN ?", identifiers).
+			Pluck("user_id", &deletedUserIDs).Error
+		if err != nil {
+			log.Fatal().Err(err).Msg("load deleted identifiers failed")
+		}
+
+		collision := Collision{CanonicalEmail: canonicalEmail, Emails: emails, DeletedUserIDs: deletedUserIDs}
+		for _, user := range users {
+			collision.UserIDs = append(collision.UserIDs, user.ID)
+			if user.Identifier.String != identifiers[0] {
+				collision.NeedUpgradeCount++
+			}
+		}
+		needUpgradeCount += collision.NeedUpgradeCount
+
+		if len(users) > 1 || (len(users) > 0 && len(deletedUserIDs) > 0) {
+			collisionCount++
+			err = encoder.Encode(collision)
+			if err != nil {
+				log.Fatal().Err(err).Msg("write output failed")
+			}
+		}
+	}
+
+	log.Info().
+		Int("emails", len(groups)).
+		Int("collisions", collisionCount).
+		Int("need_upgrade", needUpgradeCount).
+		Msg("identifier collision check finished")
+}
@@ -0,0 +1,113 @@
+// Command identifier-migrate re-hashes stored identifiers to a salt version that wraps an older one.
+//
+// A version configured with wrap=N in IDENTIFIER_SALTS takes the version N identifier as its input
+// instead of the email, so existing identifiers can be converted without knowing any plaintext email.
+// Both users and deleted identifiers are migrated, in batches ordered by user id. Migrated rows no longer
+// match the old version, so the command can be interrupted and run again at any time.
+//
+// It uses the same environment variables as the server, e.g. MODE, DB_URL and IDENTIFIER_SALT(S).
+//
+//	identifier-migrate -to 3 -batch 1000
+package main
+
+import (
+	"flag"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"auth_next/config"
+	"auth_next/models"
+	"auth_next/utils/auth"
+)
+
+type row struct {
+	ID         int
+	Identifier string
+}
+
+func main() {
+	to := flag.Int("to", 0, "target salt version, must be configured with wrap")
+	batchSize := flag.Int("batch", 1000, "rows per batch")
+	dryRun := flag.Bool("dry-run", false, "count rows to migrate without writing")
+	flag.Parse()
+
+	config.InitConfig()
+	models.ConnectDB()
+
+	salt, ok := config.IdentifierSalts[*to]
+	if !ok || salt.Wrap == 0 {
+		log.Fatal().Int("to", *to).Msg("target version not found or doesn't wrap an older version")
+	}
+
+	tables := []struct {
+		model  any
+		column string
+	}{
+		{&models.User{}, "id"},
+		{&models.DeleteIdentifier{}, "user_id"},
+	}
+	for _, table := range tables {
+		migrated, err := migrate(table.model, table.column, salt.Wrap, *to, *batchSize, *dryRun)
+		if err != nil {
+			log.Fatal().Err(err).Int("migrated", migrated).Msg("migrate identifiers failed")
+		}
+		log.Info().Str("column", table.column).Int("migrated", migrated).Bool("dry_run", *dryRun).
+			Msgf("migrate identifiers of %T finished", table.model)
+	}
+}
+
+func migrate(model any, idColumn string, from, to, batchSize int, dryRun bool) (int, error) {
+	var migrated, lastID int
+	for {
+		query := models.DB.Model(model).
+			Select(idColumn+" AS id", "identifier").
+			Where(idColumn+" > ?", lastID).
+			Order(idColumn).
+			Limit(batchSize)
+		if from == 1 {
+			// identifiers of version 1 have no prefix
+			query = query.Where("identifier IS NOT NULL AND identifier NOT LIKE ?", "v%")
+		} else {
+			query = query.Where("identifier LIKE ?", auth.IdentifierVersionPattern(from))
+		}
+
+		var rows []row
+		err := query.Scan(&rows).Error
+		if err != nil {
+			return migrated, err
+		}
+		if len(rows) == 0 {
+			return migrated, nil
+		}
+		lastID = rows[len(rows)-1].ID
+
+		if dryRun {
+			migrated += len(rows)
+			continue
+		}
+
+		err = models.DB.Transaction(func(tx *gorm.DB) error {
+			for _, r := range rows {
+				identifier, err := auth.WrapIdentifier(to, r.Identifier)
+				if err != nil {
+					log.Warn().Err(err).Int("id", r.ID).Msg("skip invalid identifier")
+					continue
+				}
+				// only update if unchanged, the user may have logged in meanwhile
+				err = tx.Model(model).
+					Where(idColumn+" = ? AND identifier = ?", r.ID, r.Identifier).
+					UpdateColumn("identifier", identifier).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, err
+		}
+		migrated += len(rows)
+		log.Info().Int("last_id", lastID).Int("migrated", migrated).Msg("batch migrated")
+	}
+}
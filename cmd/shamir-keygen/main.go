@@ -0,0 +1,285 @@
+// Command shamir-keygen generates the PGP key pairs of shamir trustees for a key ceremony.
+//
+// Every private key is locked with its own passphrase, generated at random unless given with -passphrases
+// (one per line, in the order of -identity). The output directory contains, for the i-th trustee,
+// i-public.key, i-private.key and i-passphrase.txt, to be handed to that trustee only, and
+//
+//   - manifest.json: identity names and fingerprints, for SHAMIR_PUBLIC_KEY_DIR and for trustees to check their keys
+//   - public_keys.json: the body of POST /api/shamir/key, to rotate the keys of a running server
+//
+// After the ceremony the private keys and passphrases should be removed from the output directory.
+//
+//	shamir-keygen -out keys -identity "Alice <alice@example.com>" -identity "Bob <bob@example.com>" -identity "Carol <carol@example.com>"
+//
+// With -verify, the manifest and the keys left in a directory are checked instead.
+//
+//	shamir-keygen -verify keys
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/gopenpgp/v2/helper"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+
+	"auth_next/utils/shamir"
+)
+
+const minPassphraseLength = 12
+
+type identities []string
+
+func (i *identities) String() string {
+	return strings.Join(*i, ", ")
+}
+
+func (i *identities) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+func main() {
+	var names identities
+	flag.Var(&names, "identity", `trustee identity "Name <email>", repeat for every trustee`)
+	out := flag.String("out", "shamir-keys", "output directory, must not exist or be empty")
+	keyType := flag.String("type", "x25519", "key type, x25519 or rsa")
+	bits := flag.Int("bits", 4096, "rsa key size")
+	passphrasesFile := flag.String("passphrases", "", "file with one passphrase per trustee, generated if empty")
+	verify := flag.String("verify", "", "check the manifest and keys in a directory instead of generating")
+	flag.Parse()
+
+	if *verify != "" {
+		err := verifyDir(*verify)
+		if err != nil {
+			log.Fatal().Err(err).Msg("verify keys failed")
+		}
+		return
+	}
+
+	if len(names) == 0 {
+		log.Fatal().Msg("at least one -identity is required")
+	}
+	if *keyType != "x25519" && *keyType != "rsa" {
+		log.Fatal().Str("type", *keyType).Msg("key type should be x25519 or rsa")
+	}
+	passphrases, err := loadPassphrases(*passphrasesFile, len(names))
+	if err != nil {
+		log.Fatal().Err(err).Msg("load passphrases failed")
+	}
+	err = prepareDir(*out)
+	if err != nil {
+		log.Fatal().Err(err).Msg("prepare output directory failed")
+	}
+
+	manifest := shamir.KeyManifest{CreatedAt: time.Now().UTC()}
+	armoredPublicKeys := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		index := i + 1
+		address, err := mail.ParseAddress(name)
+		if err != nil {
+			log.Fatal().Err(err).Str("identity", name).Msg("identity should be \"Name <email>\"")
+		}
+		if seen[address.Address] {
+			log.Fatal().Str("email", address.Address).Msg("duplicate identity")
+		}
+		seen[address.Address] = true
+
+		armoredPrivateKey, err := helper.GenerateKey(address.Name, address.Address, passphrases[i], *keyType, *bits)
+		if err != nil {
+			log.Fatal().Err(err).Int("index", index).Msg("generate key failed")
+		}
+		key, err := crypto.NewKeyFromArmored(armoredPrivateKey)
+		if err != nil {
+			log.Fatal().Err(err).Int("index", index).Msg("parse generated key failed")
+		}
+		armoredPublicKey, err := key.GetArmoredPublicKey()
+		if err != nil {
+			log.Fatal().Err(err).Int("index", index).Msg("export public key failed")
+		}
+
+		manifestKey := shamir.ManifestKey{
+			Index:          index,
+			IdentityName:   key.GetEntity().PrimaryIdentity().Name,
+			Fingerprint:    key.GetFingerprint(),
+			Algorithm:      *keyType,
+			PublicKeyFile:  fmt.Sprintf("%d-public.key", index),
+			PrivateKeyFile: fmt.Sprintf("%d-private.key", index),
+		}
+		files := []struct {
+			name string
+			data string
+			perm os.FileMode
+		}{
+			{manifestKey.PublicKeyFile, armoredPublicKey, 0o644},
+			{manifestKey.PrivateKeyFile, armoredPrivateKey, 0o600},
+			{fmt.Sprintf("%d-passphrase.txt", index), string(passphrases[i]) + "\n", 0o600},
+		}
+		for _, file := range files {
+			err = os.WriteFile(filepath.Join(*out, file.name), []byte(file.data), file.perm)
+			if err != nil {
+				log.Fatal().Err(err).Str("file", file.name).Msg("write key failed")
+			}
+		}
+
+		manifest.Keys = append(manifest.Keys, manifestKey)
+		armoredPublicKeys = append(armoredPublicKeys, armoredPublicKey)
+		log.Info().Int("index", index).Str("identity", manifestKey.IdentityName).
+			Str("fingerprint", manifestKey.Fingerprint).Msg("key generated")
+	}
+
+	err = writeJSON(filepath.Join(*out, shamir.ManifestFile), manifest)
+	if err != nil {
+		log.Fatal().Err(err).Msg("write manifest failed")
+	}
+	err = writeJSON(filepath.Join(*out, "public_keys.json"), map[string]any{"data": armoredPublicKeys})
+	if err != nil {
+		log.Fatal().Err(err).Msg("write public keys failed")
+	}
+
+	err = verifyDir(*out)
+	if err != nil {
+		log.Fatal().Err(err).Msg("verify generated keys failed")
+	}
+	log.Info().Int("keys", len(manifest.Keys)).Str("out", *out).
+		Msg("keys generated, hand each trustee their private key and passphrase, then remove them from the directory")
+}
+
+// loadPassphrases 读取或生成 n 个口令，口令之间不能相同
+func loadPassphrases(filename string, n int) ([][]byte, error) {
+	passphrases := make([][]byte, 0, n)
+	if filename == "" {
+		for i := 0; i < n; i++ {
+			passphrase, err := randomPassphrase()
+			if err != nil {
+				return nil, err
+			}
+			passphrases = append(passphrases, passphrase)
+		}
+		return passphrases, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, n)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if len(line) < minPassphraseLength {
+			return nil, fmt.Errorf("passphrase %d is shorter than %d characters", len(passphrases)+1, minPassphraseLength)
+		}
+		if seen[line] {
+			return nil, fmt.Errorf("passphrase %d is used more than once", len(passphrases)+1)
+		}
+		seen[line] = true
+		passphrases = append(passphrases, []byte(line))
+	}
+	if len(passphrases) != n {
+		return nil, fmt.Errorf("%d passphrases for %d identities", len(passphrases), n)
+	}
+	return passphrases, nil
+}
+
+// randomPassphrase 160 位随机数，base32 编码后每 4 个字符一组
+func randomPassphrase() ([]byte, error) {
+	random := make([]byte, 20)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random)
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return []byte(strings.Join(groups, "-")), nil
+}
+
+// prepareDir 创建输出目录，已有文件时拒绝，避免覆盖上一次生成的私钥
+func prepareDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(dir, 0o700)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return nil
+}
+
+// verifyDir 检查清单与公钥一致；私钥和口令还在目录中时，检查能够解锁且与公钥匹配
+func verifyDir(dir string) error {
+	manifest, armoredPublicKeys, err := shamir.LoadKeyManifest(dir)
+	if err != nil {
+		return err
+	}
+	err = shamir.CheckDemoKeys(armoredPublicKeys)
+	if err != nil {
+		return err
+	}
+
+	for _, manifestKey := range manifest.Keys {
+		if manifestKey.PrivateKeyFile == "" {
+			continue
+		}
+		armoredPrivateKey, err := os.ReadFile(filepath.Join(dir, manifestKey.PrivateKeyFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		key, err := crypto.NewKeyFromArmored(string(armoredPrivateKey))
+		if err != nil {
+			return fmt.Errorf("key %d: %w", manifestKey.Index, err)
+		}
+		if key.GetFingerprint() != manifestKey.Fingerprint {
+			return fmt.Errorf("key %d: private key fingerprint %s doesn't match manifest", manifestKey.Index, key.GetFingerprint())
+		}
+
+		passphrase, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d-passphrase.txt", manifestKey.Index)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		unlocked, err := key.Unlock([]byte(strings.TrimRight(string(passphrase), "\r\n")))
+		if err != nil {
+			return fmt.Errorf("key %d: %w", manifestKey.Index, err)
+		}
+		unlocked.ClearPrivateParams()
+	}
+
+	for _, manifestKey := range manifest.Keys {
+		log.Info().Int("index", manifestKey.Index).Str("identity", manifestKey.IdentityName).
+			Str("fingerprint", manifestKey.Fingerprint).Msg("key verified")
+	}
+	return nil
+}
+
+func writeJSON(filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o644)
+}
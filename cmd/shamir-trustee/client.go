@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const defaultChunkSize = 500
+
+// Client 调用 shamir 接口，使用 SHAMIR_TRUSTEE_TOKEN 认证
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   os.Getenv("SHAMIR_TRUSTEE_TOKEN"),
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+type messageResponse struct {
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// StatusError 接口返回 4xx/5xx
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s %s: %d", e.Method, e.Path, e.StatusCode)
+}
+
+// UploadError 第 Chunk 块上传失败，之前的分块已上传到 SessionID，从第 Chunk 块继续上传
+type UploadError struct {
+	Chunk     int
+	SessionID string
+	Err       error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("chunk %d: %v", e.Chunk, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// Forbidden 服务器返回 403，上传时间较长时一般是令牌的提权已过期
+func (e *UploadError) Forbidden() bool {
+	var statusErr *StatusError
+	return errors.As(e.Err, &statusErr) && statusErr.StatusCode == http.StatusForbidden
+}
+
+func (client *Client) do(method, path string, body, response any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, client.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+
+	res, err := client.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		var errorResponse messageResponse
+		_ = json.Unmarshal(data, &errorResponse)
+		return &StatusError{Method: method, Path: path, StatusCode: res.StatusCode, Message: errorResponse.Message}
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+// FetchMessages 获取 identityName 的所有 PGP 消息，userID 不为 0 时只获取一个用户的
+func (client *Client) FetchMessages(identityName string, userID int) (MessagesFile, error) {
+	messages := MessagesFile{IdentityName: identityName, UserID: userID}
+	query := "?identity_name=" + url.QueryEscape(identityName)
+
+	if userID != 0 {
+		var message MessageRecord
+		err := client.do(http.MethodGet, fmt.Sprintf("/shamir/%d%s", userID, query), nil, &message)
+		if err != nil {
+			return messages, err
+		}
+		messages.Messages = []MessageRecord{message}
+		return messages, nil
+	}
+
+	err := client.do(http.MethodGet, "/shamir"+query, nil, &messages.Messages)
+	return messages, err
+}
+
+type uploadSharesRequest struct {
+	IdentityName string        `json:"identity_name"`
+	SessionID    string        `json:"session_id,omitempty"`
+	Shares       []ShareRecord `json:"shares"`
+	Chunk        int           `json:"chunk"`
+	More         bool          `json:"more"`
+}
+
+type uploadSharesResponse struct {
+	SessionID string `json:"session_id"`
+}
+
+// UploadShares 按用户 ID 排序后分块上传到更新会话，从第 from 块开始.
+// 第一块上传后固定会话 ID，避免中途会话变化时坐标点分散在不同会话中；
+// 上传失败时返回 *UploadError，包含继续上传需要的分块和会话 ID
+func (client *Client) UploadShares(shares SharesFile, chunkSize, from int, progress func(chunk, chunks int, sessionID string)) error {
+	// 服务端要求分块的用户 ID 递增，手动编辑过的文件也能上传
+	shares.Shares = append([]ShareRecord(nil), shares.Shares...)
+	sort.Slice(shares.Shares, func(i, j int) bool {
+		return shares.Shares[i].UserID < shares.Shares[j].UserID
+	})
+
+	chunks := (len(shares.Shares) + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	sessionID := shares.SessionID
+	for chunk := from; chunk < chunks; chunk++ {
+		start := chunk * chunkSize
+		end := min(start+chunkSize, len(shares.Shares))
+		body := uploadSharesRequest{
+			IdentityName: shares.IdentityName,
+			SessionID:    sessionID,
+			Shares:       shares.Shares[start:end],
+			Chunk:        chunk,
+			More:         chunk < chunks-1,
+		}
+
+		var response messageResponse
+		err := client.do(http.MethodPost, "/shamir/shares", body, &response)
+		if err != nil {
+			return &UploadError{Chunk: chunk, SessionID: sessionID, Err: err}
+		}
+		var data uploadSharesResponse
+		err = json.Unmarshal(response.Data, &data)
+		if err != nil {
+			return &UploadError{Chunk: chunk, SessionID: sessionID, Err: err}
+		}
+		sessionID = data.SessionID
+		progress(chunk, chunks, sessionID)
+	}
+	return nil
+}
+
+type uploadShareRequest struct {
+	IdentityName string `json:"identity_name"`
+	SessionID    string `json:"session_id,omitempty"`
+	ShareRecord
+}
+
+// UploadUserShare 上传一个用户的坐标点，批准 SessionID 对应的解密申请
+func (client *Client) UploadUserShare(shares SharesFile) error {
+	if len(shares.Shares) != 1 {
+		return fmt.Errorf("expect one share of user %d, got %d", shares.UserID, len(shares.Shares))
+	}
+	return client.do(http.MethodPost, "/shamir/decrypt", uploadShareRequest{
+		IdentityName: shares.IdentityName,
+		SessionID:    shares.SessionID,
+		ShareRecord:  shares.Shares[0],
+	}, nil)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/goccy/go-json"
+
+	"auth_next/utils/shamir"
+)
+
+func TestUploadShares(t *testing.T) {
+	var requests []uploadSharesRequest
+	forbiddenChunk := -1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, http.MethodPost)
+		assert.Equal(t, r.URL.Path, "/api/shamir/shares")
+		assert.Equal(t, r.Header.Get("Authorization"), "Bearer token")
+
+		var body uploadSharesRequest
+		err := json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, err, nil)
+		if body.Chunk == forbiddenChunk {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"elevation required"}`))
+			return
+		}
+		requests = append(requests, body)
+		_, _ = w.Write([]byte(`{"message":"ok","data":{"session_id":"session1"}}`))
+	}))
+	defer server.Close()
+
+	client := newClient(server.URL + "/api/")
+	client.token = "token"
+
+	shares := SharesFile{IdentityName: "trustee1"}
+	for _, userID := range []int{5, 3, 1, 4, 2} {
+		shares.Shares = append(shares.Shares, ShareRecord{
+			UserID: userID,
+			Share:  shamir.Share{X: big.NewInt(int64(userID)), Y: big.NewInt(1)},
+		})
+	}
+
+	// shares are uploaded in chunks ordered by user ID, the session is pinned after the first chunk
+	var progress []int
+	err := client.UploadShares(shares, 2, 0, func(chunk, chunks int, sessionID string) {
+		assert.Equal(t, chunks, 3)
+		assert.Equal(t, sessionID, "session1")
+		progress = append(progress, chunk)
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, progress, []int{0, 1, 2})
+	assert.Equal(t, len(requests), 3)
+	userIDs := make([]int, 0, len(shares.Shares))
+	for i, request := range requests {
+		assert.Equal(t, request.IdentityName, "trustee1")
+		assert.Equal(t, request.Chunk, i)
+		assert.Equal(t, request.More, i < 2)
+		if i == 0 {
+			assert.Equal(t, request.SessionID, "")
+		} else {
+			assert.Equal(t, request.SessionID, "session1")
+		}
+		for _, record := range request.Shares {
+			userIDs = append(userIDs, record.UserID)
+		}
+	}
+	assert.Equal(t, userIDs, []int{1, 2, 3, 4, 5})
+	// the caller's file is not reordered
+	assert.Equal(t, shares.Shares[0].UserID, 5)
+
+	// resume from a chunk
+	requests = nil
+	shares.SessionID = "session1"
+	err = client.UploadShares(shares, 2, 2, func(int, int, string) {})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(requests), 1)
+	assert.Equal(t, requests[0].Chunk, 2)
+	assert.Equal(t, requests[0].SessionID, "session1")
+	assert.Equal(t, requests[0].Shares[0].UserID, 5)
+	assert.Equal(t, requests[0].More, false)
+
+	// a 403 halfway reports where to resume
+	requests = nil
+	shares.SessionID = ""
+	forbiddenChunk = 1
+	err = client.UploadShares(shares, 2, 0, func(int, int, string) {})
+	uploadErr, ok := err.(*UploadError)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, uploadErr.Chunk, 1)
+	assert.Equal(t, uploadErr.SessionID, "session1")
+	assert.Equal(t, uploadErr.Forbidden(), true)
+	assert.Equal(t, err.Error(), "chunk 1: POST /shamir/shares: 403 elevation required")
+	assert.Equal(t, len(requests), 1)
+}
+
+func TestResumeCommand(t *testing.T) {
+	args := []string{"shamir-trustee", "upload", "-url", "https://auth.example.com/api", "-i", "my shares.json", "-from", "1", "-session=old"}
+	assert.Equal(t, resumeCommand(args, 3, "session1"),
+		"shamir-trustee upload -url https://auth.example.com/api -i 'my shares.json' -from 3 -session session1")
+
+	args = []string{"shamir-trustee", "run", "-url", "https://auth.example.com/api", "-key", "trustee.asc"}
+	assert.Equal(t, resumeCommand(args, 0, ""),
+		"shamir-trustee run -url https://auth.example.com/api -key trustee.asc -from 0")
+}
@@ -0,0 +1,273 @@
+// Command shamir-trustee decrypts the PGP messages of a shamir trustee locally and uploads the shares.
+//
+// The private key never leaves the machine. Shares are checked before uploading: the envelope checksum
+// must match and the share must belong to the user it was fetched for. Large uploads are split into chunks,
+// the server only counts the trustee as uploaded after the last chunk.
+//
+// The access token is read from SHAMIR_TRUSTEE_TOKEN and must be elevated, the key passphrase from
+// SHAMIR_TRUSTEE_PASSPHRASE or -passphrase-file. Elevation expires after 5 minutes; if an upload fails
+// halfway, e.g. with 403 after the elevation expired, the command to resume from the failed chunk is printed.
+//
+// Online, in one step:
+//
+//	shamir-trustee run -url https://auth.example.com/api -key trustee.asc
+//
+// Air-gapped, the messages and shares files are carried between the machines:
+//
+//	shamir-trustee fetch -url https://auth.example.com/api -identity "trustee <trustee@example.com>" -o messages.json
+//	shamir-trustee decrypt -key trustee.asc -i messages.json -o shares.json
+//	shamir-trustee upload -url https://auth.example.com/api -i shares.json
+//
+// With -user, only the message of one user is fetched, and the share approves the decrypt request given with -session.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `usage: shamir-trustee <command> [flags]
+
+commands:
+  fetch    download the PGP messages of a trustee to a file
+  decrypt  decrypt a messages file with the private key, offline
+  upload   upload a shares file
+  run      fetch, decrypt and upload
+
+run "shamir-trustee <command> -h" for the flags of a command`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "fetch":
+		fetchCommand(args)
+	case "decrypt":
+		decryptCommand(args)
+	case "upload":
+		uploadCommand(args)
+	case "run":
+		runCommand(args)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func fetchCommand(args []string) {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	url := flags.String("url", "", "api base url, e.g. https://auth.example.com/api")
+	identityName := flags.String("identity", "", "identity name of the trustee, the name of the PGP key")
+	userID := flags.Int("user", 0, "only fetch the message of this user")
+	output := flags.String("o", "messages.json", "output file")
+	_ = flags.Parse(args)
+
+	if *url == "" || *identityName == "" {
+		log.Fatal().Msg("-url and -identity are required")
+	}
+
+	client := newClient(*url)
+	messages, err := client.FetchMessages(*identityName, *userID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fetch messages failed")
+	}
+
+	err = writeJSON(*output, messages)
+	if err != nil {
+		log.Fatal().Err(err).Msg("write messages failed")
+	}
+	log.Info().Int("messages", len(messages.Messages)).Str("output", *output).Msg("messages fetched")
+}
+
+func decryptCommand(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := flags.String("key", "", "armored PGP private key of the trustee")
+	passphraseFile := flags.String("passphrase-file", "", "file containing the key passphrase, defaults to SHAMIR_TRUSTEE_PASSPHRASE")
+	input := flags.String("i", "messages.json", "messages file")
+	output := flags.String("o", "shares.json", "output file")
+	_ = flags.Parse(args)
+
+	trustee := loadTrustee(*keyFile, *passphraseFile)
+
+	var messages MessagesFile
+	err := readJSON(*input, &messages)
+	if err != nil {
+		log.Fatal().Err(err).Msg("read messages failed")
+	}
+
+	shares := decryptOrExit(trustee, messages)
+
+	err = writeJSON(*output, shares)
+	if err != nil {
+		log.Fatal().Err(err).Msg("write shares failed")
+	}
+	log.Info().Int("shares", len(shares.Shares)).Str("output", *output).Msg("messages decrypted")
+}
+
+func uploadCommand(args []string) {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	url := flags.String("url", "", "api base url, e.g. https://auth.example.com/api")
+	input := flags.String("i", "shares.json", "shares file")
+	sessionID := flags.String("session", "", "session id, defaults to the current session; the decrypt request id with -user")
+	chunkSize := flags.Int("chunk", defaultChunkSize, "shares per request")
+	from := flags.Int("from", 0, "first chunk to upload, to resume an interrupted upload")
+	_ = flags.Parse(args)
+
+	if *url == "" {
+		log.Fatal().Msg("-url is required")
+	}
+
+	var shares SharesFile
+	err := readJSON(*input, &shares)
+	if err != nil {
+		log.Fatal().Err(err).Msg("read shares failed")
+	}
+	if *sessionID != "" {
+		shares.SessionID = *sessionID
+	}
+
+	upload(newClient(*url), shares, *chunkSize, *from)
+}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	url := flags.String("url", "", "api base url, e.g. https://auth.example.com/api")
+	keyFile := flags.String("key", "", "armored PGP private key of the trustee")
+	passphraseFile := flags.String("passphrase-file", "", "file containing the key passphrase, defaults to SHAMIR_TRUSTEE_PASSPHRASE")
+	userID := flags.Int("user", 0, "only decrypt the shares of this user")
+	sessionID := flags.String("session", "", "session id, defaults to the current session; the decrypt request id with -user")
+	chunkSize := flags.Int("chunk", defaultChunkSize, "shares per request")
+	from := flags.Int("from", 0, "first chunk to upload, to resume an interrupted upload")
+	_ = flags.Parse(args)
+
+	if *url == "" {
+		log.Fatal().Msg("-url is required")
+	}
+
+	trustee := loadTrustee(*keyFile, *passphraseFile)
+	client := newClient(*url)
+
+	messages, err := client.FetchMessages(trustee.IdentityName, *userID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fetch messages failed")
+	}
+	log.Info().Int("messages", len(messages.Messages)).Msg("messages fetched")
+
+	shares := decryptOrExit(trustee, messages)
+	shares.SessionID = *sessionID
+
+	upload(client, shares, *chunkSize, *from)
+}
+
+func loadTrustee(keyFile, passphraseFile string) *Trustee {
+	if keyFile == "" {
+		log.Fatal().Msg("-key is required")
+	}
+	armoredKey, err := os.ReadFile(keyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("read private key failed")
+	}
+
+	passphrase := []byte(os.Getenv("SHAMIR_TRUSTEE_PASSPHRASE"))
+	if passphraseFile != "" {
+		passphrase, err = os.ReadFile(passphraseFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("read passphrase failed")
+		}
+		passphrase = []byte(strings.TrimRight(string(passphrase), "\r\n"))
+	}
+
+	trustee, err := NewTrustee(string(armoredKey), passphrase)
+	if err != nil {
+		log.Fatal().Err(err).Msg("load private key failed")
+	}
+	return trustee
+}
+
+// decryptOrExit 解密并检查所有消息，有任何错误时列出后退出，不上传部分坐标点
+func decryptOrExit(trustee *Trustee, messages MessagesFile) SharesFile {
+	shares, invalid, err := trustee.Decrypt(messages)
+	if err != nil {
+		log.Fatal().Err(err).Msg("decrypt messages failed")
+	}
+	for _, message := range invalid {
+		log.Error().Int("user_id", message.UserID).Err(message.Err).Msg("invalid message")
+	}
+	if len(invalid) > 0 {
+		log.Fatal().Int("invalid", len(invalid)).Msg("some messages can't be decrypted or validated, nothing uploaded")
+	}
+	return shares
+}
+
+func upload(client *Client, shares SharesFile, chunkSize, from int) {
+	if chunkSize <= 0 {
+		log.Fatal().Int("chunk", chunkSize).Msg("chunk size must be positive")
+	}
+
+	if shares.UserID != 0 {
+		if shares.SessionID == "" {
+			log.Fatal().Msg("-session is required to approve the decrypt request of a user")
+		}
+		err := client.UploadUserShare(shares)
+		if err != nil {
+			log.Fatal().Err(err).Msg("upload share failed")
+		}
+		log.Info().Int("user_id", shares.UserID).Msg("share uploaded")
+		return
+	}
+
+	err := client.UploadShares(shares, chunkSize, from, func(chunk, chunks int, sessionID string) {
+		log.Info().Int("chunk", chunk).Int("chunks", chunks).Str("session_id", sessionID).Msg("chunk uploaded")
+	})
+	if err != nil {
+		var uploadErr *UploadError
+		if !errors.As(err, &uploadErr) {
+			log.Fatal().Err(err).Msg("upload shares failed")
+		}
+		if uploadErr.Forbidden() {
+			log.Error().Err(err).Msg("upload shares failed, the elevation of SHAMIR_TRUSTEE_TOKEN may have expired, elevate it again and resume")
+		} else {
+			log.Error().Err(err).Msg("upload shares failed, resume")
+		}
+		fmt.Fprintf(os.Stderr, "resume with:\n  %s\n", resumeCommand(os.Args, uploadErr.Chunk, uploadErr.SessionID))
+		os.Exit(1)
+	}
+	log.Info().Int("shares", len(shares.Shares)).Msg("shares uploaded")
+}
+
+// resumeCommand 替换 args 中的 -from 和 -session，从第 chunk 块继续上传到同一个会话
+func resumeCommand(args []string, chunk int, sessionID string) string {
+	command := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if i > 0 && strings.HasPrefix(args[i], "-") && (name == "from" || name == "session") {
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		command = append(command, shellQuote(args[i]))
+	}
+	command = append(command, "-from", strconv.Itoa(chunk))
+	if sessionID != "" {
+		command = append(command, "-session", sessionID)
+	}
+	return strings.Join(command, " ")
+}
+
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`;&|<>()*?[]{}~#!") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
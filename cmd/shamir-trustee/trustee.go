@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/goccy/go-json"
+
+	"auth_next/utils/shamir"
+)
+
+// MessagesFile fetch 导出的文件，可以拷贝到离线机器解密
+type MessagesFile struct {
+	IdentityName string          `json:"identity_name"`
+	UserID       int             `json:"user_id,omitempty"` // 只获取了一个用户
+	Messages     []MessageRecord `json:"messages"`
+}
+
+type MessageRecord struct {
+	UserID     int    `json:"user_id"`
+	PGPMessage string `json:"pgp_message"`
+}
+
+// SharesFile decrypt 导出的文件，只包含坐标点，不包含私钥
+type SharesFile struct {
+	IdentityName string        `json:"identity_name"`
+	UserID       int           `json:"user_id,omitempty"`
+	SessionID    string        `json:"session_id,omitempty"`
+	Shares       []ShareRecord `json:"shares"`
+}
+
+type ShareRecord struct {
+	UserID int          `json:"user_id"`
+	Share  shamir.Share `json:"share"`
+}
+
+// InvalidMessage 无法解密或没有通过检查的消息
+type InvalidMessage struct {
+	UserID int
+	Err    error
+}
+
+// Trustee 解锁后的私钥
+type Trustee struct {
+	IdentityName string
+	keyRing      *crypto.KeyRing
+}
+
+func NewTrustee(armoredKey string, passphrase []byte) (*Trustee, error) {
+	key, err := crypto.NewKeyFromArmored(armoredKey)
+	if err != nil {
+		return nil, err
+	}
+	if !key.IsPrivate() {
+		return nil, errors.New("not a private key")
+	}
+	locked, err := key.IsLocked()
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		key, err = key.Unlock(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unlock private key: %w", err)
+		}
+	}
+	keyRing, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Trustee{
+		IdentityName: key.GetEntity().PrimaryIdentity().Name,
+		keyRing:      keyRing,
+	}, nil
+}
+
+// Decrypt 并行解密所有消息并检查坐标点，结果按用户 ID 排序.
+//
+// 信封格式的坐标点检查校验和、用户 ID，所有坐标点必须来自同一个 key set；
+// 坐标点是否在多项式上由服务器用承诺检查
+func (trustee *Trustee) Decrypt(messages MessagesFile) (SharesFile, []InvalidMessage, error) {
+	shares := SharesFile{IdentityName: trustee.IdentityName, UserID: messages.UserID}
+	if messages.IdentityName != "" && messages.IdentityName != trustee.IdentityName {
+		return shares, nil, fmt.Errorf("messages are encrypted for %q, but the key belongs to %q",
+			messages.IdentityName, trustee.IdentityName)
+	}
+
+	records := make([]ShareRecord, len(messages.Messages))
+	errs := make([]error, len(messages.Messages))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				message := messages.Messages[i]
+				records[i].UserID = message.UserID
+				records[i].Share, errs[i] = trustee.decryptShare(message)
+			}
+		}()
+	}
+	for i := range messages.Messages {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	invalid := make([]InvalidMessage, 0)
+	seen := make(map[int]bool, len(records))
+	keySetID := 0
+	for i, record := range records {
+		err := errs[i]
+		if err == nil && seen[record.UserID] {
+			err = errors.New("duplicate user")
+		}
+		if err == nil && record.Share.Meta != nil {
+			if keySetID == 0 {
+				keySetID = record.Share.Meta.KeySetID
+			} else if record.Share.Meta.KeySetID != keySetID {
+				err = fmt.Errorf("share of key set %d, others are of key set %d", record.Share.Meta.KeySetID, keySetID)
+			}
+		}
+		if err != nil {
+			invalid = append(invalid, InvalidMessage{UserID: record.UserID, Err: err})
+			continue
+		}
+		seen[record.UserID] = true
+		shares.Shares = append(shares.Shares, record)
+	}
+
+	sort.Slice(shares.Shares, func(i, j int) bool {
+		return shares.Shares[i].UserID < shares.Shares[j].UserID
+	})
+	return shares, invalid, nil
+}
+
+func (trustee *Trustee) decryptShare(message MessageRecord) (shamir.Share, error) {
+	pgpMessage, err := crypto.NewPGPMessageFromArmored(message.PGPMessage)
+	if err != nil {
+		return shamir.Share{}, fmt.Errorf("parse pgp message: %w", err)
+	}
+	plainMessage, err := trustee.keyRing.Decrypt(pgpMessage, nil, 0)
+	if err != nil {
+		return shamir.Share{}, fmt.Errorf("decrypt pgp message: %w", err)
+	}
+	share, err := shamir.FromString(plainMessage.GetString())
+	if err != nil {
+		return share, err
+	}
+	if share.Meta != nil && share.Meta.UserID != message.UserID {
+		return share, fmt.Errorf("share belongs to user %d", share.Meta.UserID)
+	}
+	return share, nil
+}
+
+func readJSON(filename string, v any) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON 写入文件，坐标点是敏感数据，只有所有者可读
+func writeJSON(filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0o600)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/utils/shamir"
+)
+
+func newTestTrustee(t *testing.T, passphrase []byte) (*Trustee, *crypto.KeyRing) {
+	key, err := crypto.GenerateKey("trustee1", "trustee1@example.com", "x25519", 0)
+	assert.Equal(t, err, nil)
+	publicKey, err := key.ToPublic()
+	assert.Equal(t, err, nil)
+	publicKeyRing, err := crypto.NewKeyRing(publicKey)
+	assert.Equal(t, err, nil)
+
+	lockedKey, err := key.Lock(passphrase)
+	assert.Equal(t, err, nil)
+	armoredKey, err := lockedKey.Armor()
+	assert.Equal(t, err, nil)
+
+	_, err = NewTrustee(armoredKey, []byte("wrong passphrase"))
+	assert.NotEqual(t, err, nil)
+	_, err = NewTrustee(armoredKey, nil)
+	assert.NotEqual(t, err, nil)
+	trustee, err := NewTrustee(armoredKey, passphrase)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, trustee.IdentityName, "trustee1 <trustee1@example.com>")
+	return trustee, publicKeyRing
+}
+
+func encryptTestShare(t *testing.T, publicKeyRing *crypto.KeyRing, userID int, share shamir.Share) MessageRecord {
+	message, err := publicKeyRing.Encrypt(crypto.NewPlainMessageFromString(share.ToString()), nil)
+	assert.Equal(t, err, nil)
+	armored, err := message.GetArmored()
+	assert.Equal(t, err, nil)
+	return MessageRecord{UserID: userID, PGPMessage: armored}
+}
+
+func TestTrusteeDecrypt(t *testing.T) {
+	trustee, publicKeyRing := newTestTrustee(t, []byte("passphrase"))
+
+	messages := MessagesFile{IdentityName: trustee.IdentityName}
+	expected := map[int]string{}
+	for _, userID := range []int{3, 1, 2} {
+		shares, err := shamir.Encrypt("user@example.com", 3, 2)
+		assert.Equal(t, err, nil)
+		share := shares[0]
+		share.Meta = &shamir.ShareMeta{KeySetID: 1, UserID: userID, Index: 1, Threshold: 2}
+		messages.Messages = append(messages.Messages, encryptTestShare(t, publicKeyRing, userID, share))
+		expected[userID] = share.ToString()
+	}
+
+	// valid messages are decrypted and sorted by user ID
+	sharesFile, invalid, err := trustee.Decrypt(messages)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(invalid), 0)
+	assert.Equal(t, sharesFile.IdentityName, trustee.IdentityName)
+	assert.Equal(t, len(sharesFile.Shares), 3)
+	for i, record := range sharesFile.Shares {
+		assert.Equal(t, record.UserID, i+1)
+		assert.Equal(t, record.Share.ToString(), expected[record.UserID])
+	}
+
+	// a share fetched for another user, a share of another key set, a duplicate and a broken message
+	shares, err := shamir.Encrypt("user@example.com", 3, 2)
+	assert.Equal(t, err, nil)
+	share := shares[0]
+	share.Meta = &shamir.ShareMeta{KeySetID: 1, UserID: 5, Index: 1, Threshold: 2}
+	messages.Messages = append(messages.Messages, encryptTestShare(t, publicKeyRing, 4, share))
+	share.Meta = &shamir.ShareMeta{KeySetID: 2, UserID: 6, Index: 1, Threshold: 2}
+	messages.Messages = append(messages.Messages, encryptTestShare(t, publicKeyRing, 6, share))
+	messages.Messages = append(messages.Messages, messages.Messages[0])
+	messages.Messages = append(messages.Messages, MessageRecord{UserID: 7, PGPMessage: "not a pgp message"})
+
+	sharesFile, invalid, err = trustee.Decrypt(messages)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(sharesFile.Shares), 3)
+	invalidUserIDs := make([]int, 0, len(invalid))
+	for _, message := range invalid {
+		assert.NotEqual(t, message.Err, nil)
+		invalidUserIDs = append(invalidUserIDs, message.UserID)
+	}
+	assert.Equal(t, invalidUserIDs, []int{4, 6, 3, 7})
+
+	// messages of another trustee are rejected
+	messages.IdentityName = "trustee2 <trustee2@example.com>"
+	_, _, err = trustee.Decrypt(messages)
+	assert.NotEqual(t, err, nil)
+}
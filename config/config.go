@@ -1,47 +1,92 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/base32"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env/v9"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
 )
 
 const (
+	// DefaultShamirKeyCount 默认公钥（data/{1..7}-public.key）的数量
 	DefaultShamirKeyCount = 7
 )
 
 var Config struct {
-	Mode                    string `envDefault:"dev"`
-	DbUrl                   string
-	KongUrl                 string
-	RedisUrl                string
-	NotificationUrl         string
-	EmailWhitelist          []string
-	ValidateEmailWhitelist  []string
-	EmailServerNoReplyUrl   url.URL `env:"EMAIL_SERVER_NO_REPLY_URL"`
-	EmailDomain             string
-	EmailDev                string `envDefault:"dev@danta.tech"`
-	ShamirFeature           bool   `envDefault:"true"`
-	Standalone              bool
-	VerificationCodeExpires int    `envDefault:"10"`
-	SiteName                string `envDefault:"Open Tree Hole"`
-	EnableRegisterQuestions bool   `envDefault:"false"`
+	Mode                        string `envDefault:"dev"`
+	DbUrl                       string
+	KongUrl                     string
+	RedisUrl                    string
+	NotificationUrl             string
+	EmailWhitelist              []string
+	ValidateEmailWhitelist      []string // 已废弃，加入邮箱规则的例外地址，见 EMAIL_POLICY_FILE
+	EmailServerNoReplyUrl       url.URL  `env:"EMAIL_SERVER_NO_REPLY_URL"`
+	EmailDomain                 string
+	EmailDev                    string `envDefault:"dev@danta.tech"`
+	ShamirFeature               bool   `envDefault:"true"`
+	ShamirKeyCount              int    `envDefault:"7"`
+	ShamirSessionExpires        int    `envDefault:"1440"`
+	ShamirShareTTL              int    `env:"SHAMIR_SHARE_TTL" envDefault:"360"` // 上传的坐标点保存的分钟数，超过后需要重新上传
+	ShamirThreshold             int
+	ShamirPublicKeyDir          string // cmd/shamir-keygen 的输出目录，数据库中没有公钥时使用
+	ShamirAllowDemoKeys         bool   // 生产环境暂时允许使用示例公钥启动，仅用于轮换公钥
+	Standalone                  bool
+	VerificationCodeExpires     int    `envDefault:"10"`
+	VerificationCodeMaxAttempts int    `envDefault:"5"`
+	IdentifierRateLimit         int    `envDefault:"30"`        // 每个 IP 每分钟计算 identifier（argon2）的公开请求数，0 为不限制
+	ProxyHeader                 string `envDefault:"X-Real-IP"` // 客户端 IP 的请求头，由 kong 设置；直接暴露时设为空
+	ElevationExpires            int    `envDefault:"5"`
+	SiteName                    string `envDefault:"Open Tree Hole"`
+	EnableRegisterQuestions     bool   `envDefault:"false"`
+	WebhookMaxAttempts          int    `envDefault:"10"`
+	EmailTemplateDir            string `envDefault:"./data/email_templates"`
+	EmailPolicyFile             string `envDefault:"./data/email_policy.yaml"`
+	EmailBlocklistFile          string `envDefault:"./data/email_blocklist.txt"`
+	EmailDomainAliases          map[string]string
+	EmailStripPlusTag           bool
+	EmailTransport              string `envDefault:"smtps"`
+	EmailFileDir                string `envDefault:"./data/emails"`
+	EmailWorkers                int    `envDefault:"4"`
+	EmailMaxAttempts            int    `envDefault:"6"`
+	IdentifierSaltVersion       int
+	MetricsAddr                 string `envDefault:"0.0.0.0:9090"` // prometheus 指标的监听地址，与 API 分开，为空时不启用
 }
 
 var FileConfig struct {
 	IdentifierSalt     string `env:"IDENTIFIER_SALT,file" envDefault:"/var/run/secrets/identifier_salt" default:""`
+	IdentifierSalts    string `env:"IDENTIFIER_SALTS,file" envDefault:"/var/run/secrets/identifier_salts" default:""`
 	ProvisionKey       string `env:"PROVISION_KEY,file" envDefault:"/var/run/secrets/provision_key" default:""`
 	RegisterApikeySeed string `env:"REGISTER_APIKEY_SEED,file" envDefault:"/var/run/secrets/register_apikey_seed" default:""`
 	KongToken          string `env:"KONG_TOKEN,file" envDefault:"/var/run/secrets/kong_token" default:""`
+	ShamirSessionKey   string `env:"SHAMIR_SESSION_KEY,file" envDefault:"/var/run/secrets/shamir_session_key" default:""`
+	VerificationKey    string `env:"VERIFICATION_KEY,file" envDefault:"/var/run/secrets/verification_key" default:""`
 }
 
-var DecryptedIdentifierSalt []byte
+// IdentifierSalts 所有可用的 identifier salt，key 为版本号，IDENTIFIER_SALT 为版本 1
+var IdentifierSalts map[int]IdentifierSaltConfig
+
+// IdentifierSaltVersions 所有可用的 salt 版本，从新到旧排列
+var IdentifierSaltVersions []int
+
+// IdentifierSaltVersion 计算新 identifier 使用的 salt 版本
+var IdentifierSaltVersion int
+
 var RegisterApikeySecret string
 
+// ShamirSessionKey AES-256 密钥，用于加密保存在数据库中的 shamir 坐标点
+var ShamirSessionKey []byte
+
+// VerificationKey HMAC 密钥，用于计算验证码在缓存中的 key，与 identifier 的 salt 无关
+var VerificationKey []byte
+
 func InitConfig() {
 	var err error
 	err = env.ParseWithOptions(&Config, env.Options{UseFieldNameByDefault: true})
@@ -53,6 +98,9 @@ func InitConfig() {
 		if Config.DbUrl == "" {
 			innerErr = errors.Join(innerErr, errors.New("db url not set"))
 		}
+		if Config.EmailTransport == "file" || Config.EmailTransport == "memory" {
+			innerErr = errors.Join(innerErr, errors.New("email transport "+Config.EmailTransport+" is not allowed in production"))
+		}
 		if Config.EmailServerNoReplyUrl.String() == "" {
 			innerErr = errors.Join(innerErr, errors.New("email server no reply url not set"))
 		}
@@ -64,24 +112,132 @@ func InitConfig() {
 		}
 	}
 
-	log.Info().Any("config", Config).Send()
+	if len(Config.ValidateEmailWhitelist) > 0 {
+		log.Warn().
+			Int("emails", len(Config.ValidateEmailWhitelist)).
+			Msg("VALIDATE_EMAIL_WHITELIST is deprecated, its emails are added to the exceptions of EMAIL_POLICY_FILE; move them there")
+	}
+
+	initShamirConfig()
+
+	// don't log the redis password
+	loggedConfig := Config
+	loggedConfig.RedisUrl = redactURL(Config.RedisUrl)
+	log.Info().Any("config", loggedConfig).Send()
 
 	initFileConfig()
 
-	if FileConfig.IdentifierSalt == "" {
+	initIdentifierSalts()
+
+	initShamirSessionKey()
+
+	initVerificationKey()
+
+	RegisterApikeySecret = base32.StdEncoding.EncodeToString([]byte(FileConfig.RegisterApikeySeed))
+}
+
+// initShamirConfig 检查 shamir 公钥数量和门限，门限未设置时使用过半数
+func initShamirConfig() {
+	if !Config.ShamirFeature {
+		return
+	}
+	if Config.ShamirKeyCount < 1 {
+		log.Fatal().Int("shamir_key_count", Config.ShamirKeyCount).Msg("shamir key count should be at least 1")
+	}
+	if Config.ShamirThreshold == 0 {
+		Config.ShamirThreshold = Config.ShamirKeyCount/2 + 1
+	}
+	if Config.ShamirThreshold < 1 || Config.ShamirThreshold > Config.ShamirKeyCount {
+		log.Fatal().
+			Int("shamir_key_count", Config.ShamirKeyCount).
+			Int("shamir_threshold", Config.ShamirThreshold).
+			Msg("shamir threshold should be between 1 and shamir key count")
+	}
+	if Config.ShamirThreshold == 1 {
+		log.Warn().Msg("shamir threshold is 1, any single key holder can decrypt emails")
+	}
+	if Config.ShamirShareTTL < 1 || Config.ShamirShareTTL > Config.ShamirSessionExpires {
+		log.Warn().
+			Int("shamir_share_ttl", Config.ShamirShareTTL).
+			Int("shamir_session_expires", Config.ShamirSessionExpires).
+			Msg("shamir share ttl should be between 1 and shamir session expires, use shamir session expires")
+		Config.ShamirShareTTL = Config.ShamirSessionExpires
+	}
+}
+
+// initShamirSessionKey 加载 shamir 会话密钥，SHAMIR_SESSION_KEY 为 base64 编码的 32 字节密钥，
+// 所有实例必须使用相同的密钥
+func initShamirSessionKey() {
+	if !Config.ShamirFeature {
+		return
+	}
+	if FileConfig.ShamirSessionKey == "" {
 		if Config.Mode == "production" {
-			log.Fatal().Msg("identifier salt not set")
-		} else {
-			DecryptedIdentifierSalt = []byte("123456")
+			log.Fatal().Msg("shamir session key not set")
 		}
-	} else {
-		DecryptedIdentifierSalt, err = base64.StdEncoding.DecodeString(FileConfig.IdentifierSalt)
-		if err != nil {
-			log.Fatal().Err(err).Msg("decode identifier salt error")
+		log.Warn().Msg("shamir session key not set, using insecure development key")
+		key := sha256.Sum256([]byte("auth_next shamir session development key"))
+		ShamirSessionKey = key[:]
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(FileConfig.ShamirSessionKey))
+	if err != nil {
+		log.Fatal().Err(err).Msg("decode shamir session key error")
+	}
+	if len(key) != 32 {
+		log.Fatal().Int("length", len(key)).Msg("shamir session key should be 32 bytes")
+	}
+	ShamirSessionKey = key
+}
+
+// initVerificationKey 验证码 key 使用单独的 HMAC 密钥，不经过 argon2 等较慢的 identifier 算法
+func initVerificationKey() {
+	key := strings.TrimSpace(FileConfig.VerificationKey)
+	if key == "" {
+		if Config.Mode == "production" {
+			log.Fatal().Msg("verification key not set")
 		}
+		log.Warn().Msg("verification key not set, using insecure development key")
+		key = "auth_next verification development key"
 	}
+	VerificationKey = []byte(key)
+}
 
-	RegisterApikeySecret = base32.StdEncoding.EncodeToString([]byte(FileConfig.RegisterApikeySeed))
+// redacted 日志中替换敏感信息的字符串
+const redacted = "xxxxx"
+
+// redactURL 隐藏 URL 中的用户名和密码，以及名称包含 password、secret、token 的参数（如 sentinel_password）.
+// sentinel URL 的 host 为逗号分隔的列表，url.Parse 不接受，先取出 authority 再解析其余部分
+func redactURL(rawURL string) string {
+	schemeEnd := strings.Index(rawURL, "://")
+	if schemeEnd == -1 {
+		return rawURL
+	}
+	authorityStart := schemeEnd + len("://")
+	authorityEnd := len(rawURL)
+	if i := strings.IndexAny(rawURL[authorityStart:], "/?#"); i != -1 {
+		authorityEnd = authorityStart + i
+	}
+	authority := rawURL[authorityStart:authorityEnd]
+	if at := strings.LastIndex(authority, "@"); at != -1 {
+		authority = redacted + authority[at:]
+	}
+
+	rest, err := url.Parse(rawURL[authorityEnd:])
+	if err != nil {
+		// can't tell which part is sensitive
+		return rawURL[:authorityStart] + authority
+	}
+	query := rest.Query()
+	for key := range query {
+		lowerKey := strings.ToLower(key)
+		if strings.Contains(lowerKey, "password") || strings.Contains(lowerKey, "secret") || strings.Contains(lowerKey, "token") {
+			query[key] = []string{redacted}
+		}
+	}
+	rest.RawQuery = query.Encode()
+	return rawURL[:authorityStart] + authority + rest.String()
 }
 
 func initFileConfig() {
@@ -98,3 +254,107 @@ func initFileConfig() {
 		}
 	}
 }
+
+// initIdentifierSalts 加载 identifier salt.
+// IDENTIFIER_SALT 为版本 1；IDENTIFIER_SALTS 每行一个 salt，格式见 ParseIdentifierSalts，# 开头的行为注释.
+// 新 identifier 使用 IDENTIFIER_SALT_VERSION 指定的版本，未指定时使用最新版本；
+// 所有加载的版本都会用于查找用户，从文件中删除某个版本即停用该版本
+func initIdentifierSalts() {
+	salts, err := ParseIdentifierSalts(FileConfig.IdentifierSalts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("parse identifier salts error")
+	}
+
+	if FileConfig.IdentifierSalt != "" {
+		if _, ok := salts[1]; ok {
+			log.Fatal().Msg("identifier salt version 1 is set in both IDENTIFIER_SALT and IDENTIFIER_SALTS")
+		}
+		salt, err := base64.StdEncoding.DecodeString(FileConfig.IdentifierSalt)
+		if err != nil {
+			log.Fatal().Err(err).Msg("decode identifier salt error")
+		}
+		salts[1] = IdentifierSaltConfig{Algorithm: IdentifierAlgorithmPBKDF2, Salt: salt}
+	}
+
+	if len(salts) == 0 {
+		if Config.Mode == "production" {
+			log.Fatal().Msg("identifier salt not set")
+		}
+		salts[1] = IdentifierSaltConfig{Algorithm: IdentifierAlgorithmPBKDF2, Salt: []byte("123456")}
+	}
+
+	versions := make([]int, 0, len(salts))
+	for version, salt := range salts {
+		if salt.Wrap != 0 {
+			if _, ok := salts[salt.Wrap]; !ok {
+				log.Fatal().Int("version", version).Int("wrap", salt.Wrap).Msg("wrapped identifier salt version not found")
+			}
+		}
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	currentVersion := Config.IdentifierSaltVersion
+	if currentVersion == 0 {
+		currentVersion = versions[0]
+	} else if _, ok := salts[currentVersion]; !ok {
+		log.Fatal().Int("version", currentVersion).Msg("identifier salt version not found")
+	}
+
+	IdentifierSalts = salts
+	IdentifierSaltVersions = versions
+	IdentifierSaltVersion = currentVersion
+	log.Info().Ints("versions", versions).Int("current", currentVersion).Msg("identifier salts loaded")
+}
+
+// ParseIdentifierSalts 解析 IDENTIFIER_SALTS 文件内容，每行格式为
+//
+//	版本号 [算法] base64编码的salt或密钥 [选项,...]
+//
+// 算法为 pbkdf2（默认）、hmac 或 argon2id；选项为 key=value 格式，用逗号分隔:
+// wrap=N 表示以版本 N 的 identifier 作为输入，可以不经过邮箱明文迁移已有的 identifier；
+// t、m、p 为 argon2id 的迭代次数、内存（KiB）和并行度
+func ParseIdentifierSalts(content string) (map[int]IdentifierSaltConfig, error) {
+	salts := make(map[int]IdentifierSaltConfig)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			fields = []string{fields[0], IdentifierAlgorithmPBKDF2, fields[1]}
+		}
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expect \"version [algorithm] salt [options]\"", i+1)
+		}
+
+		version, err := strconv.Atoi(fields[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("line %d: invalid version %q", i+1, fields[0])
+		}
+		if _, ok := salts[version]; ok {
+			return nil, fmt.Errorf("line %d: duplicate version %d", i+1, version)
+		}
+
+		salt := IdentifierSaltConfig{Algorithm: fields[1]}
+		salt.Salt, err = base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if len(fields) == 4 {
+			err = salt.parseOptions(fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+		err = salt.validate(version)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		salts[version] = salt
+	}
+	return salts, nil
+}
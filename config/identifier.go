@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// IdentifierAlgorithmPBKDF2 PBKDF2-SHA3-512，一次迭代，仅用于兼容旧数据
+	IdentifierAlgorithmPBKDF2 = "pbkdf2"
+
+	// IdentifierAlgorithmHMAC HMAC-SHA3-512，密钥应与数据库分开保存，例如由 KMS 或 HSM 挂载
+	IdentifierAlgorithmHMAC = "hmac"
+
+	// IdentifierAlgorithmArgon2id argon2id，内存困难，增加枚举邮箱的成本
+	IdentifierAlgorithmArgon2id = "argon2id"
+)
+
+// argon2id 默认参数，见 golang.org/x/crypto/argon2 的推荐值
+const (
+	DefaultArgon2Time    = 1
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 4
+)
+
+// IdentifierSaltConfig 一个版本的 identifier 计算方式
+type IdentifierSaltConfig struct {
+	Algorithm string
+
+	// salt，hmac 算法为密钥
+	Salt []byte
+
+	// 不为 0 时，以该版本的 identifier 作为输入，而不是邮箱
+	Wrap int
+
+	// argon2id 参数
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (salt *IdentifierSaltConfig) parseOptions(options string) error {
+	for _, option := range strings.Split(options, ",") {
+		key, value, found := strings.Cut(option, "=")
+		if !found {
+			return fmt.Errorf("invalid option %q", option)
+		}
+		number, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || number == 0 {
+			return fmt.Errorf("invalid option %q", option)
+		}
+		switch key {
+		case "wrap":
+			salt.Wrap = int(number)
+		case "t":
+			salt.Time = uint32(number)
+		case "m":
+			salt.Memory = uint32(number)
+		case "p":
+			if number > 255 {
+				return fmt.Errorf("invalid option %q", option)
+			}
+			salt.Threads = uint8(number)
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return nil
+}
+
+func (salt *IdentifierSaltConfig) validate(version int) error {
+	switch salt.Algorithm {
+	case IdentifierAlgorithmPBKDF2, IdentifierAlgorithmHMAC:
+		if salt.Time != 0 || salt.Memory != 0 || salt.Threads != 0 {
+			return fmt.Errorf("options t, m and p are only for %s", IdentifierAlgorithmArgon2id)
+		}
+	case IdentifierAlgorithmArgon2id:
+		if salt.Time == 0 {
+			salt.Time = DefaultArgon2Time
+		}
+		if salt.Memory == 0 {
+			salt.Memory = DefaultArgon2Memory
+		}
+		if salt.Threads == 0 {
+			salt.Threads = DefaultArgon2Threads
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q", salt.Algorithm)
+	}
+	if salt.Algorithm == IdentifierAlgorithmHMAC && len(salt.Salt) < 32 {
+		return fmt.Errorf("hmac key should be at least 32 bytes")
+	}
+	if salt.Wrap >= version {
+		return fmt.Errorf("wrap version %d should be older than %d", salt.Wrap, version)
+	}
+	return nil
+}
@@ -16,6 +16,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -27,6 +29,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/opentreehole/go-common"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 
@@ -34,7 +37,9 @@ import (
 	"auth_next/config"
 	_ "auth_next/docs"
 	"auth_next/models"
+	"auth_next/utils"
 	"auth_next/utils/auth"
+	"auth_next/utils/i18n"
 	"auth_next/utils/kong"
 )
 
@@ -46,6 +51,7 @@ func init() {
 
 func main() {
 	config.InitConfig()
+	utils.InitMailer()
 	auth.InitVerificationCodeCache()
 	models.InitDB()
 	apis.Init()
@@ -59,16 +65,19 @@ func main() {
 	}
 
 	app := fiber.New(fiber.Config{
-		ErrorHandler:          common.ErrorHandler,
+		ErrorHandler:          i18n.ErrorHandler,
 		JSONEncoder:           json.Marshal,
 		JSONDecoder:           json.Unmarshal,
 		DisableStartupMessage: true,
 		BodyLimit:             128 * 1024 * 1024,
+		ProxyHeader:           config.Config.ProxyHeader,
+		EnableIPValidation:    true,
 	})
 	RegisterMiddlewares(app)
 	apis.RegisterRoutes(app)
 
 	cancel := startTasks()
+	metricsServer := startMetricsServer()
 
 	go func() {
 		err := app.Listen("0.0.0.0:8000")
@@ -88,23 +97,60 @@ func main() {
 	if err != nil {
 		log.Err(err).Msg("error shutdown app")
 	}
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(context.Background())
+		if err != nil {
+			log.Err(err).Msg("error shutdown metrics server")
+		}
+	}
 
 	// stop tasks
 	cancel()
 }
 
 func startTasks() context.CancelFunc {
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 
 	c := cron.New()
 	_, err := c.AddFunc("CRON_TZ=Asia/Shanghai 0 0 * * *", models.ActiveStatusTask) // run every day 00:00 +8:00
 	if err != nil {
 		log.Fatal().Err(err).Msg("cron add func failed")
 	}
+	_, err = c.AddFunc("CRON_TZ=Asia/Shanghai 30 3 * * *", models.PurgeEmailOutboxTask) // run every day 03:30 +8:00
+	if err != nil {
+		log.Fatal().Err(err).Msg("cron add func failed")
+	}
 	go c.Start()
+
+	go models.WebhookDeliveryTask(ctx)
+	go models.EmailOutboxTask(ctx)
+	go models.ShamirPublicKeyTask(ctx)
+	go models.ShamirSessionTask(ctx)
 	return cancel
 }
 
+// startMetricsServer 在单独的地址上提供 prometheus 指标，不经过 API 端口，避免被网关暴露
+func startMetricsServer() *http.Server {
+	if config.Config.MetricsAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:              config.Config.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("metrics server listen failed")
+		}
+	}()
+	return server
+}
+
 func RegisterMiddlewares(app *fiber.App) {
 	app.Use(recover.New(recover.Config{
 		EnableStackTrace:  true,
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计日志操作
+const (
+	AuditShamirDecryptRequest = "shamir.decrypt.request" // 申请解密用户邮箱
+	AuditShamirDecryptApprove = "shamir.decrypt.approve" // 受托人上传坐标点批准申请
+	AuditShamirDecryptCancel  = "shamir.decrypt.cancel"
+	AuditShamirDecryptExpire  = "shamir.decrypt.expire" // 申请过期，由后台任务结束
+	AuditShamirDecryptResult  = "shamir.decrypt.result" // 申请人获取解密结果，成功或失败
+	AuditUserLookupEmail      = "user.lookup_email"     // 管理员按邮箱查找用户，只记录 identifier
+)
+
+// AuditLog 敏感操作的审计日志，只追加不修改
+type AuditLog struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	ActorID      int       `json:"actor_id" gorm:"index"`
+	Action       string    `json:"action" gorm:"size:64;not null;index"`
+	ResourceID   string    `json:"resource_id,omitempty" gorm:"size:64;index"` // 例如解密申请 ID
+	TargetUserID int       `json:"target_user_id,omitempty" gorm:"index"`
+	Detail       Map       `json:"detail,omitempty" gorm:"type:text;serializer:json"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
+
+// CreateAuditLog 写入审计日志，tx 为 nil 时使用 DB；应与被记录的操作在同一个事务中
+func CreateAuditLog(tx *gorm.DB, auditLog *AuditLog) error {
+	if tx == nil {
+		tx = DB
+	}
+	return tx.Create(auditLog).Error
+}
+
+// ListAuditLogs 按条件查询审计日志，最新的在前；零值条件不过滤
+func ListAuditLogs(filter AuditLog, offset, size int) ([]AuditLog, error) {
+	querySet := DB.Model(&AuditLog{})
+	if filter.ActorID != 0 {
+		querySet = querySet.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		querySet = querySet.Where("action = ?", filter.Action)
+	}
+	if filter.ResourceID != "" {
+		querySet = querySet.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.TargetUserID != 0 {
+		querySet = querySet.Where("target_user_id = ?", filter.TargetUserID)
+	}
+
+	auditLogs := make([]AuditLog, 0, size)
+	err := querySet.Order("id desc").Offset(offset).Limit(size).Find(&auditLogs).Error
+	return auditLogs, err
+}
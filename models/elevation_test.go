@@ -0,0 +1,66 @@
+package models
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/gofiber/fiber/v2"
+
+	"auth_next/config"
+)
+
+func TestCheckElevated(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.Standalone = true
+	config.Config.ElevationExpires = 5
+	ConnectDB()
+
+	user := User{Nickname: "elevation"}
+	err := DB.Create(&user).Error
+	assert.Equal(t, err, nil)
+
+	access, _, err := user.CreateJWTToken()
+	assert.Equal(t, err, nil)
+	elevated, err := user.CreateElevatedJWTToken(ElevationMethodPassword)
+	assert.Equal(t, err, nil)
+
+	app := fiber.New()
+	app.Get("/:id", func(c *fiber.Ctx) error {
+		userID, err := c.ParamsInt("id")
+		if err != nil {
+			return err
+		}
+		err = CheckElevated(c, userID)
+		if err != nil {
+			return fiber.ErrForbidden
+		}
+		return c.SendStatus(204)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		userID int
+		status int
+	}{
+		{"elevated", elevated, user.ID, 204},
+		{"normal access token", access, user.ID, 403},
+		{"other user", elevated, user.ID + 1, 403},
+		{"tampered", elevated + "x", user.ID, 403},
+		{"no token", "", user.ID, 403},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/"+strconv.Itoa(tt.userID), nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := app.Test(req)
+		assert.Equal(t, err, nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expect status %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+}
@@ -1,32 +1,172 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+
+	"github.com/thanhpk/randstr"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"auth_next/utils/auth"
 )
 
+// ErrIdentifierCollision 当前的 identifier 已被其他账号使用
+var ErrIdentifierCollision = errors.New("identifier used by another user")
+
 type DeleteIdentifier struct {
 	UserID     int    `json:"user_id" gorm:"primaryKey"`
-	Identifier string `json:"identifier" gorm:"size:128;uniqueIndex:,length:10"`
+	Identifier string `json:"identifier" gorm:"size:160;uniqueIndex:idx_delete_identifier_prefix,length:32"`
 }
 
 func HasRegisteredEmail(tx *gorm.DB, email string) (bool, error) {
 	var exists bool
-	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM user WHERE identifier = ?)", auth.MakeIdentifier(email)).Scan(&exists).Error
+	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM user WHERE identifier IN ?)", auth.IdentifierCandidates(email)).Scan(&exists).Error
 	return exists, err
 }
 
 func HasDeletedEmail(tx *gorm.DB, email string) (bool, error) {
 	var exists bool
-	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM delete_identifier WHERE identifier = ?)", auth.MakeIdentifier(email)).Scan(&exists).Error
+	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM delete_identifier WHERE identifier IN ?)", auth.IdentifierCandidates(email)).Scan(&exists).Error
 	return exists, err
 }
 
+// 按邮箱查找用户的结果
+const (
+	EmailLookupActive   = "active"
+	EmailLookupInactive = "inactive" // 已被封禁
+	EmailLookupDeleted  = "deleted"
+	EmailLookupNotFound = "not_found"
+)
+
+// EmailLookupResult 邮箱对应的用户，只包含用户 ID 和状态
+type EmailLookupResult struct {
+	Email  string `json:"email"`
+	UserID int    `json:"user_id,omitempty"`
+	Status string `json:"status" enums:"active,inactive,deleted,not_found"`
+}
+
+// LookupUsersByEmail 通过 identifier 查找邮箱对应的用户，不需要解密邮箱.
+// 每个邮箱写入一条审计日志，同一批次的 ResourceID 相同；日志只记录当前的 identifier，不记录明文邮箱
+func LookupUsersByEmail(actorID int, emails []string, reason string) ([]EmailLookupResult, error) {
+	candidates := make([][]string, len(emails))
+	allCandidates := make([]string, 0, len(emails))
+	for i, email := range emails {
+		candidates[i] = auth.IdentifierCandidates(email)
+		allCandidates = append(allCandidates, candidates[i]...)
+	}
+
+	var users []User
+	err := DB.Select("id", "identifier", "is_active").
+		Where("identifier IN ?", allCandidates).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	var deleted []DeleteIdentifier
+	err = DB.Where("identifier IN ?", allCandidates).Find(&deleted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]EmailLookupResult, len(users)+len(deleted))
+	for _, deleteIdentifier := range deleted {
+		found[deleteIdentifier.Identifier] = EmailLookupResult{UserID: deleteIdentifier.UserID, Status: EmailLookupDeleted}
+	}
+	for _, user := range users {
+		status := EmailLookupActive
+		if !user.IsActive {
+			status = EmailLookupInactive
+		}
+		found[user.Identifier.String] = EmailLookupResult{UserID: user.ID, Status: status}
+	}
+
+	batchID := randstr.Hex(16)
+	results := make([]EmailLookupResult, len(emails))
+	auditLogs := make([]AuditLog, len(emails))
+	for i, email := range emails {
+		results[i] = EmailLookupResult{Email: email, Status: EmailLookupNotFound}
+		// 注册的账号优先于已注销的账号
+		for _, identifier := range candidates[i] {
+			result, ok := found[identifier]
+			if ok && (results[i].Status == EmailLookupNotFound || results[i].Status == EmailLookupDeleted && result.Status != EmailLookupDeleted) {
+				results[i].UserID, results[i].Status = result.UserID, result.Status
+			}
+		}
+		auditLogs[i] = AuditLog{
+			ActorID:      actorID,
+			Action:       AuditUserLookupEmail,
+			ResourceID:   batchID,
+			TargetUserID: results[i].UserID,
+			Detail:       Map{"identifier": candidates[i][0], "status": results[i].Status, "reason": reason},
+		}
+	}
+
+	err = DB.CreateInBatches(auditLogs, 500).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// IdentifierVersionCount 统计每个 salt 版本的 identifier 数量，key 为版本号，0 为已停用或无法识别的版本
+func IdentifierVersionCount(tx *gorm.DB, model any) (map[int]int64, error) {
+	var total int64
+	err := tx.Model(model).Where("identifier IS NOT NULL").Count(&total).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int64)
+	var known int64
+	for _, version := range auth.IdentifierVersions() {
+		query := tx.Model(model)
+		if version == 1 {
+			// identifiers of version 1 have no prefix
+			query = query.Where("identifier IS NOT NULL AND identifier NOT LIKE ?", "v%")
+		} else {
+			query = query.Where("identifier LIKE ?", auth.IdentifierVersionPattern(version))
+		}
+		var count int64
+		err = query.Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		counts[version] = count
+		known += count
+	}
+	counts[0] = total - known
+	return counts, nil
+}
+
 func AddDeletedIdentifier(tx *gorm.DB, userID int, identifier string) error {
 	deleteIdentifier := DeleteIdentifier{UserID: userID, Identifier: identifier}
 	return tx.
 		Clauses(clause.OnConflict{DoNothing: true}).
 		Create(&deleteIdentifier).Error
 }
+
+// UpgradeIdentifier 用户通过邮箱验证身份后，将旧的 identifier 更新为当前的 identifier.
+// 如果当前的 identifier 已被其他账号使用，说明存在重复账号，保留旧的 identifier 并返回 ErrIdentifierCollision，
+// 由调用者决定是否继续；先查询再更新，冲突时不会因唯一索引报错而中断事务
+func UpgradeIdentifier(tx *gorm.DB, user *User, email string) error {
+	identifier := auth.MakeIdentifier(email)
+	if !user.Identifier.Valid || user.Identifier.String == identifier {
+		return nil
+	}
+
+	var collision bool
+	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM user WHERE identifier = ? AND id <> ?)", identifier, user.ID).Scan(&collision).Error
+	if err != nil {
+		return err
+	}
+	if collision {
+		return ErrIdentifierCollision
+	}
+
+	err = tx.Model(user).UpdateColumn("identifier", identifier).Error
+	if err != nil {
+		return err
+	}
+	user.Identifier = sql.NullString{String: identifier, Valid: true}
+	return nil
+}
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thanhpk/randstr"
+	"gorm.io/gorm"
+
+	"auth_next/config"
+	"auth_next/utils"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSending = "sending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+const (
+	EmailPurposeShamirUpdate = "shamir_update"
+)
+
+// EmailOutbox 待发送的邮件.
+// 接收者和正文（可能包含验证码）只在发送前保存，发送成功或最终失败后立即清空，
+// 保留的元数据供客户端查询状态和管理员排查
+type EmailOutbox struct {
+	ID            string     `json:"id" gorm:"primaryKey;size:32"`
+	Purpose       string     `json:"purpose" gorm:"size:32"`
+	Receivers     []string   `json:"-" gorm:"serializer:json"`
+	Subject       string     `json:"-" gorm:"size:256"`
+	Text          string     `json:"-"`
+	HTML          string     `json:"-"`
+	Status        string     `json:"status" gorm:"size:16;index:idx_email_outbox_due,priority:1"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index:idx_email_outbox_due,priority:2"`
+	LastError     string     `json:"last_error,omitempty" gorm:"size:1024"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// emailOutboxNotify wakes up the dispatcher after enqueueing
+var emailOutboxNotify = make(chan struct{}, 1)
+
+// EnqueueEmail 将邮件写入发件箱，由 EmailOutboxTask 异步发送
+func EnqueueEmail(tx *gorm.DB, purpose, subject, text, html string, receivers []string) (*EmailOutbox, error) {
+	outbox := EmailOutbox{
+		ID:            randstr.Hex(16),
+		Purpose:       purpose,
+		Receivers:     receivers,
+		Subject:       subject,
+		Text:          text,
+		HTML:          html,
+		Status:        EmailStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	err := tx.Create(&outbox).Error
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case emailOutboxNotify <- struct{}{}:
+	default:
+	}
+	return &outbox, nil
+}
+
+// emailRetryDelay 指数退避，10s, 20s, 40s ... 最长 10min
+func emailRetryDelay(attempts int) time.Duration {
+	const maxDelay = 10 * time.Minute
+	delay := 10 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+// EmailOutboxTask 启动发件箱的 worker pool，直到 ctx 结束
+func EmailOutboxTask(ctx context.Context) {
+	taskChan := make(chan EmailOutbox, 100)
+	defer close(taskChan)
+
+	for i := 0; i < config.Config.EmailWorkers; i++ {
+		go func() {
+			for outbox := range taskChan {
+				outbox := outbox
+				err := SendOutboxEmail(&outbox)
+				if err != nil {
+					log.Warn().Err(err).
+						Str("email_id", outbox.ID).
+						Str("purpose", outbox.Purpose).
+						Int("attempts", outbox.Attempts).
+						Msg("send email failed")
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-emailOutboxNotify:
+		}
+
+		err := dispatchDueEmails(taskChan)
+		if err != nil {
+			log.Err(err).Msg("dispatch emails failed")
+		}
+	}
+}
+
+func dispatchDueEmails(taskChan chan<- EmailOutbox) error {
+	var outboxes []EmailOutbox
+	err := DB.
+		Where("status IN ? AND next_attempt_at <= ?", []string{EmailStatusPending, EmailStatusSending}, time.Now()).
+		Order("next_attempt_at asc").
+		Limit(100).
+		Find(&outboxes).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range outboxes {
+		outbox := outboxes[i]
+
+		// claim the email, a message stuck in sending status is retried after the lease expires
+		lease := time.Now().Add(2 * time.Minute)
+		result := DB.Model(&EmailOutbox{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", outbox.ID, outbox.Status, outbox.NextAttemptAt).
+			Updates(Map{"status": EmailStatusSending, "next_attempt_at": lease})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		outbox.Status = EmailStatusSending
+		outbox.NextAttemptAt = lease
+		taskChan <- outbox
+	}
+	return nil
+}
+
+// SendOutboxEmail 发送一封邮件并记录结果，失败时按照指数退避安排重试
+func SendOutboxEmail(outbox *EmailOutbox) error {
+	sendErr := utils.SendEmailWithHTML(outbox.Subject, outbox.Text, outbox.HTML, outbox.Receivers)
+
+	outbox.Attempts++
+	if sendErr == nil {
+		now := time.Now()
+		outbox.Status = EmailStatusSent
+		outbox.SentAt = &now
+		outbox.LastError = ""
+	} else {
+		outbox.LastError = sendErr.Error()
+		if len(outbox.LastError) > 1024 {
+			outbox.LastError = outbox.LastError[:1024]
+		}
+		if outbox.Attempts >= config.Config.EmailMaxAttempts {
+			outbox.Status = EmailStatusFailed
+		} else {
+			outbox.Status = EmailStatusPending
+			outbox.NextAttemptAt = time.Now().Add(emailRetryDelay(outbox.Attempts))
+		}
+	}
+
+	if outbox.Status != EmailStatusPending {
+		// never keep receivers and contents longer than needed
+		outbox.Receivers = []string{}
+		outbox.Subject = ""
+		outbox.Text = ""
+		outbox.HTML = ""
+	}
+
+	err := DB.Select("Receivers", "Subject", "Text", "HTML", "Status", "Attempts", "NextAttemptAt", "LastError", "SentAt").
+		Updates(outbox).Error
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// PurgeEmailOutboxTask 清理七天前已经结束的邮件记录
+func PurgeEmailOutboxTask() {
+	err := DB.
+		Where("status IN ? AND created_at < ?", []string{EmailStatusSent, EmailStatusFailed}, time.Now().AddDate(0, 0, -7)).
+		Delete(&EmailOutbox{}).Error
+	if err != nil {
+		log.Err(err).Msg("purge email outbox err")
+	}
+}
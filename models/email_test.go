@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+	"auth_next/utils/auth"
+)
+
+func TestLookupUsersByEmail(t *testing.T) {
+	config.Config.Mode = "test"
+	ConnectDB()
+
+	active := User{Nickname: "lookup", Identifier: sql.NullString{String: auth.MakeIdentifier("active@example.com"), Valid: true}, IsActive: true}
+	err := DB.Create(&active).Error
+	assert.Equal(t, err, nil)
+	banned := User{Nickname: "lookup", Identifier: sql.NullString{String: auth.MakeIdentifier("banned@example.com"), Valid: true}}
+	err = DB.Create(&banned).Error
+	assert.Equal(t, err, nil)
+	err = DB.Model(&banned).Update("is_active", false).Error
+	assert.Equal(t, err, nil)
+	err = AddDeletedIdentifier(DB, 9999, auth.MakeIdentifier("deleted@example.com"))
+	assert.Equal(t, err, nil)
+
+	emails := []string{"active@example.com", "banned@example.com", "deleted@example.com", "nobody@example.com"}
+	results, err := LookupUsersByEmail(1, emails, "abuse report 42")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, results, []EmailLookupResult{
+		{Email: "active@example.com", UserID: active.ID, Status: EmailLookupActive},
+		{Email: "banned@example.com", UserID: banned.ID, Status: EmailLookupInactive},
+		{Email: "deleted@example.com", UserID: 9999, Status: EmailLookupDeleted},
+		{Email: "nobody@example.com", Status: EmailLookupNotFound},
+	})
+
+	// every email is audited with its identifier, never the plaintext email
+	auditLogs, err := ListAuditLogs(AuditLog{ActorID: 1, Action: AuditUserLookupEmail}, 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(auditLogs), 4)
+	assert.Equal(t, auditLogs[0].ResourceID, auditLogs[3].ResourceID)
+	assert.Equal(t, auditLogs[3].TargetUserID, active.ID)
+	assert.Equal(t, auditLogs[3].Detail["identifier"], auth.MakeIdentifier("active@example.com"))
+	assert.Equal(t, auditLogs[3].Detail["reason"], "abuse report 42")
+}
+
+func TestUpgradeIdentifier(t *testing.T) {
+	config.Config.Mode = "test"
+	ConnectDB()
+
+	legacy := User{Nickname: "upgrade", Identifier: sql.NullString{String: "legacy-upgrade", Valid: true}, IsActive: true}
+	err := DB.Create(&legacy).Error
+	assert.Equal(t, err, nil)
+	err = UpgradeIdentifier(DB, &legacy, "upgrade@example.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, legacy.Identifier.String, auth.MakeIdentifier("upgrade@example.com"))
+
+	// the current identifier is used by another account, keep the old one
+	duplicated := User{Nickname: "upgrade", Identifier: sql.NullString{String: "legacy-duplicated", Valid: true}, IsActive: true}
+	err = DB.Create(&duplicated).Error
+	assert.Equal(t, err, nil)
+	err = UpgradeIdentifier(DB, &duplicated, "upgrade@example.com")
+	assert.Equal(t, err, ErrIdentifierCollision)
+	assert.Equal(t, duplicated.Identifier.String, "legacy-duplicated")
+}
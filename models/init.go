@@ -16,7 +16,7 @@ import (
 
 func InitDB() {
 	// connect to database and auto migrate models
-	initDB()
+	ConnectDB()
 
 	// get admin list for admin check and start admin refresh task
 	InitAdminList()
@@ -45,7 +45,8 @@ var gormConfig = &gorm.Config{
 	),
 }
 
-func initDB() {
+// ConnectDB connect to database and auto migrate models, without loading caches
+func ConnectDB() {
 	mysqlDB := func() (*gorm.DB, error) {
 		return gorm.Open(mysql.Open(config.Config.DbUrl), gormConfig)
 	}
@@ -105,12 +106,20 @@ func initDB() {
 		ShamirEmail{},
 		ActiveStatus{},
 		DeleteIdentifier{},
+		WebhookSubscription{},
+		WebhookDelivery{},
+		EmailOutbox{},
+		AuditLog{},
 	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("auto migrate failed")
 	}
+	err = dropLegacyIndexes()
+	if err != nil {
+		log.Fatal().Err(err).Msg("drop legacy indexes failed")
+	}
 	if config.Config.ShamirFeature {
-		err = DB.AutoMigrate(ShamirPublicKey{})
+		err = DB.AutoMigrate(ShamirPublicKey{}, ShamirKeySet{}, ShamirCommitment{}, ShamirSession{}, ShamirSessionShare{})
 		if err != nil {
 			log.Fatal().Err(err).Msg("auto migrate failed")
 		}
@@ -122,3 +131,30 @@ func initDB() {
 		}
 	}
 }
+
+// dropLegacyIndexes 删除已被替代的唯一索引.
+// 旧 identifier 索引只包含前 10 个字符，带版本前缀的 identifier 会使索引的有效长度过短，已被更长的索引替代；
+// 旧 shamir_email 索引不包含 key_set_id，不同 key set 的 shamir_email 无法并存；
+// 旧 shamir_session_share 索引不包含 chunk，不能分块上传
+func dropLegacyIndexes() error {
+	legacyIndexes := []struct {
+		model any
+		name  string
+	}{
+		{&User{}, "idx_user_identifier"},
+		{&DeleteIdentifier{}, "idx_delete_identifier_identifier"},
+		{&ShamirEmail{}, "idx_key_uid"},
+		{&ShamirSessionShare{}, "idx_shamir_session_identity"},
+	}
+	for _, index := range legacyIndexes {
+		if !DB.Migrator().HasIndex(index.model, index.name) {
+			continue
+		}
+		err := DB.Migrator().DropIndex(index.model, index.name)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("index", index.name).Msg("legacy index dropped")
+	}
+	return nil
+}
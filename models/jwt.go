@@ -28,6 +28,10 @@ type UserClaims struct {
 	JoinedTime           time.Time `json:"joined_time"`
 	IsAdmin              bool      `json:"is_admin"`
 	HasAnsweredQuestions bool      `json:"has_answered_questions"`
+
+	// 二次验证的时间和方式，只在 CreateElevatedJWTToken 签发的 token 中存在
+	ElevatedAt      *jwt.NumericDate `json:"elevated_at,omitempty"`
+	ElevationMethod string           `json:"elevation_method,omitempty"`
 }
 
 const (
@@ -35,10 +39,8 @@ const (
 	JWTTypeRefresh = "refresh"
 )
 
-func (user *User) CreateJWTToken() (accessToken, refreshToken string, err error) {
-	// get jwt key and secret
-	var key, secret string
-
+// jwtKeyAndSecret 获取用户签发 JWT 的 key 和 secret
+func (user *User) jwtKeyAndSecret() (key, secret string, err error) {
 	if config.Config.Standalone {
 		// no gateway, store jwt secret in database
 		var userJwtSecret UserJwtSecret
@@ -58,13 +60,16 @@ func (user *User) CreateJWTToken() (accessToken, refreshToken string, err error)
 			}
 		}
 
-		key = fmt.Sprintf("user_%d", user.ID)
-		secret = userJwtSecret.Secret
-	} else {
-		key, secret, err = kong.GetJwtSecret(user.ID)
-		if err != nil {
-			return "", "", err
-		}
+		return fmt.Sprintf("user_%d", user.ID), userJwtSecret.Secret, nil
+	}
+	return kong.GetJwtSecret(user.ID)
+}
+
+func (user *User) CreateJWTToken() (accessToken, refreshToken string, err error) {
+	// get jwt key and secret
+	key, secret, err := user.jwtKeyAndSecret()
+	if err != nil {
+		return "", "", err
 	}
 
 	// create JWT token
@@ -1,11 +1,17 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
 
 	"auth_next/config"
@@ -14,19 +20,69 @@ import (
 
 type ShamirEmail struct {
 	ID          int    `json:"id" gorm:"primaryKey"`
-	UserID      int    `json:"user_id" gorm:"uniqueIndex:idx_key_uid,priority:2"`
-	EncryptedBy string `json:"encrypted_by" gorm:"uniqueIndex:idx_key_uid,priority:1,length:5"`
+	KeySetID    int    `json:"key_set_id" gorm:"uniqueIndex:idx_shamir_email_key_set_uid,priority:1"`
+	UserID      int    `json:"user_id" gorm:"uniqueIndex:idx_shamir_email_key_set_uid,priority:3"`
+	EncryptedBy string `json:"encrypted_by" gorm:"uniqueIndex:idx_shamir_email_key_set_uid,priority:2,length:5"`
 	Key         string `json:"key"`
+
+	// 切换 key set 时从该 key set 复制而来，仍使用旧的公钥加密，管理员不会获取；用户下次登录时重新加密
+	CarriedFromKeySetID int `json:"-" gorm:"not null;default:0"`
+}
+
+// ShamirCommitment 拆分用户邮箱时生成的 Pedersen 承诺，用于逐个验证管理员上传的坐标点；
+// 旧数据没有承诺，坐标点不验证
+type ShamirCommitment struct {
+	ID          int    `json:"id" gorm:"primaryKey"`
+	KeySetID    int    `json:"key_set_id" gorm:"uniqueIndex:idx_shamir_commitment_key_set_uid,priority:1"`
+	UserID      int    `json:"user_id" gorm:"uniqueIndex:idx_shamir_commitment_key_set_uid,priority:2"`
+	Commitments string `json:"commitments" gorm:"type:text;not null"`
 }
 
 type ShamirPublicKey struct {
 	ID               int             `json:"id" gorm:"primaryKey"`
+	KeySetID         int             `json:"key_set_id" gorm:"index"`
 	IdentityName     string          `json:"identity_name" gorm:"not null"`
 	ArmoredPublicKey string          `json:"armored_public_key" gorm:"not null"`
 	PublicKey        *crypto.KeyRing `json:"-" gorm:"-"`
 }
 
-var ShamirPublicKeys []ShamirPublicKey
+// ShamirKeySet 一组 shamir 公钥，邮箱被拆分为 KeyCount 份，任意 Threshold 份可以解密.
+// 每个 key set 是 shamir_email 的一代，同时只有一个 active 的 key set
+type ShamirKeySet struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	KeyCount  int       `json:"key_count" gorm:"not null"`
+	Threshold int       `json:"threshold" gorm:"not null"`
+	Status    string    `json:"status" gorm:"size:16;not null;default:active;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// shamirCurrentKeys 当前 active 的 key set 和公钥，切换时整体替换，读取时不需要加锁
+type shamirCurrentKeys struct {
+	keySet     ShamirKeySet
+	publicKeys []ShamirPublicKey
+}
+
+var shamirCurrent atomic.Pointer[shamirCurrentKeys]
+
+// CurrentShamirKeySet 当前 shamir 公钥对应的 key set，由 ShamirPublicKeyTask 每分钟与数据库同步
+func CurrentShamirKeySet() ShamirKeySet {
+	keySet, _ := CurrentShamirKeys()
+	return keySet
+}
+
+// CurrentShamirKeys 同一次切换的 key set 和公钥，返回的公钥不可修改
+func CurrentShamirKeys() (ShamirKeySet, []ShamirPublicKey) {
+	current := shamirCurrent.Load()
+	if current == nil {
+		return ShamirKeySet{}, nil
+	}
+	return current.keySet, current.publicKeys
+}
+
+// SetCurrentShamirKeys 切换当前的 key set 和公钥
+func SetCurrentShamirKeys(keySet ShamirKeySet, publicKeys []ShamirPublicKey) {
+	shamirCurrent.Store(&shamirCurrentKeys{keySet: keySet, publicKeys: publicKeys})
+}
 
 // InitShamirPublicKey initialize shamir public key.
 // if found in database, load from database,
@@ -36,28 +92,27 @@ func InitShamirPublicKey() {
 		return
 	}
 
-	ShamirPublicKeys = make([]ShamirPublicKey, 0)
-	err := DB.Find(&ShamirPublicKeys).Error
+	var keySet ShamirKeySet
+	err := DB.Where("status = ?", ShamirKeySetActive).Order("id desc").Limit(1).Find(&keySet).Error
+	if err != nil {
+		log.Fatal().Err(err).Msg("load shamir key set failed")
+	}
+
+	publicKeys := make([]ShamirPublicKey, 0)
+	if keySet.ID != 0 {
+		err = DB.Where("key_set_id = ?", keySet.ID).Order("id").Find(&publicKeys).Error
+	} else {
+		err = DB.Order("id").Find(&publicKeys).Error
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("load shamir public key failed")
 	}
 
 	// check if stored public keys in the database
-	if len(ShamirPublicKeys) == 0 {
+	if len(publicKeys) == 0 {
 		// no public key found, generate using default keys
-		log.Warn().Msg("no public key found in database, using default keys")
-
-		// load keys from data dir
-		for i := 1; i <= config.DefaultShamirKeyCount; i++ {
-			filename := fmt.Sprintf("data/%d-public.key", i)
-
-			// read public key
-			armoredPublicKeyBytes, err := os.ReadFile(filename)
-			if err != nil {
-				log.Fatal().Err(err).Msg("read default public key failed")
-			}
-			armoredPublicKey := string(armoredPublicKeyBytes)
-
+		armoredPublicKeys := defaultShamirPublicKeys()
+		for i, armoredPublicKey := range armoredPublicKeys {
 			// parse key
 			key, err := crypto.NewKeyFromArmored(armoredPublicKey)
 			if err != nil {
@@ -71,87 +126,407 @@ func InitShamirPublicKey() {
 			}
 
 			// append to public key list
-			ShamirPublicKeys = append(ShamirPublicKeys, ShamirPublicKey{
-				ID:               i,
+			publicKeys = append(publicKeys, ShamirPublicKey{
+				ID:               i + 1,
 				IdentityName:     key.GetEntity().PrimaryIdentity().Name,
 				ArmoredPublicKey: armoredPublicKey,
 				PublicKey:        keyRing,
 			})
 		}
 
-		// save public key list to database
-		err := DB.Save(&ShamirPublicKeys).Error
+		// save public key list and key set to database
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			return SaveShamirKeySet(tx, &keySet, publicKeys, config.Config.ShamirThreshold)
+		})
 		if err != nil {
 			log.Fatal().Err(err).Msg("save default public key failed")
 		}
 	} else {
 		// check public key validity
-		for i := range ShamirPublicKeys {
-			identityName := ShamirPublicKeys[i].IdentityName
+		err = ParseShamirPublicKeys(publicKeys)
+		if err != nil {
+			log.Fatal().Err(err).Msg("please check your database")
+		}
 
-			// parse key
-			key, err := crypto.NewKeyFromArmored(ShamirPublicKeys[i].ArmoredPublicKey)
+		if keySet.ID == 0 {
+			// public keys saved before key sets were introduced, shamir emails were encrypted with threshold num/2 + 1
+			log.Warn().Int("key_count", len(publicKeys)).Msg("no shamir key set found, creating from stored public keys")
+			err = DB.Transaction(func(tx *gorm.DB) error {
+				return SaveShamirKeySet(tx, &keySet, publicKeys, len(publicKeys)/2+1)
+			})
 			if err != nil {
-				log.Fatal().Err(err).Str("identity_name", identityName).Msg("parse key failed")
+				log.Fatal().Err(err).Msg("save shamir key set failed")
 			}
+		}
 
-			// check identity name
-			if key.GetEntity().PrimaryIdentity().Name != identityName {
-				log.Fatal().
-					Str("identity_name", identityName).
-					Msg("identity name not in public key, please check your database")
-			}
+		if keySet.KeyCount != len(publicKeys) {
+			log.Fatal().
+				Int("key_count", keySet.KeyCount).
+				Int("public_keys", len(publicKeys)).
+				Msg("number of shamir public keys doesn't match the key set, please check your database")
+		}
+	}
 
-			// transform public key to key ring
-			ShamirPublicKeys[i].PublicKey, err = crypto.NewKeyRing(key)
+	SetCurrentShamirKeys(keySet, publicKeys)
+	checkShamirDemoKeys(publicKeys)
+
+	// shamir emails created before key sets were introduced belong to the current key set
+	result := DB.Model(&ShamirEmail{}).Where("key_set_id = 0").Update("key_set_id", keySet.ID)
+	if result.Error != nil {
+		log.Fatal().Err(result.Error).Msg("update key set of shamir emails failed")
+	}
+	if result.RowsAffected > 0 {
+		log.Info().Int64("rows", result.RowsAffected).Int("key_set_id", keySet.ID).Msg("shamir emails assigned to key set")
+	}
+
+	if keySet.KeyCount != config.Config.ShamirKeyCount || keySet.Threshold != config.Config.ShamirThreshold {
+		log.Warn().
+			Int("key_count", keySet.KeyCount).
+			Int("threshold", keySet.Threshold).
+			Msg("current shamir key set differs from config, upload new public keys and update shamir to apply")
+	}
+}
+
+// defaultShamirPublicKeys 数据库中没有公钥时使用的公钥：SHAMIR_PUBLIC_KEY_DIR 中 cmd/shamir-keygen 生成的公钥，
+// 未设置时使用 data 目录中的示例公钥，生产环境不允许使用示例公钥
+func defaultShamirPublicKeys() []string {
+	if config.Config.ShamirPublicKeyDir != "" {
+		manifest, armoredPublicKeys, err := shamir.LoadKeyManifest(config.Config.ShamirPublicKeyDir)
+		if err != nil {
+			log.Fatal().Err(err).Str("dir", config.Config.ShamirPublicKeyDir).Msg("load shamir public keys failed")
+		}
+		if len(armoredPublicKeys) != config.Config.ShamirKeyCount {
+			log.Fatal().
+				Int("shamir_key_count", config.Config.ShamirKeyCount).
+				Int("public_keys", len(armoredPublicKeys)).
+				Msg("number of shamir public keys in manifest doesn't match shamir key count")
+		}
+		log.Info().Time("created_at", manifest.CreatedAt).Int("keys", len(armoredPublicKeys)).
+			Msg("no public key found in database, using keys from manifest")
+		return armoredPublicKeys
+	}
+
+	if config.Config.Mode == "production" {
+		log.Fatal().Msg("no public key found in database, generate keys with cmd/shamir-keygen and set SHAMIR_PUBLIC_KEY_DIR")
+	}
+	log.Warn().Msg("no public key found in database, using default keys")
+	if config.Config.ShamirKeyCount > config.DefaultShamirKeyCount {
+		log.Fatal().
+			Int("shamir_key_count", config.Config.ShamirKeyCount).
+			Msgf("only %d default keys, please upload public keys", config.DefaultShamirKeyCount)
+	}
+
+	// load keys from data dir
+	armoredPublicKeys := make([]string, 0, config.Config.ShamirKeyCount)
+	for i := 1; i <= config.Config.ShamirKeyCount; i++ {
+		filename := fmt.Sprintf("data/%d-public.key", i)
+
+		// read public key
+		armoredPublicKeyBytes, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal().Err(err).Msg("read default public key failed")
+		}
+		armoredPublicKeys = append(armoredPublicKeys, string(armoredPublicKeyBytes))
+	}
+	return armoredPublicKeys
+}
+
+// checkShamirDemoKeys 生产环境拒绝使用示例公钥启动，示例私钥在仓库中，任何人都可以解密邮箱；
+// SHAMIR_ALLOW_DEMO_KEYS 允许暂时启动以轮换公钥
+func checkShamirDemoKeys(publicKeys []ShamirPublicKey) {
+	if config.Config.Mode != "production" {
+		return
+	}
+	err := CheckShamirDemoKeys(publicKeys)
+	if err == nil {
+		return
+	}
+	if config.Config.ShamirAllowDemoKeys {
+		log.Error().Err(err).Msg("shamir public keys include demo keys, generate keys with cmd/shamir-keygen and rotate them now")
+		return
+	}
+	log.Fatal().Err(err).Msg("refuse to start with shamir demo keys, set SHAMIR_ALLOW_DEMO_KEYS to start and rotate them")
+}
+
+// CheckShamirDemoKeys 任何一个公钥是仓库中的示例公钥时返回 shamir.ErrDemoKey
+func CheckShamirDemoKeys(publicKeys []ShamirPublicKey) error {
+	armoredPublicKeys := make([]string, len(publicKeys))
+	for i := range publicKeys {
+		armoredPublicKeys[i] = publicKeys[i].ArmoredPublicKey
+	}
+	return shamir.CheckDemoKeys(armoredPublicKeys)
+}
+
+// ParseShamirPublicKeys 解析 ArmoredPublicKey 并检查 identity name，结果保存在 PublicKey 中
+func ParseShamirPublicKeys(publicKeys []ShamirPublicKey) error {
+	for i := range publicKeys {
+		identityName := publicKeys[i].IdentityName
+
+		// parse key
+		key, err := crypto.NewKeyFromArmored(publicKeys[i].ArmoredPublicKey)
+		if err != nil {
+			return fmt.Errorf("parse key of %s failed: %w", identityName, err)
+		}
+
+		// check identity name
+		if key.GetEntity().PrimaryIdentity().Name != identityName {
+			return fmt.Errorf("identity name %s not in public key", identityName)
+		}
+
+		// transform public key to key ring
+		publicKeys[i].PublicKey, err = crypto.NewKeyRing(key)
+		if err != nil {
+			return fmt.Errorf("cannot generate keyring from key of %s: %w", identityName, err)
+		}
+	}
+	return nil
+}
+
+// ShamirPublicKeyTask 定期检查数据库中最新的 key set，加载其他实例更新的公钥
+func ShamirPublicKeyTask(ctx context.Context) {
+	if !config.Config.ShamirFeature {
+		return
+	}
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ReloadShamirPublicKeys()
 			if err != nil {
-				log.Fatal().
-					Str("identity_name", identityName).
-					Msg("cannot generate keyring from key")
+				log.Err(err).Msg("reload shamir public keys failed")
 			}
 		}
 	}
 }
 
+// ReloadShamirPublicKeys 其他实例切换了 active key set 时重新加载公钥
+func ReloadShamirPublicKeys() error {
+	var keySet ShamirKeySet
+	err := DB.Where("status = ?", ShamirKeySetActive).Order("id desc").Take(&keySet).Error
+	if err != nil {
+		return err
+	}
+	if keySet.ID == CurrentShamirKeySet().ID {
+		return nil
+	}
+
+	publicKeys, err := LoadShamirKeySetPublicKeys(&keySet)
+	if err != nil {
+		return err
+	}
+
+	SetCurrentShamirKeys(keySet, publicKeys)
+	log.Info().Int("key_set_id", keySet.ID).Msg("shamir public keys reloaded")
+	return nil
+}
+
+// SaveShamirKeySet 创建新的 active key set 并替换数据库中所有公钥，用于初始化，其他 key set 变为 retired；
+// keySet 和 publicKeys 的 KeySetID 会被更新
+func SaveShamirKeySet(tx *gorm.DB, keySet *ShamirKeySet, publicKeys []ShamirPublicKey, threshold int) error {
+	*keySet = ShamirKeySet{
+		KeyCount:  len(publicKeys),
+		Threshold: threshold,
+		Status:    ShamirKeySetActive,
+	}
+	err := tx.Model(&ShamirKeySet{}).Where("status = ?", ShamirKeySetActive).Update("status", ShamirKeySetRetired).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Create(keySet).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range publicKeys {
+		publicKeys[i].KeySetID = keySet.ID
+	}
+
+	err = tx.Where("id NOT IN ?", keyIDs(publicKeys)).Delete(&ShamirPublicKey{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Save(publicKeys).Error
+}
+
+func keyIDs(publicKeys []ShamirPublicKey) []int {
+	ids := make([]int, 0, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		ids = append(ids, publicKey.ID)
+	}
+	return ids
+}
+
+// CreateShamirEmails 为用户生成 active 和正在生成的 key set 的 shamir_email，替换这些 key set 中已有的行，
+// 重复执行或与其他实例同时执行不会违反唯一索引
 func CreateShamirEmails(tx *gorm.DB, userID int, email string) error {
-	shamirEmails, err := GenerateShamirEmails(userID, email)
+	shamirEmails, commitments, err := GenerateShamirEmails(userID, email)
 	if err != nil {
 		return err
 	}
 
-	// save into database
-	return tx.Create(shamirEmails).Error
+	return tx.Transaction(func(tx *gorm.DB) error {
+		for _, commitment := range commitments {
+			keySetShamirEmails := make([]ShamirEmail, 0, len(shamirEmails))
+			for _, shamirEmail := range shamirEmails {
+				if shamirEmail.KeySetID == commitment.KeySetID {
+					keySetShamirEmails = append(keySetShamirEmails, shamirEmail)
+				}
+			}
+			err := ReplaceShamirEmails(tx, commitment.KeySetID, []int{userID}, keySetShamirEmails, []ShamirCommitment{commitment})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HasActiveShamirEmails 用户在数据库中 active 的 key set 里是否有使用当前公钥加密的 shamir_email，
+// 不使用内存中的 CurrentShamirKeySet，其他实例切换 key set 后立即生效
+func HasActiveShamirEmails(tx *gorm.DB, userID int) (bool, error) {
+	var exists bool
+	err := tx.Raw(
+		"SELECT EXISTS (SELECT 1 FROM shamir_email WHERE user_id = ? AND carried_from_key_set_id = 0 AND key_set_id = "+
+			"(SELECT id FROM shamir_key_set WHERE status = ? ORDER BY id DESC LIMIT 1))",
+		userID, ShamirKeySetActive,
+	).Scan(&exists).Error
+	return exists, err
 }
 
-func GenerateShamirEmails(userID int, email string) ([]ShamirEmail, error) {
-	num := len(ShamirPublicKeys)
-	threshold := num/2 + 1
+// SaveShamirEmails 保存 shamir_email 和对应的承诺
+func SaveShamirEmails(tx *gorm.DB, shamirEmails []ShamirEmail, commitments []ShamirCommitment) error {
+	if len(shamirEmails) > 0 {
+		err := tx.Create(&shamirEmails).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(commitments) > 0 {
+		return tx.Create(&commitments).Error
+	}
+	return nil
+}
 
-	shares, err := shamir.Encrypt(email, num, threshold)
+// GenerateShamirEmails 使用 active 的 key set 拆分并加密邮箱；
+// 正在重新加密时，同时为正在生成的 key set 加密，新用户不会在切换后丢失
+func GenerateShamirEmails(userID int, email string) ([]ShamirEmail, []ShamirCommitment, error) {
+	keySets, allPublicKeys, err := WritableShamirKeySets()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	shamirEmails := make([]ShamirEmail, 0, len(keySets)*len(allPublicKeys[0]))
+	commitments := make([]ShamirCommitment, 0, len(keySets))
+	for i := range keySets {
+		keySetShamirEmails, commitment, err := GenerateShamirEmailsWithKeys(&keySets[i], allPublicKeys[i], userID, email)
+		if err != nil {
+			return nil, nil, err
+		}
+		shamirEmails = append(shamirEmails, keySetShamirEmails...)
+		commitments = append(commitments, *commitment)
+	}
+	return shamirEmails, commitments, nil
+}
+
+// GenerateShamirEmailsWithKeys 使用指定 key set 的公钥和门限拆分并加密邮箱，同时返回坐标点的承诺
+func GenerateShamirEmailsWithKeys(keySet *ShamirKeySet, publicKeys []ShamirPublicKey, userID int, email string) ([]ShamirEmail, *ShamirCommitment, error) {
+	num := len(publicKeys)
+	threshold := keySet.Threshold
+
+	shares, commitments, err := shamir.EncryptVerifiable(email, num, threshold)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	shamirEmails := make([]ShamirEmail, 0, len(shares))
 
 	// encrypt with pgp public keys
 	for i := range shares {
+		shares[i].Meta = &shamir.ShareMeta{
+			KeySetID:  keySet.ID,
+			UserID:    userID,
+			Index:     i + 1,
+			Threshold: len(commitments),
+		}
 		shareText := shares[i].ToString()
 		sharePlanMessage := crypto.NewPlainMessageFromString(shareText)
-		pgpMessage, err := ShamirPublicKeys[i].PublicKey.Encrypt(sharePlanMessage, nil)
+		pgpMessage, err := publicKeys[i].PublicKey.Encrypt(sharePlanMessage, nil)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		armoredPGPMessage, err := pgpMessage.GetArmored()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		shamirEmails = append(shamirEmails, ShamirEmail{
+			KeySetID:    keySet.ID,
 			UserID:      userID,
-			EncryptedBy: ShamirPublicKeys[i].IdentityName,
+			EncryptedBy: publicKeys[i].IdentityName,
 			Key:         armoredPGPMessage,
 		})
 	}
 
-	return shamirEmails, nil
+	return shamirEmails, &ShamirCommitment{
+		KeySetID:    keySet.ID,
+		UserID:      userID,
+		Commitments: commitments.ToString(),
+	}, nil
+}
+
+// shamirVerifyBatchSize 验证坐标点时每次查询的承诺数量
+const shamirVerifyBatchSize = 1000
+
+// VerifyShamirShares 使用 key set 中的承诺逐个验证坐标点，key 为用户 ID，返回不一致的用户 ID；
+// 没有承诺的用户（旧数据）不验证
+func VerifyShamirShares(keySetID int, shares map[int]shamir.Share) ([]int, error) {
+	userIDs := make([]int, 0, len(shares))
+	for userID := range shares {
+		userIDs = append(userIDs, userID)
+	}
+	slices.Sort(userIDs)
+
+	invalid := make([]int, 0)
+	for start := 0; start < len(userIDs); start += shamirVerifyBatchSize {
+		end := start + shamirVerifyBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		var commitments []ShamirCommitment
+		err := DB.Where("key_set_id = ? AND user_id IN ?", keySetID, userIDs[start:end]).Find(&commitments).Error
+		if err != nil {
+			return nil, err
+		}
+
+		// verify concurrently, each verification takes threshold + 2 modular exponentiations
+		results := make([]bool, len(commitments))
+		var wg sync.WaitGroup
+		indexChan := make(chan int)
+		for i := 0; i < runtime.NumCPU(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range indexChan {
+					userCommitments, err := shamir.CommitmentsFromString(commitments[index].Commitments)
+					results[index] = err == nil && userCommitments.Verify(shares[commitments[index].UserID])
+				}
+			}()
+		}
+		for index := range commitments {
+			indexChan <- index
+		}
+		close(indexChan)
+		wg.Wait()
+
+		for index, valid := range results {
+			if !valid {
+				invalid = append(invalid, commitments[index].UserID)
+			}
+		}
+	}
+	slices.Sort(invalid)
+	return invalid, nil
 }
@@ -0,0 +1,262 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// key set 状态
+const (
+	ShamirKeySetBuilding  = "building"  // 正在重新加密，shamir_email 与 active 的 key set 并存
+	ShamirKeySetActive    = "active"    // 当前使用的 key set
+	ShamirKeySetRetired   = "retired"   // 被新的 key set 替代，shamir_email 已删除
+	ShamirKeySetDiscarded = "discarded" // 重新加密失败，shamir_email 已删除
+)
+
+// shamirPurgeBatchSize 删除旧 shamir_email 时每批删除的行数
+const shamirPurgeBatchSize = 1000
+
+// shamirKeySetPublicKeysCache key set 的公钥创建后不再改变，按 key set ID 缓存
+var shamirKeySetPublicKeysCache struct {
+	sync.Mutex
+	publicKeys map[int][]ShamirPublicKey
+}
+
+func cachedShamirKeySetPublicKeys(keySet *ShamirKeySet) ([]ShamirPublicKey, error) {
+	shamirKeySetPublicKeysCache.Lock()
+	defer shamirKeySetPublicKeysCache.Unlock()
+	cache := &shamirKeySetPublicKeysCache
+
+	if publicKeys, ok := cache.publicKeys[keySet.ID]; ok {
+		return publicKeys, nil
+	}
+	publicKeys, err := LoadShamirKeySetPublicKeys(keySet)
+	if err != nil {
+		return nil, err
+	}
+	if cache.publicKeys == nil {
+		cache.publicKeys = make(map[int][]ShamirPublicKey)
+	}
+	cache.publicKeys[keySet.ID] = publicKeys
+	return publicKeys, nil
+}
+
+// GetShamirKeySet 获取指定的 key set
+func GetShamirKeySet(keySetID int) (*ShamirKeySet, error) {
+	var keySet ShamirKeySet
+	err := DB.Take(&keySet, keySetID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &keySet, nil
+}
+
+// LoadShamirKeySetPublicKeys 加载并解析 key set 的公钥
+func LoadShamirKeySetPublicKeys(keySet *ShamirKeySet) ([]ShamirPublicKey, error) {
+	var publicKeys []ShamirPublicKey
+	err := DB.Where("key_set_id = ?", keySet.ID).Order("id").Find(&publicKeys).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(publicKeys) != keySet.KeyCount {
+		return nil, fmt.Errorf("key set %d has %d public keys, expect %d", keySet.ID, len(publicKeys), keySet.KeyCount)
+	}
+	err = ParseShamirPublicKeys(publicKeys)
+	if err != nil {
+		return nil, err
+	}
+	return publicKeys, nil
+}
+
+// BuildingShamirKeySet 获取正在生成的 key set 和公钥，不存在时返回 nil.
+// 每次都查询数据库，其他实例开始重新加密后立即生效；公钥按 key set 缓存
+func BuildingShamirKeySet() (*ShamirKeySet, []ShamirPublicKey, error) {
+	var keySet ShamirKeySet
+	result := DB.Where("status = ?", ShamirKeySetBuilding).Order("id desc").Limit(1).Find(&keySet)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, nil
+	}
+
+	publicKeys, err := cachedShamirKeySetPublicKeys(&keySet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &keySet, publicKeys, nil
+}
+
+// WritableShamirKeySets 新用户的 shamir_email 需要写入的 key set 和公钥：active 的 key set，以及正在生成的 key set.
+//
+// 不使用内存中的 CurrentShamirKeySet，其他实例切换 key set 后最多一分钟才会重新加载；
+// 两者在一次查询中读取，切换前读到的是 active 和 building，切换后读到的是新的 active，不会只写入已停用的 key set
+func WritableShamirKeySets() ([]ShamirKeySet, [][]ShamirPublicKey, error) {
+	var keySets []ShamirKeySet
+	err := DB.Where("status IN ?", []string{ShamirKeySetActive, ShamirKeySetBuilding}).Order("id").Find(&keySets).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allPublicKeys := make([][]ShamirPublicKey, 0, len(keySets)+1)
+	if len(keySets) == 0 || keySets[0].Status != ShamirKeySetActive {
+		// public keys saved before key sets were introduced
+		currentKeySet, currentPublicKeys := CurrentShamirKeys()
+		keySets = append([]ShamirKeySet{currentKeySet}, keySets...)
+		allPublicKeys = append(allPublicKeys, currentPublicKeys)
+	}
+	for i := len(allPublicKeys); i < len(keySets); i++ {
+		publicKeys, err := cachedShamirKeySetPublicKeys(&keySets[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		allPublicKeys = append(allPublicKeys, publicKeys)
+	}
+	return keySets, allPublicKeys, nil
+}
+
+// CreateBuildingShamirKeySet 保存新公钥，创建正在生成的 key set
+func CreateBuildingShamirKeySet(publicKeys []ShamirPublicKey, threshold int) (*ShamirKeySet, []ShamirPublicKey, error) {
+	keySet := ShamirKeySet{
+		KeyCount:  len(publicKeys),
+		Threshold: threshold,
+		Status:    ShamirKeySetBuilding,
+	}
+
+	// public keys of different key sets are stored together, ids are assigned by database
+	newPublicKeys := make([]ShamirPublicKey, len(publicKeys))
+	copy(newPublicKeys, publicKeys)
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&keySet).Error
+		if err != nil {
+			return err
+		}
+		for i := range newPublicKeys {
+			newPublicKeys[i].ID = 0
+			newPublicKeys[i].KeySetID = keySet.ID
+		}
+		return tx.Create(&newPublicKeys).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Info().Int("key_set_id", keySet.ID).Int("key_count", keySet.KeyCount).Int("threshold", threshold).Msg("shamir key set created")
+	return &keySet, newPublicKeys, nil
+}
+
+// ReplaceShamirEmails 写入一批用户在 keySetID 下的 shamir_email 和承诺，已存在的行会被替换，重复执行结果相同
+func ReplaceShamirEmails(tx *gorm.DB, keySetID int, userIDs []int, shamirEmails []ShamirEmail, commitments []ShamirCommitment) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	err := tx.Where("key_set_id = ? AND user_id IN ?", keySetID, userIDs).Delete(&ShamirEmail{}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Where("key_set_id = ? AND user_id IN ?", keySetID, userIDs).Delete(&ShamirCommitment{}).Error
+	if err != nil {
+		return err
+	}
+	return SaveShamirEmails(tx, shamirEmails, commitments)
+}
+
+// carryForwardShamirEmails 将原 key set 中在新 key set 里没有 shamir_email 的用户（管理员获取加密信息之后注册的用户）
+// 的行复制到新 key set，CarriedFromKeySetID 记录最初的 key set，旧数据不会随原 key set 一起删除
+func carryForwardShamirEmails(tx *gorm.DB, retiredIDs []int, keySetID int) error {
+	result := tx.Exec(
+		"INSERT INTO shamir_email (key_set_id, user_id, encrypted_by, `key`, carried_from_key_set_id) "+
+			"SELECT ?, user_id, encrypted_by, `key`, CASE WHEN carried_from_key_set_id <> 0 THEN carried_from_key_set_id ELSE key_set_id END "+
+			"FROM shamir_email WHERE key_set_id IN ? AND NOT EXISTS "+
+			"(SELECT 1 FROM shamir_email AS new_email WHERE new_email.key_set_id = ? AND new_email.user_id = shamir_email.user_id)",
+		keySetID, retiredIDs, keySetID,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Warn().Int("key_set_id", keySetID).Int64("rows", result.RowsAffected).Msg("shamir emails without uploaded shares carried forward, re-encrypted on next login")
+	}
+	return nil
+}
+
+// ActivateShamirKeySet 将正在生成的 key set 切换为 active，原 active 的 key set 变为 retired.
+// 原 key set 中没有重新加密的用户，在同一事务中复制原来的 shamir_email，切换后不会丢失
+func ActivateShamirKeySet(keySet *ShamirKeySet) (retired []ShamirKeySet, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		retired, err = activateShamirKeySet(tx, keySet)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	keySet.Status = ShamirKeySetActive
+	log.Info().Int("key_set_id", keySet.ID).Msg("shamir key set activated")
+	return retired, nil
+}
+
+func activateShamirKeySet(tx *gorm.DB, keySet *ShamirKeySet) (retired []ShamirKeySet, err error) {
+	err = tx.Where("status = ?", ShamirKeySetActive).Find(&retired).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(retired) > 0 {
+		retiredIDs := make([]int, 0, len(retired))
+		for _, retiredKeySet := range retired {
+			retiredIDs = append(retiredIDs, retiredKeySet.ID)
+		}
+		err = carryForwardShamirEmails(tx, retiredIDs, keySet.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := tx.Model(&ShamirKeySet{}).
+		Where("id = ? AND status = ?", keySet.ID, ShamirKeySetBuilding).
+		Update("status", ShamirKeySetActive)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("key set %d is not building", keySet.ID)
+	}
+
+	err = tx.Model(&ShamirKeySet{}).
+		Where("status = ? AND id <> ?", ShamirKeySetActive, keySet.ID).
+		Update("status", ShamirKeySetRetired).Error
+	return retired, err
+}
+
+// PurgeShamirKeySet 分批删除 key set 的 shamir_email、承诺和公钥，并将状态设置为 status
+func PurgeShamirKeySet(keySetID int, status string) error {
+	for _, model := range []any{&ShamirEmail{}, &ShamirCommitment{}} {
+		for {
+			var ids []int
+			err := DB.Model(model).Where("key_set_id = ?", keySetID).Limit(shamirPurgeBatchSize).Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				break
+			}
+			err = DB.Delete(model, ids).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("key_set_id = ?", keySetID).Delete(&ShamirPublicKey{}).Error
+		if err != nil {
+			return err
+		}
+		log.Info().Int("key_set_id", keySetID).Str("status", status).Msg("shamir key set purged")
+		return tx.Model(&ShamirKeySet{}).Where("id = ?", keySetID).Update("status", status).Error
+	})
+}
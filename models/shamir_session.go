@@ -0,0 +1,878 @@
+package models
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+	"github.com/thanhpk/randstr"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"auth_next/config"
+	"auth_next/utils/shamir"
+)
+
+// shamir 会话类型
+const (
+	ShamirSessionTypeUpdate  = "update"  // 重新加密所有用户的邮箱
+	ShamirSessionTypeDecrypt = "decrypt" // 解密单个用户的邮箱
+)
+
+// shamir 会话状态
+const (
+	ShamirSessionPending   = "pending"
+	ShamirSessionUpdating  = "updating"
+	ShamirSessionSuccess   = "success"
+	ShamirSessionFailed    = "failed"
+	ShamirSessionCancelled = "cancelled"
+	ShamirSessionExpired   = "expired"
+)
+
+var ErrShamirSessionNotFound = errors.New("shamir session not found")
+var ErrShamirSessionExists = errors.New("shamir session already exists")
+var ErrShamirSharesUploaded = errors.New("shares already uploaded")
+var ErrShamirRequesterApproval = errors.New("the requester can't approve the request")
+var ErrShamirAlreadyApproved = errors.New("the uploader already approved the request")
+var ErrShamirSessionSuperseded = errors.New("shamir session has been taken over by another instance")
+
+// ErrShamirChunkOutOfOrder 分块没有按顺序上传
+type ErrShamirChunkOutOfOrder struct {
+	Next int
+}
+
+func (e *ErrShamirChunkOutOfOrder) Error() string {
+	return fmt.Sprintf("chunks must be uploaded in order, the next chunk is %d", e.Next)
+}
+
+// ErrShamirChunkUserOrder 分块中的用户 ID 没有大于之前分块的用户 ID
+type ErrShamirChunkUserOrder struct {
+	After int
+}
+
+func (e *ErrShamirChunkUserOrder) Error() string {
+	return fmt.Sprintf("user IDs of a chunk must be larger than %d, the largest of previous chunks", e.After)
+}
+
+// ShamirSession 保存在数据库中的 shamir 上传会话，多个实例共享。
+// 同一类型（解密时为同一用户）同时只有一个进行中的会话，由 ActiveKey 的唯一索引保证
+type ShamirSession struct {
+	ID               string            `json:"id" gorm:"primaryKey;size:32"`
+	Type             string            `json:"type" gorm:"size:16;not null"`
+	TargetUserID     int               `json:"target_user_id,omitempty"`
+	OwnerID          int               `json:"owner_id"`
+	KeySetID         int               `json:"key_set_id"`
+	Status           string            `json:"status" gorm:"size:16;not null"`
+	ActiveKey        *string           `json:"-" gorm:"size:32;uniqueIndex"`
+	NewPublicKeys    []ShamirPublicKey `json:"-" gorm:"type:longtext;serializer:json"`
+	NewKeySetID      int               `json:"new_key_set_id,omitempty"`     // 重新加密正在生成的 key set
+	CheckpointUserID int               `json:"checkpoint_user_id,omitempty"` // 不大于该 ID 的用户已重新加密
+	NowUserID        int               `json:"now_user_id,omitempty"`
+	Generation       int               `json:"-" gorm:"not null;default:0"` // 每次开始或接管时加一，旧实例的写入不再生效
+	FailMessage      string            `json:"fail_message,omitempty" gorm:"type:text"`
+	WarningMessage   string            `json:"warning_message,omitempty" gorm:"type:text"`
+	Reason           string            `json:"reason,omitempty" gorm:"type:text"` // 解密申请的理由，OwnerID 为申请人
+	DecryptedAt      *time.Time        `json:"decrypted_at,omitempty"`            // 申请人获取解密结果的时间，只能获取一次
+	ExpiresAt        time.Time         `json:"expires_at" gorm:"index"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// ShamirSessionShare 某个 shamir 管理员在会话中上传的坐标点，使用 SHAMIR_SESSION_KEY 加密保存
+type ShamirSessionShare struct {
+	ID              int        `json:"id" gorm:"primaryKey"`
+	SessionID       string     `json:"session_id" gorm:"size:32;not null;uniqueIndex:idx_shamir_session_identity_chunk,priority:1;uniqueIndex:idx_shamir_session_approver,priority:1"`
+	IdentityName    string     `json:"identity_name" gorm:"size:255;not null;uniqueIndex:idx_shamir_session_identity_chunk,priority:2"`
+	Chunk           int        `json:"chunk" gorm:"not null;default:0;uniqueIndex:idx_shamir_session_identity_chunk,priority:3"`
+	Complete        bool       `json:"complete" gorm:"not null;default:false"` // 最后一块，之后该 identity 才算上传完成
+	UploaderID      int        `json:"uploader_id"`
+	MinUserID       int        `json:"-"` // 分块中最小和最大的用户 ID，分块按用户 ID 递增上传，不必解密之前的分块检查重复
+	MaxUserID       int        `json:"-"`
+	ApproverID      *int       `json:"-" gorm:"uniqueIndex:idx_shamir_session_approver,priority:2"` // 解密申请第 0 块的 UploaderID，每个管理员只能批准一次
+	EncryptedShares []byte     `json:"-" gorm:"type:longblob"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty" gorm:"index"` // 同一 identity 的分块与第一块同时过期，旧数据为空时跟随会话
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+func shamirSessionActiveKey(sessionType string, targetUserID int) string {
+	if sessionType == ShamirSessionTypeDecrypt {
+		return fmt.Sprintf("%s:%d", sessionType, targetUserID)
+	}
+	return sessionType
+}
+
+func shamirSessionExpires() time.Duration {
+	return time.Duration(config.Config.ShamirSessionExpires) * time.Minute
+}
+
+// shamirShareTTL 上传的坐标点的有效期，未设置时与会话相同
+func shamirShareTTL() time.Duration {
+	if config.Config.ShamirShareTTL <= 0 {
+		return shamirSessionExpires()
+	}
+	return time.Duration(config.Config.ShamirShareTTL) * time.Minute
+}
+
+// ShamirUpdateStalledAfter 重新加密超过这个时间没有保存进度，认为实例已中断，可以由其他实例继续
+const ShamirUpdateStalledAfter = 5 * time.Minute
+
+// Active 会话未结束且未过期；开始重新加密时会延长有效期，实例中断后的会话也会过期
+func (session *ShamirSession) Active() bool {
+	if session.Status != ShamirSessionPending && session.Status != ShamirSessionUpdating {
+		return false
+	}
+	return session.ExpiresAt.After(time.Now())
+}
+
+// GetActiveShamirSession 获取进行中的会话，过期的会话会被结束
+func GetActiveShamirSession(sessionType string, targetUserID int) (*ShamirSession, error) {
+	var session ShamirSession
+	err := DB.Where("active_key = ?", shamirSessionActiveKey(sessionType, targetUserID)).Take(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShamirSessionNotFound
+		}
+		return nil, err
+	}
+	if !session.Active() {
+		err = session.Finish(ShamirSessionExpired)
+		if errors.Is(err, ErrShamirSessionSuperseded) {
+			// taken over or started after it was loaded
+			return GetActiveShamirSession(sessionType, targetUserID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = session.DiscardNewKeySet()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrShamirSessionNotFound
+	}
+	return &session, nil
+}
+
+// ShamirSessionTask 定期结束过期的会话，删除过期的坐标点
+func ShamirSessionTask(ctx context.Context) {
+	if !config.Config.ShamirFeature {
+		return
+	}
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _, err := SweepShamirSessions()
+			if err != nil {
+				log.Err(err).Msg("sweep shamir sessions failed")
+			}
+		}
+	}
+}
+
+// SweepShamirSessions 结束过期的会话，删除等待上传的会话中过期的坐标点，返回结束的会话数和删除的分块数
+func SweepShamirSessions() (int, int64, error) {
+	now := time.Now()
+	var sessions []ShamirSession
+	err := DB.Where("active_key IS NOT NULL AND expires_at <= ?", now).Find(&sessions).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range sessions {
+		session := &sessions[i]
+		if session.Type == ShamirSessionTypeDecrypt && session.Status == ShamirSessionPending {
+			err = session.FinishWithAudit(ShamirSessionExpired, &AuditLog{
+				Action:       AuditShamirDecryptExpire,
+				ResourceID:   session.ID,
+				TargetUserID: session.TargetUserID,
+			})
+		} else {
+			err = session.Finish(ShamirSessionExpired)
+		}
+		if errors.Is(err, ErrShamirSessionSuperseded) {
+			continue
+		}
+		if err != nil {
+			return i, 0, err
+		}
+		err = session.DiscardNewKeySet()
+		if err != nil {
+			return i, 0, err
+		}
+	}
+
+	result := DB.Where("expires_at <= ? AND session_id IN (?)", now,
+		DB.Model(&ShamirSession{}).Select("id").Where("status = ?", ShamirSessionPending)).
+		Delete(&ShamirSessionShare{})
+	if result.Error != nil {
+		return len(sessions), 0, result.Error
+	}
+	if len(sessions) > 0 || result.RowsAffected > 0 {
+		log.Info().Int("sessions", len(sessions)).Int64("chunks", result.RowsAffected).Msg("expired shamir shares purged")
+	}
+	return len(sessions), result.RowsAffected, nil
+}
+
+// Stalled 重新加密的实例已中断
+func (session *ShamirSession) Stalled() bool {
+	return session.Status == ShamirSessionUpdating && time.Since(session.UpdatedAt) > ShamirUpdateStalledAfter
+}
+
+// TakeOver 接管中断的重新加密，只有一个实例可以成功；
+// 同时增加 generation，被判断为中断的实例恢复后保存进度或结束会话都会失败
+func (session *ShamirSession) TakeOver() (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(shamirSessionExpires())
+	result := DB.Model(&ShamirSession{}).
+		Where("id = ? AND status = ? AND generation = ? AND updated_at < ?",
+			session.ID, ShamirSessionUpdating, session.Generation, now.Add(-ShamirUpdateStalledAfter)).
+		Updates(map[string]any{"generation": gorm.Expr("generation + 1"), "updated_at": now, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	session.Generation++
+	session.UpdatedAt = now
+	session.ExpiresAt = expiresAt
+	log.Warn().Str("session_id", session.ID).Int("checkpoint_user_id", session.CheckpointUserID).Msg("resume stalled shamir update")
+	return true, nil
+}
+
+// DiscardNewKeySet 删除未完成的重新加密生成的 key set
+func (session *ShamirSession) DiscardNewKeySet() error {
+	if session.NewKeySetID == 0 {
+		return nil
+	}
+	keySet, err := GetShamirKeySet(session.NewKeySetID)
+	if err != nil {
+		return err
+	}
+	if keySet.Status != ShamirKeySetBuilding {
+		return nil
+	}
+	return PurgeShamirKeySet(keySet.ID, ShamirKeySetDiscarded)
+}
+
+// GetOrCreateActiveShamirSession 获取进行中的会话，不存在时以 ownerID 为所有者创建
+func GetOrCreateActiveShamirSession(sessionType string, targetUserID, ownerID int) (*ShamirSession, error) {
+	session, err := GetActiveShamirSession(sessionType, targetUserID)
+	if !errors.Is(err, ErrShamirSessionNotFound) {
+		return session, err
+	}
+
+	activeKey := shamirSessionActiveKey(sessionType, targetUserID)
+	session = &ShamirSession{
+		ID:           randstr.Hex(16),
+		Type:         sessionType,
+		TargetUserID: targetUserID,
+		OwnerID:      ownerID,
+		KeySetID:     CurrentShamirKeySet().ID,
+		Status:       ShamirSessionPending,
+		ActiveKey:    &activeKey,
+		ExpiresAt:    time.Now().Add(shamirSessionExpires()),
+	}
+	err = DB.Create(session).Error
+	if err != nil {
+		// created by another instance at the same time
+		existing, findErr := GetActiveShamirSession(sessionType, targetUserID)
+		if findErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	log.Info().
+		Str("session_id", session.ID).
+		Str("type", sessionType).
+		Int("target_user_id", targetUserID).
+		Int("owner_id", ownerID).
+		Msg("shamir session created")
+	return session, nil
+}
+
+// CreateShamirDecryptRequest 申请解密 targetUserID 的邮箱，创建该用户的解密会话并记录审计日志.
+// 已有进行中的申请时返回该申请和 ErrShamirSessionExists
+func CreateShamirDecryptRequest(targetUserID, requesterID int, reason string) (*ShamirSession, error) {
+	existing, err := GetActiveShamirSession(ShamirSessionTypeDecrypt, targetUserID)
+	if err == nil {
+		return existing, ErrShamirSessionExists
+	}
+	if !errors.Is(err, ErrShamirSessionNotFound) {
+		return nil, err
+	}
+
+	activeKey := shamirSessionActiveKey(ShamirSessionTypeDecrypt, targetUserID)
+	session := &ShamirSession{
+		ID:           randstr.Hex(16),
+		Type:         ShamirSessionTypeDecrypt,
+		TargetUserID: targetUserID,
+		OwnerID:      requesterID,
+		KeySetID:     CurrentShamirKeySet().ID,
+		Status:       ShamirSessionPending,
+		ActiveKey:    &activeKey,
+		Reason:       reason,
+		ExpiresAt:    time.Now().Add(shamirSessionExpires()),
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(session).Error
+		if err != nil {
+			return err
+		}
+		return CreateAuditLog(tx, &AuditLog{
+			ActorID:      requesterID,
+			Action:       AuditShamirDecryptRequest,
+			ResourceID:   session.ID,
+			TargetUserID: targetUserID,
+			Detail:       Map{"reason": reason},
+		})
+	})
+	if err != nil {
+		// created by another admin at the same time
+		existing, findErr := GetActiveShamirSession(ShamirSessionTypeDecrypt, targetUserID)
+		if findErr == nil {
+			return existing, ErrShamirSessionExists
+		}
+		return nil, err
+	}
+	log.Info().
+		Str("session_id", session.ID).
+		Int("target_user_id", targetUserID).
+		Int("owner_id", requesterID).
+		Msg("shamir decrypt request created")
+	return session, nil
+}
+
+// GetShamirSession 按 ID 获取会话，包括已结束的会话
+func GetShamirSession(sessionID string) (*ShamirSession, error) {
+	var session ShamirSession
+	err := DB.Where("id = ?", sessionID).Take(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShamirSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// EffectiveStatus 已过期但还没有被结束的会话视为 expired
+func (session *ShamirSession) EffectiveStatus() string {
+	if (session.Status == ShamirSessionPending || session.Status == ShamirSessionUpdating) && !session.Active() {
+		return ShamirSessionExpired
+	}
+	return session.Status
+}
+
+// ListShamirDecryptRequests 查询解密申请，最新的在前；targetUserID 为 0、status 为空时不过滤
+func ListShamirDecryptRequests(targetUserID int, status string, offset, size int) ([]ShamirSession, error) {
+	querySet := DB.Where("type = ?", ShamirSessionTypeDecrypt)
+	if targetUserID != 0 {
+		querySet = querySet.Where("target_user_id = ?", targetUserID)
+	}
+	now := time.Now()
+	switch status {
+	case "":
+	case ShamirSessionPending, ShamirSessionUpdating:
+		querySet = querySet.Where("status = ? AND expires_at > ?", status, now)
+	case ShamirSessionExpired:
+		querySet = querySet.Where("(status = ? OR (status IN ? AND expires_at <= ?))",
+			ShamirSessionExpired, []string{ShamirSessionPending, ShamirSessionUpdating}, now)
+	default:
+		querySet = querySet.Where("status = ?", status)
+	}
+
+	sessions := make([]ShamirSession, 0, size)
+	err := querySet.Order("created_at desc").Offset(offset).Limit(size).Find(&sessions).Error
+	return sessions, err
+}
+
+// ApprovedIdentityNames 批准过申请的 identity，按批准顺序排列；会话结束后坐标点被删除，从审计日志中获取
+func (session *ShamirSession) ApprovedIdentityNames() ([]string, error) {
+	var auditLogs []AuditLog
+	err := DB.Where("action = ? AND resource_id = ?", AuditShamirDecryptApprove, session.ID).
+		Order("id").Find(&auditLogs).Error
+	if err != nil {
+		return nil, err
+	}
+	identityNames := make([]string, 0, len(auditLogs))
+	for _, auditLog := range auditLogs {
+		identityName, _ := auditLog.Detail["identity_name"].(string)
+		if identityName != "" && !slices.Contains(identityNames, identityName) {
+			identityNames = append(identityNames, identityName)
+		}
+	}
+	return identityNames, nil
+}
+
+// GetLatestShamirSession 获取最近创建的会话，用于查看已结束会话的结果
+func GetLatestShamirSession(sessionType string, targetUserID int) (*ShamirSession, error) {
+	var session ShamirSession
+	err := DB.Where("type = ? AND target_user_id = ?", sessionType, targetUserID).
+		Order("created_at desc").Take(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShamirSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StartUpdating 将会话从 pending 切换为 updating，只有一个实例可以成功
+func (session *ShamirSession) StartUpdating() (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(shamirSessionExpires())
+	result := DB.Model(&ShamirSession{}).
+		Where("id = ? AND status = ?", session.ID, ShamirSessionPending).
+		Updates(map[string]any{
+			"status":     ShamirSessionUpdating,
+			"generation": gorm.Expr("generation + 1"),
+			"expires_at": expiresAt,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	session.Generation++
+	session.Status = ShamirSessionUpdating
+	session.ExpiresAt = expiresAt
+	session.UpdatedAt = now
+	return true, nil
+}
+
+// Finish 结束会话并删除上传的坐标点
+func (session *ShamirSession) Finish(status string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return session.finish(tx, status)
+	})
+}
+
+// FinishWithAudit 结束会话，在同一个事务中写入审计日志
+func (session *ShamirSession) FinishWithAudit(status string, auditLog *AuditLog) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := session.finish(tx, status)
+		if err != nil {
+			return err
+		}
+		return CreateAuditLog(tx, auditLog)
+	})
+}
+
+func (session *ShamirSession) finish(tx *gorm.DB, status string) error {
+	err := session.fencedUpdates(tx, map[string]any{
+		"status":          status,
+		"active_key":      nil,
+		"fail_message":    session.FailMessage,
+		"warning_message": session.WarningMessage,
+		"now_user_id":     session.NowUserID,
+		"decrypted_at":    session.DecryptedAt,
+		"updated_at":      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	session.Status = status
+	session.ActiveKey = nil
+	log.Info().Str("session_id", session.ID).Str("status", status).Msg("shamir session finished")
+	return tx.Where("session_id = ?", session.ID).Delete(&ShamirSessionShare{}).Error
+}
+
+// SaveNewPublicKeys 保存新公钥，覆盖之前上传的公钥
+func (session *ShamirSession) SaveNewPublicKeys(publicKeys []ShamirPublicKey) error {
+	session.NewPublicKeys = publicKeys
+	return DB.Model(session).Select("NewPublicKeys").Updates(session).Error
+}
+
+// LoadNewPublicKeys 解析会话中保存的新公钥
+func (session *ShamirSession) LoadNewPublicKeys() error {
+	return ParseShamirPublicKeys(session.NewPublicKeys)
+}
+
+// fencedUpdates 只在会话没有被其他实例开始或接管时更新，否则返回 ErrShamirSessionSuperseded
+func (session *ShamirSession) fencedUpdates(tx *gorm.DB, values map[string]any) error {
+	result := tx.Model(&ShamirSession{}).
+		Where("id = ? AND generation = ?", session.ID, session.Generation).
+		Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShamirSessionSuperseded
+	}
+	return nil
+}
+
+// SaveNewKeySet 记录重新加密生成的 key set，中断后继续使用同一个 key set
+func (session *ShamirSession) SaveNewKeySet(keySetID int) error {
+	err := session.fencedUpdates(DB, map[string]any{"new_key_set_id": keySetID, "updated_at": time.Now()})
+	if err != nil {
+		return err
+	}
+	session.NewKeySetID = keySetID
+	return nil
+}
+
+// ActivateNewKeySet 在同一个事务中确认会话仍由本实例执行并切换到 keySet
+func (session *ShamirSession) ActivateNewKeySet(keySet *ShamirKeySet) (retired []ShamirKeySet, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := session.fencedUpdates(tx, map[string]any{"updated_at": time.Now()})
+		if err != nil {
+			return err
+		}
+		retired, err = activateShamirKeySet(tx, keySet)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	keySet.Status = ShamirKeySetActive
+	log.Info().Int("key_set_id", keySet.ID).Str("session_id", session.ID).Msg("shamir key set activated")
+	return retired, nil
+}
+
+// SaveCheckpoint 保存重新加密的进度，并延长会话有效期
+// updated_at 同时作为心跳，用于判断实例是否中断
+func (session *ShamirSession) SaveCheckpoint(tx *gorm.DB, checkpointUserID, nowUserID int, warningMessage string) error {
+	now := time.Now()
+	expiresAt := now.Add(shamirSessionExpires())
+	err := session.fencedUpdates(tx, map[string]any{
+		"checkpoint_user_id": checkpointUserID,
+		"now_user_id":        nowUserID,
+		"warning_message":    warningMessage,
+		"expires_at":         expiresAt,
+		"updated_at":         now,
+	})
+	if err != nil {
+		return err
+	}
+	session.CheckpointUserID = checkpointUserID
+	session.UpdatedAt = now
+	session.NowUserID = nowUserID
+	session.WarningMessage = warningMessage
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// AddShares 保存 uploaderID 以 identityName 上传的坐标点，key 为用户 ID；每个 identity 只能上传一次
+func (session *ShamirSession) AddShares(identityName string, uploaderID int, shares map[int]shamir.Share) error {
+	return session.AddSharesChunk(identityName, uploaderID, 0, true, shares)
+}
+
+// AddSharesChunk 分块保存 identityName 上传的坐标点，last 为 true 时该 identity 上传完成.
+//
+// 分块从 0 开始按顺序上传，同一块不能重复上传，上传完成后不能再上传；同一用户的坐标点只能出现在一块中。
+// 坐标点在 SHAMIR_SHARE_TTL 分钟后过期，过期后该 identity 需要从第 0 块重新上传。
+// 解密申请中上传即批准，申请人不能批准自己的申请，每个管理员只能以一个 identity 批准
+func (session *ShamirSession) AddSharesChunk(identityName string, uploaderID, chunk int, last bool, shares map[int]shamir.Share) error {
+	err := session.deleteExpiredShares(identityName, uploaderID)
+	if err != nil {
+		return err
+	}
+	var approverID *int
+	if session.Type == ShamirSessionTypeDecrypt {
+		if uploaderID == session.OwnerID {
+			return ErrShamirRequesterApproval
+		}
+		var count int64
+		err = session.unexpiredShares(DB.Model(&ShamirSessionShare{})).
+			Where("session_id = ? AND approver_id = ? AND identity_name <> ?", session.ID, uploaderID, identityName).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrShamirAlreadyApproved
+		}
+		if chunk == 0 {
+			approverID = &uploaderID
+		}
+	}
+	var uploaded []ShamirSessionShare
+	err = session.unexpiredShares(DB).
+		Select("chunk", "complete", "max_user_id", "expires_at").
+		Where("session_id = ? AND identity_name = ?", session.ID, identityName).
+		Order("chunk").Find(&uploaded).Error
+	if err != nil {
+		return err
+	}
+	if chunk > len(uploaded) {
+		return &ErrShamirChunkOutOfOrder{Next: len(uploaded)}
+	}
+	for _, sessionShare := range uploaded {
+		if sessionShare.Complete || sessionShare.Chunk == chunk {
+			return ErrShamirSharesUploaded
+		}
+	}
+
+	// 同一用户只能上传一次：分块的用户 ID 须大于之前所有分块的用户 ID
+	minUserID, maxUserID := shareMapUserIDRange(shares)
+	if len(uploaded) > 0 {
+		previousMax := uploaded[len(uploaded)-1].MaxUserID
+		if len(shares) == 0 {
+			minUserID, maxUserID = previousMax, previousMax
+		} else if minUserID <= previousMax {
+			return &ErrShamirChunkUserOrder{After: previousMax}
+		}
+	}
+
+	expiresAt := time.Now().Add(shamirShareTTL())
+	if len(uploaded) > 0 && uploaded[0].ExpiresAt != nil {
+		expiresAt = *uploaded[0].ExpiresAt
+	}
+	if session.Status == ShamirSessionPending && expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	data, err := json.Marshal(shares)
+	if err != nil {
+		return err
+	}
+	encrypted, err := sealShamirShares(data, session.ID, identityName)
+	clear(data)
+	if err != nil {
+		return err
+	}
+
+	result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&ShamirSessionShare{
+		SessionID:       session.ID,
+		IdentityName:    identityName,
+		Chunk:           chunk,
+		Complete:        last,
+		UploaderID:      uploaderID,
+		MinUserID:       minUserID,
+		MaxUserID:       maxUserID,
+		ApproverID:      approverID,
+		EncryptedShares: encrypted,
+		ExpiresAt:       &expiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShamirSharesUploaded
+	}
+	return nil
+}
+
+// unexpiredShares 等待上传的会话只使用未过期的坐标点；开始重新加密或解密后，坐标点保留到会话结束
+func (session *ShamirSession) unexpiredShares(db *gorm.DB) *gorm.DB {
+	if session.Status != ShamirSessionPending {
+		return db
+	}
+	return db.Where("(expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+// deleteExpiredShares 删除 identityName 或 uploaderID 批准的过期坐标点，以便重新上传
+func (session *ShamirSession) deleteExpiredShares(identityName string, uploaderID int) error {
+	if session.Status != ShamirSessionPending {
+		return nil
+	}
+	return DB.Where("session_id = ? AND (identity_name = ? OR approver_id = ?) AND expires_at <= ?", session.ID, identityName, uploaderID, time.Now()).
+		Delete(&ShamirSessionShare{}).Error
+}
+
+func (session *ShamirSession) openSessionShare(sessionShare ShamirSessionShare) (map[int]shamir.Share, error) {
+	data, err := openShamirShares(sessionShare.EncryptedShares, session.ID, sessionShare.IdentityName)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt shares of %s failed: %w", sessionShare.IdentityName, err)
+	}
+	defer clear(data)
+	var shares map[int]shamir.Share
+	err = json.Unmarshal(data, &shares)
+	return shares, err
+}
+
+func shareMapUserIDRange(shares map[int]shamir.Share) (minUserID, maxUserID int) {
+	first := true
+	for userID := range shares {
+		if first || userID < minUserID {
+			minUserID = userID
+		}
+		if first || userID > maxUserID {
+			maxUserID = userID
+		}
+		first = false
+	}
+	return minUserID, maxUserID
+}
+
+// IdentityNames 已上传完成且未过期的 identity，按完成顺序排列
+func (session *ShamirSession) IdentityNames() ([]string, error) {
+	identityNames := make([]string, 0)
+	err := session.unexpiredShares(DB.Model(&ShamirSessionShare{})).
+		Where("session_id = ? AND complete = ?", session.ID, true).
+		Order("id").
+		Pluck("identity_name", &identityNames).Error
+	return identityNames, err
+}
+
+// UploadedChunks identityName 已上传且未过期的分块数量和是否上传完成
+func (session *ShamirSession) UploadedChunks(identityName string) (int, bool, error) {
+	var sessionShares []ShamirSessionShare
+	err := session.unexpiredShares(DB).Select("chunk", "complete").
+		Where("session_id = ? AND identity_name = ?", session.ID, identityName).
+		Find(&sessionShares).Error
+	if err != nil {
+		return 0, false, err
+	}
+	complete := false
+	for _, sessionShare := range sessionShares {
+		complete = complete || sessionShare.Complete
+	}
+	return len(sessionShares), complete, nil
+}
+
+// ShamirSharesBatch 一个 identity 在会话中上传的坐标点
+type ShamirSharesBatch struct {
+	IdentityName string    `json:"identity_name"`
+	Chunks       int       `json:"chunks"`
+	Complete     bool      `json:"complete"`
+	UploadedAt   time.Time `json:"uploaded_at"` // 最后一块的上传时间
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// UploadedBatches 会话中未过期的坐标点，按开始上传的顺序排列
+func (session *ShamirSession) UploadedBatches() ([]ShamirSharesBatch, error) {
+	var sessionShares []ShamirSessionShare
+	err := session.unexpiredShares(DB).
+		Select("identity_name", "chunk", "complete", "expires_at", "created_at").
+		Where("session_id = ?", session.ID).
+		Order("id").Find(&sessionShares).Error
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make([]ShamirSharesBatch, 0)
+	index := make(map[string]int)
+	for _, sessionShare := range sessionShares {
+		i, ok := index[sessionShare.IdentityName]
+		if !ok {
+			i = len(batches)
+			index[sessionShare.IdentityName] = i
+			expiresAt := session.ExpiresAt
+			if sessionShare.ExpiresAt != nil {
+				expiresAt = *sessionShare.ExpiresAt
+			}
+			batches = append(batches, ShamirSharesBatch{IdentityName: sessionShare.IdentityName, ExpiresAt: expiresAt})
+		}
+		batch := &batches[i]
+		batch.Chunks++
+		batch.Complete = batch.Complete || sessionShare.Complete
+		if sessionShare.CreatedAt.After(batch.UploadedAt) {
+			batch.UploadedAt = sessionShare.CreatedAt
+		}
+	}
+	return batches, nil
+}
+
+// ShamirUserShares 一个用户的坐标点，Shares[i] 由 IdentityNames[i] 上传
+type ShamirUserShares struct {
+	IdentityNames []string
+	Shares        shamir.Shares
+}
+
+// ZeroShamirUserShares 用完后覆盖 LoadShares 返回的坐标点
+func ZeroShamirUserShares(allShares map[int]*ShamirUserShares) {
+	for _, userShares := range allShares {
+		userShares.Shares.Zero()
+	}
+}
+
+// Decrypt 容忍错误的坐标点恢复邮箱，返回上传了不一致坐标点的 identity
+func (userShares *ShamirUserShares) Decrypt(threshold int) (string, []string, error) {
+	email, inconsistent, err := shamir.RobustDecrypt(userShares.Shares, threshold)
+	if err != nil {
+		return "", nil, err
+	}
+	identityNames := make([]string, 0, len(inconsistent))
+	for _, index := range inconsistent {
+		identityNames = append(identityNames, userShares.IdentityNames[index])
+	}
+	return email, identityNames, nil
+}
+
+// LoadShares 解密会话中所有上传完成的 identity 的坐标点，按用户 ID 合并
+func (session *ShamirSession) LoadShares() (map[int]*ShamirUserShares, error) {
+	identityNames, err := session.IdentityNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionShares []ShamirSessionShare
+	err = session.unexpiredShares(DB).
+		Where("session_id = ? AND identity_name IN ?", session.ID, identityNames).
+		Order("id").Find(&sessionShares).Error
+	if err != nil {
+		return nil, err
+	}
+
+	allShares := make(map[int]*ShamirUserShares)
+	for _, sessionShare := range sessionShares {
+		shares, err := session.openSessionShare(sessionShare)
+		if err != nil {
+			return nil, err
+		}
+		for userID, share := range shares {
+			userShares, ok := allShares[userID]
+			if !ok {
+				userShares = &ShamirUserShares{}
+				allShares[userID] = userShares
+			}
+			if slices.Contains(userShares.IdentityNames, sessionShare.IdentityName) {
+				share.Zero()
+				continue
+			}
+			userShares.IdentityNames = append(userShares.IdentityNames, sessionShare.IdentityName)
+			userShares.Shares = append(userShares.Shares, share)
+		}
+	}
+	return allShares, nil
+}
+
+// sealShamirShares 使用 AES-256-GCM 加密，会话 ID 和 identity 作为附加数据，密文不能移动到其他会话
+func sealShamirShares(plaintext []byte, sessionID, identityName string) ([]byte, error) {
+	aead, err := newShamirSessionAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(sessionID+"\x00"+identityName)), nil
+}
+
+func openShamirShares(ciphertext []byte, sessionID, identityName string) ([]byte, error) {
+	aead, err := newShamirSessionAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(sessionID+"\x00"+identityName))
+}
+
+func newShamirSessionAEAD() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(config.ShamirSessionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
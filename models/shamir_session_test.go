@@ -0,0 +1,367 @@
+package models
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+	"auth_next/utils/shamir"
+)
+
+func TestShamirSession(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	config.Config.ShamirSessionExpires = 60
+	config.ShamirSessionKey = bytes.Repeat([]byte{1}, 32)
+	ConnectDB()
+
+	session, err := GetOrCreateActiveShamirSession(ShamirSessionTypeDecrypt, 10, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, session.OwnerID, 1)
+
+	// the same active session is returned to other admins
+	other, err := GetOrCreateActiveShamirSession(ShamirSessionTypeDecrypt, 10, 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, other.ID, session.ID)
+	assert.Equal(t, other.OwnerID, 1)
+
+	share := shamir.Share{X: big.NewInt(12345), Y: big.NewInt(67890)}
+	err = session.AddShares("trustee1", 2, map[int]shamir.Share{10: share})
+	assert.Equal(t, err, nil)
+	err = other.AddShares("trustee1", 3, map[int]shamir.Share{10: share})
+	assert.Equal(t, err, ErrShamirSharesUploaded)
+
+	// the requester can't approve, and nobody approves twice
+	err = other.AddShares("trustee2", 1, map[int]shamir.Share{10: share})
+	assert.Equal(t, err, ErrShamirRequesterApproval)
+	err = other.AddShares("trustee2", 2, map[int]shamir.Share{10: share})
+	assert.Equal(t, err, ErrShamirAlreadyApproved)
+	err = other.AddShares("trustee2", 3, map[int]shamir.Share{10: share})
+	assert.Equal(t, err, nil)
+
+	identityNames, err := session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, identityNames, []string{"trustee1", "trustee2"})
+
+	// shares are encrypted at rest and bound to the session
+	var sessionShare ShamirSessionShare
+	err = DB.Where("session_id = ? AND identity_name = ?", session.ID, "trustee1").Take(&sessionShare).Error
+	assert.Equal(t, err, nil)
+	assert.Equal(t, bytes.Contains(sessionShare.EncryptedShares, []byte("67890")), false)
+	_, err = openShamirShares(sessionShare.EncryptedShares, "another", "trustee1")
+	assert.NotEqual(t, err, nil)
+
+	allShares, err := session.LoadShares()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(allShares[10].Shares), 2)
+	assert.Equal(t, allShares[10].Shares[0].Y.Int64(), int64(67890))
+	assert.Equal(t, allShares[10].IdentityNames, []string{"trustee1", "trustee2"})
+
+	// finished sessions release shares, a new session is created next time
+	err = session.Finish(ShamirSessionSuccess)
+	assert.Equal(t, err, nil)
+	var count int64
+	DB.Model(&ShamirSessionShare{}).Where("session_id = ?", session.ID).Count(&count)
+	assert.Equal(t, count, int64(0))
+
+	_, err = GetActiveShamirSession(ShamirSessionTypeDecrypt, 10)
+	assert.Equal(t, err, ErrShamirSessionNotFound)
+	next, err := GetOrCreateActiveShamirSession(ShamirSessionTypeDecrypt, 10, 2)
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, next.ID, session.ID)
+
+	// expired sessions are finished
+	err = DB.Model(next).Update("expires_at", time.Now().Add(-time.Minute)).Error
+	assert.Equal(t, err, nil)
+	_, err = GetActiveShamirSession(ShamirSessionTypeDecrypt, 10)
+	assert.Equal(t, err, ErrShamirSessionNotFound)
+	latest, err := GetLatestShamirSession(ShamirSessionTypeDecrypt, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, latest.Status, ShamirSessionExpired)
+}
+
+func TestShamirSessionChunks(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	config.Config.ShamirSessionExpires = 60
+	config.ShamirSessionKey = bytes.Repeat([]byte{1}, 32)
+	ConnectDB()
+
+	session, err := GetOrCreateActiveShamirSession(ShamirSessionTypeDecrypt, 20, 1)
+	assert.Equal(t, err, nil)
+
+	share := func(y int64) shamir.Share {
+		return shamir.Share{X: big.NewInt(1), Y: big.NewInt(y)}
+	}
+
+	err = session.AddSharesChunk("trustee1", 2, 0, false, map[int]shamir.Share{1: share(1), 2: share(2)})
+	assert.Equal(t, err, nil)
+
+	// incomplete identities are neither counted nor loaded
+	identityNames, err := session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, identityNames, []string{})
+	allShares, err := session.LoadShares()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(allShares), 0)
+
+	// the same chunk or the same user can't be uploaded twice
+	err = session.AddSharesChunk("trustee1", 2, 0, false, map[int]shamir.Share{3: share(3)})
+	assert.Equal(t, err, ErrShamirSharesUploaded)
+	err = session.AddSharesChunk("trustee1", 2, 1, false, map[int]shamir.Share{2: share(2)})
+	assert.Equal(t, err, &ErrShamirChunkUserOrder{After: 2})
+
+	// chunks can't be skipped
+	err = session.AddSharesChunk("trustee1", 2, 2, true, map[int]shamir.Share{3: share(3)})
+	assert.Equal(t, err, &ErrShamirChunkOutOfOrder{Next: 1})
+
+	err = session.AddSharesChunk("trustee1", 2, 1, true, map[int]shamir.Share{3: share(3)})
+	assert.Equal(t, err, nil)
+	uploadedChunks, complete, err := session.UploadedChunks("trustee1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uploadedChunks, 2)
+	assert.Equal(t, complete, true)
+
+	// nothing more after the last chunk
+	err = session.AddSharesChunk("trustee1", 2, 2, true, map[int]shamir.Share{4: share(4)})
+	assert.Equal(t, err, ErrShamirSharesUploaded)
+
+	identityNames, err = session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, identityNames, []string{"trustee1"})
+	allShares, err = session.LoadShares()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(allShares), 3)
+	assert.Equal(t, allShares[3].Shares[0].Y.Int64(), int64(3))
+	assert.Equal(t, allShares[3].IdentityNames, []string{"trustee1"})
+}
+
+func TestShamirDecryptRequest(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	config.Config.ShamirSessionExpires = 60
+	config.ShamirSessionKey = bytes.Repeat([]byte{1}, 32)
+	ConnectDB()
+
+	request, err := CreateShamirDecryptRequest(30, 1, "court order 2024-001")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, request.OwnerID, 1)
+	assert.Equal(t, request.Reason, "court order 2024-001")
+
+	// one pending request per user
+	existing, err := CreateShamirDecryptRequest(30, 2, "another reason")
+	assert.Equal(t, err, ErrShamirSessionExists)
+	assert.Equal(t, existing.ID, request.ID)
+
+	for _, identityName := range []string{"trustee1", "trustee2", "trustee1"} {
+		err = CreateAuditLog(nil, &AuditLog{
+			ActorID:      2,
+			Action:       AuditShamirDecryptApprove,
+			ResourceID:   request.ID,
+			TargetUserID: 30,
+			Detail:       Map{"identity_name": identityName},
+		})
+		assert.Equal(t, err, nil)
+	}
+	approved, err := request.ApprovedIdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, approved, []string{"trustee1", "trustee2"})
+
+	// only one instance can claim the request
+	ok, err := request.StartUpdating()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	ok, err = request.StartUpdating()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+
+	now := time.Now()
+	request.DecryptedAt = &now
+	err = request.FinishWithAudit(ShamirSessionSuccess, &AuditLog{
+		ActorID:      1,
+		Action:       AuditShamirDecryptResult,
+		ResourceID:   request.ID,
+		TargetUserID: 30,
+	})
+	assert.Equal(t, err, nil)
+
+	finished, err := GetShamirSession(request.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, finished.Status, ShamirSessionSuccess)
+	assert.NotEqual(t, finished.DecryptedAt, nil)
+
+	history, err := ListAuditLogs(AuditLog{ResourceID: request.ID}, 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(history), 5)
+	assert.Equal(t, history[0].Action, AuditShamirDecryptResult)
+	assert.Equal(t, history[4].Action, AuditShamirDecryptRequest)
+	assert.Equal(t, history[4].Detail["reason"], "court order 2024-001")
+
+	// expired requests are listed as expired before they are finished
+	expiring, err := CreateShamirDecryptRequest(30, 2, "court order 2024-002")
+	assert.Equal(t, err, nil)
+	err = DB.Model(expiring).Update("expires_at", time.Now().Add(-time.Minute)).Error
+	assert.Equal(t, err, nil)
+
+	requests, err := ListShamirDecryptRequests(30, ShamirSessionExpired, 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(requests), 1)
+	assert.Equal(t, requests[0].ID, expiring.ID)
+	assert.Equal(t, requests[0].EffectiveStatus(), ShamirSessionExpired)
+
+	requests, err = ListShamirDecryptRequests(30, "", 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(requests), 2)
+	requests, err = ListShamirDecryptRequests(30, ShamirSessionPending, 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(requests), 0)
+}
+
+func TestShamirSessionShareExpiry(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	config.Config.ShamirSessionExpires = 60
+	config.Config.ShamirShareTTL = 10
+	config.ShamirSessionKey = bytes.Repeat([]byte{1}, 32)
+	ConnectDB()
+
+	session, err := GetOrCreateActiveShamirSession(ShamirSessionTypeDecrypt, 30, 1)
+	assert.Equal(t, err, nil)
+
+	uploaders := map[string]int{"trustee1": 2, "trustee2": 3}
+	upload := func(identityName string) {
+		err := session.AddShares(identityName, uploaders[identityName], map[int]shamir.Share{30: {X: big.NewInt(1), Y: big.NewInt(1)}})
+		assert.Equal(t, err, nil)
+	}
+	expire := func(identityName string) {
+		err := DB.Model(&ShamirSessionShare{}).
+			Where("session_id = ? AND identity_name = ?", session.ID, identityName).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error
+		assert.Equal(t, err, nil)
+	}
+	countShares := func() int64 {
+		var count int64
+		DB.Model(&ShamirSessionShare{}).Where("session_id = ?", session.ID).Count(&count)
+		return count
+	}
+
+	upload("trustee1")
+	upload("trustee2")
+	batches, err := session.UploadedBatches()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(batches), 2)
+	assert.Equal(t, batches[0].IdentityName, "trustee1")
+	assert.Equal(t, batches[0].Complete, true)
+	assert.Equal(t, batches[0].ExpiresAt.Before(time.Now().Add(11*time.Minute)), true)
+
+	// expired shares of a pending session are ignored and can be uploaded again
+	expire("trustee1")
+	identityNames, err := session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, identityNames, []string{"trustee2"})
+	batches, err = session.UploadedBatches()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(batches), 1)
+	upload("trustee1")
+	identityNames, err = session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, identityNames, []string{"trustee2", "trustee1"})
+
+	// the sweeper purges expired shares
+	expire("trustee1")
+	finished, purged, err := SweepShamirSessions()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, finished, 0)
+	assert.Equal(t, purged, int64(1))
+	assert.Equal(t, countShares(), int64(1))
+
+	// shares are kept once decryption started
+	upload("trustee1")
+	expire("trustee1")
+	ok, err := session.StartUpdating()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	identityNames, err = session.IdentityNames()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(identityNames), 2)
+	_, purged, err = SweepShamirSessions()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, purged, int64(0))
+
+	// expired sessions are finished, pending decrypt requests are audited
+	request, err := CreateShamirDecryptRequest(31, 1, "expired request")
+	assert.Equal(t, err, nil)
+	err = DB.Model(&ShamirSession{}).Where("id IN ?", []string{session.ID, request.ID}).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error
+	assert.Equal(t, err, nil)
+	finished, _, err = SweepShamirSessions()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, finished, 2)
+	assert.Equal(t, countShares(), int64(0))
+
+	expired, err := GetShamirSession(request.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, expired.Status, ShamirSessionExpired)
+	auditLogs, err := ListAuditLogs(AuditLog{ResourceID: request.ID, Action: AuditShamirDecryptExpire}, 0, 10)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(auditLogs), 1)
+}
+
+func TestShamirSessionTakeOver(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	config.Config.ShamirSessionExpires = 60
+	ConnectDB()
+
+	session, err := GetOrCreateActiveShamirSession(ShamirSessionTypeUpdate, 0, 1)
+	assert.Equal(t, err, nil)
+	ok, err := session.StartUpdating()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	err = session.SaveCheckpoint(DB, 10, 10, "")
+	assert.Equal(t, err, nil)
+
+	// a running update can't be taken over
+	other, err := GetShamirSession(session.ID)
+	assert.Equal(t, err, nil)
+	ok, err = other.TakeOver()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+
+	// the instance stalls, only one instance takes over
+	err = DB.Model(&ShamirSession{}).Where("id = ?", session.ID).
+		Update("updated_at", time.Now().Add(-ShamirUpdateStalledAfter-time.Minute)).Error
+	assert.Equal(t, err, nil)
+	other, err = GetShamirSession(session.ID)
+	assert.Equal(t, err, nil)
+	another := *other
+	ok, err = other.TakeOver()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	ok, err = another.TakeOver()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+
+	// the stalled instance can't save progress or finish the session after it resumes
+	err = session.SaveCheckpoint(DB, 20, 20, "")
+	assert.Equal(t, err, ErrShamirSessionSuperseded)
+	err = session.SaveNewKeySet(1)
+	assert.Equal(t, err, ErrShamirSessionSuperseded)
+	_, err = session.ActivateNewKeySet(&ShamirKeySet{ID: 1})
+	assert.Equal(t, err, ErrShamirSessionSuperseded)
+	err = session.Finish(ShamirSessionFailed)
+	assert.Equal(t, err, ErrShamirSessionSuperseded)
+
+	err = other.SaveCheckpoint(DB, 20, 20, "")
+	assert.Equal(t, err, nil)
+	err = other.Finish(ShamirSessionSuccess)
+	assert.Equal(t, err, nil)
+	finished, err := GetShamirSession(session.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, finished.Status, ShamirSessionSuccess)
+	assert.Equal(t, finished.CheckpointUserID, 20)
+}
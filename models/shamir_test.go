@@ -0,0 +1,209 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+	"auth_next/utils/shamir"
+)
+
+func TestShamirKeySet(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	ConnectDB()
+
+	// stale public keys of a larger key set
+	for i := 1; i <= 7; i++ {
+		err := DB.Create(&ShamirPublicKey{ID: i, IdentityName: "stale", ArmoredPublicKey: "stale"}).Error
+		assert.Equal(t, err, nil)
+	}
+
+	privateKeys, publicKeys := generateShamirTestKeys(t, "trustee", 3)
+
+	var currentKeySet ShamirKeySet
+	err := SaveShamirKeySet(DB, &currentKeySet, publicKeys, 2)
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, currentKeySet.ID, 0)
+	assert.Equal(t, currentKeySet.KeyCount, 3)
+	SetCurrentShamirKeys(currentKeySet, publicKeys)
+
+	var storedKeys []ShamirPublicKey
+	err = DB.Find(&storedKeys).Error
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(storedKeys), 3)
+	for _, key := range storedKeys {
+		assert.Equal(t, key.KeySetID, currentKeySet.ID)
+	}
+
+	email := "keyset@example.com"
+	shamirEmails, commitments, err := GenerateShamirEmails(1, email)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(shamirEmails), 3)
+	assert.Equal(t, len(commitments), 1)
+	err = SaveShamirEmails(DB, shamirEmails, commitments)
+	assert.Equal(t, err, nil)
+
+	// any two of three shares recover the email
+	shares := make([]shamir.Share, 0, 2)
+	for i := 1; i <= 2; i++ {
+		message, err := crypto.NewPGPMessageFromArmored(shamirEmails[i].Key)
+		assert.Equal(t, err, nil)
+		plainMessage, err := privateKeys[i].Decrypt(message, nil, 0)
+		assert.Equal(t, err, nil)
+		share, err := shamir.FromString(plainMessage.GetString())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, *share.Meta, shamir.ShareMeta{KeySetID: currentKeySet.ID, UserID: 1, Index: i + 1, Threshold: 2})
+		shares = append(shares, share)
+	}
+	assert.Equal(t, shamir.Decrypt(shares), email)
+
+	// each share is verified against the commitments, users without commitments are not verified
+	invalid, err := VerifyShamirShares(currentKeySet.ID, map[int]shamir.Share{1: shares[0], 2: shares[1]})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, invalid, []int{})
+
+	shares[0].Y.Add(shares[0].Y, big.NewInt(1))
+	invalid, err = VerifyShamirShares(currentKeySet.ID, map[int]shamir.Share{1: shares[0]})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, invalid, []int{1})
+}
+
+func TestShamirKeySetGeneration(t *testing.T) {
+	config.Config.Mode = "test"
+	config.Config.ShamirFeature = true
+	ConnectDB()
+
+	_, oldPublicKeys := generateShamirTestKeys(t, "old", 3)
+	var oldKeySet ShamirKeySet
+	err := SaveShamirKeySet(DB, &oldKeySet, oldPublicKeys, 2)
+	assert.Equal(t, err, nil)
+	SetCurrentShamirKeys(oldKeySet, oldPublicKeys)
+
+	for userID := 1; userID <= 3; userID++ {
+		err = CreateShamirEmails(DB, userID, fmt.Sprintf("user%d@example.com", userID))
+		assert.Equal(t, err, nil)
+	}
+
+	// new users are encrypted with both key sets while building
+	_, newPublicKeys := generateShamirTestKeys(t, "new", 3)
+	keySet, newPublicKeys, err := CreateBuildingShamirKeySet(newPublicKeys, 3)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keySet.Status, ShamirKeySetBuilding)
+
+	shamirEmails, commitments, err := GenerateShamirEmails(4, "user4@example.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(shamirEmails), 6)
+	assert.Equal(t, len(commitments), 2)
+	err = SaveShamirEmails(DB, shamirEmails, commitments)
+	assert.Equal(t, err, nil)
+
+	// replacing a batch twice gives the same rows
+	for i := 0; i < 2; i++ {
+		batch := make([]ShamirEmail, 0, 6)
+		batchCommitments := make([]ShamirCommitment, 0, 2)
+		for userID := 1; userID <= 2; userID++ {
+			userShamirEmails, commitment, err := GenerateShamirEmailsWithKeys(keySet, newPublicKeys, userID, fmt.Sprintf("user%d@example.com", userID))
+			assert.Equal(t, err, nil)
+			batch = append(batch, userShamirEmails...)
+			batchCommitments = append(batchCommitments, *commitment)
+		}
+		err = ReplaceShamirEmails(DB, keySet.ID, []int{1, 2}, batch, batchCommitments)
+		assert.Equal(t, err, nil)
+	}
+
+	var count int64
+	DB.Model(&ShamirEmail{}).Where("key_set_id = ?", keySet.ID).Count(&count)
+	assert.Equal(t, count, int64(9))
+	DB.Model(&ShamirEmail{}).Where("key_set_id = ?", oldKeySet.ID).Count(&count)
+	assert.Equal(t, count, int64(12))
+	DB.Model(&ShamirCommitment{}).Where("key_set_id = ?", keySet.ID).Count(&count)
+	assert.Equal(t, count, int64(3))
+
+	// user 3 has no uploaded shares, e.g. registered after the trustees fetched their messages;
+	// its rows are carried forward on switch instead of being lost
+	retired, err := ActivateShamirKeySet(keySet)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(retired), 1)
+	assert.Equal(t, retired[0].ID, oldKeySet.ID)
+
+	err = PurgeShamirKeySet(oldKeySet.ID, ShamirKeySetRetired)
+	assert.Equal(t, err, nil)
+	DB.Model(&ShamirEmail{}).Where("key_set_id = ?", oldKeySet.ID).Count(&count)
+	assert.Equal(t, count, int64(0))
+	DB.Model(&ShamirCommitment{}).Where("key_set_id = ?", oldKeySet.ID).Count(&count)
+	assert.Equal(t, count, int64(0))
+	var carried []ShamirEmail
+	DB.Where("key_set_id = ? AND user_id = ?", keySet.ID, 3).Find(&carried)
+	assert.Equal(t, len(carried), 3)
+	assert.Equal(t, carried[0].CarriedFromKeySetID, oldKeySet.ID)
+	DB.Model(&ShamirEmail{}).Where("key_set_id = ? AND carried_from_key_set_id <> 0", keySet.ID).Count(&count)
+	assert.Equal(t, count, int64(3))
+
+	err = ReloadShamirPublicKeys()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, CurrentShamirKeySet().ID, keySet.ID)
+	assert.Equal(t, CurrentShamirKeySet().Threshold, 3)
+
+	building, _, err := BuildingShamirKeySet()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, building, nil)
+
+	// new users are encrypted with the active key set in the database, even if this instance hasn't reloaded it
+	SetCurrentShamirKeys(oldKeySet, oldPublicKeys)
+	shamirEmails, commitments, err = GenerateShamirEmails(5, "user5@example.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(commitments), 1)
+	assert.Equal(t, commitments[0].KeySetID, keySet.ID)
+	assert.Equal(t, shamirEmails[0].KeySetID, keySet.ID)
+
+	// carried forward rows are encrypted again on login, repeating it replaces the rows
+	hasShamir, err := HasActiveShamirEmails(DB, 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hasShamir, true)
+	hasShamir, err = HasActiveShamirEmails(DB, 3)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hasShamir, false)
+	for i := 0; i < 2; i++ {
+		err = CreateShamirEmails(DB, 3, "user3@example.com")
+		assert.Equal(t, err, nil)
+	}
+	hasShamir, err = HasActiveShamirEmails(DB, 3)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, hasShamir, true)
+	DB.Model(&ShamirEmail{}).Where("key_set_id = ? AND user_id = ?", keySet.ID, 3).Count(&count)
+	assert.Equal(t, count, int64(3))
+	DB.Model(&ShamirCommitment{}).Where("key_set_id = ? AND user_id = ?", keySet.ID, 3).Count(&count)
+	assert.Equal(t, count, int64(1))
+}
+
+func generateShamirTestKeys(t *testing.T, prefix string, num int) ([]*crypto.KeyRing, []ShamirPublicKey) {
+	privateKeys := make([]*crypto.KeyRing, 0, num)
+	publicKeys := make([]ShamirPublicKey, 0, num)
+	for i := 1; i <= num; i++ {
+		identityName := fmt.Sprintf("%s%d", prefix, i)
+		key, err := crypto.GenerateKey(identityName, identityName+"@example.com", "x25519", 0)
+		assert.Equal(t, err, nil)
+		privateKeyRing, err := crypto.NewKeyRing(key)
+		assert.Equal(t, err, nil)
+		privateKeys = append(privateKeys, privateKeyRing)
+
+		armoredPublicKey, err := key.GetArmoredPublicKey()
+		assert.Equal(t, err, nil)
+		publicKey, err := crypto.NewKeyFromArmored(armoredPublicKey)
+		assert.Equal(t, err, nil)
+		publicKeyRing, err := crypto.NewKeyRing(publicKey)
+		assert.Equal(t, err, nil)
+		publicKeys = append(publicKeys, ShamirPublicKey{
+			ID:               i,
+			IdentityName:     key.GetEntity().PrimaryIdentity().Name,
+			ArmoredPublicKey: armoredPublicKey,
+			PublicKey:        publicKeyRing,
+		})
+	}
+	return privateKeys, publicKeys
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
+
+	"auth_next/utils/i18n"
 )
 
 type User struct {
@@ -19,7 +21,7 @@ type User struct {
 	UserID               int            `json:"user_id" gorm:"-"`
 	Nickname             string         `json:"nickname" gorm:"default:user;size:32"`
 	Email                string         `json:"-" gorm:"-:all"`
-	Identifier           sql.NullString `json:"-" gorm:"size:128;uniqueIndex:,length:10"`
+	Identifier           sql.NullString `json:"-" gorm:"size:160;uniqueIndex:idx_user_identifier_prefix,length:32"`
 	Password             string         `json:"-" gorm:"size:128"`
 	IsAdmin              bool           `json:"is_admin" gorm:"default:false;index"`
 	IsShamirAdmin        bool           `json:"is_shamir_admin" gorm:"default:false;index"`
@@ -117,7 +119,7 @@ func LoadUserFromDB(userID int) (*User, error) {
 	err := DB.Where("is_active = true").Take(&user, userID).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, common.NotFound("User Not Found")
+			return nil, i18n.NotFound(i18n.CodeUserNotFound)
 		} else {
 			return nil, err
 		}
@@ -150,7 +152,7 @@ func GetUserByRefreshToken(c *fiber.Ctx) (string, *User, error) {
 	}
 
 	if tokenType, ok := payload["type"]; !ok || tokenType != "refresh" {
-		return "", nil, common.Unauthorized("refresh token invalid")
+		return "", nil, i18n.Unauthorized(i18n.CodeRefreshTokenInvalid)
 	}
 
 	user, err := LoadUserFromDB(userID)
@@ -165,6 +167,11 @@ func DeleteUserService(tx *gorm.DB, userID int, identifier string) error {
 			return err
 		}
 
-		return tx.Model(&User{ID: userID}).UpdateColumns(map[string]any{"is_active": false, "identifier": nil}).Error
+		err = tx.Model(&User{ID: userID}).UpdateColumns(map[string]any{"is_active": false, "identifier": nil}).Error
+		if err != nil {
+			return err
+		}
+
+		return EmitWebhookEvent(tx, WebhookEventUserDeleted, WebhookUserData{UserID: userID})
 	})
 }
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+	"github.com/thanhpk/randstr"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+
+	"auth_next/config"
+	"auth_next/utils/webhook"
+)
+
+const (
+	WebhookEventUserCreated         = "user.created"
+	WebhookEventUserDeleted         = "user.deleted"
+	WebhookEventUserNicknameChanged = "user.nickname_changed"
+	WebhookEventUserPasswordChanged = "user.password_changed"
+
+	// WebhookEventAll subscribe all events
+	WebhookEventAll = "*"
+)
+
+var WebhookEvents = []string{
+	WebhookEventUserCreated,
+	WebhookEventUserDeleted,
+	WebhookEventUserNicknameChanged,
+	WebhookEventUserPasswordChanged,
+}
+
+const (
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+type WebhookSubscription struct {
+	ID         int       `json:"id" gorm:"primaryKey"`
+	URL        string    `json:"url" gorm:"size:1024;not null"`
+	Secret     string    `json:"-" gorm:"size:256;not null"`
+	EventTypes []string  `json:"event_types" gorm:"serializer:json"`
+	IsActive   bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int        `json:"id" gorm:"primaryKey"`
+	SubscriptionID int        `json:"subscription_id" gorm:"index"`
+	EventID        string     `json:"event_id" gorm:"size:32;index"`
+	EventType      string     `json:"event_type" gorm:"size:64"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status" gorm:"size:16;index:idx_webhook_delivery_due,priority:1"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index:idx_webhook_delivery_due,priority:2"`
+	ResponseStatus int        `json:"response_status"`
+	LastError      string     `json:"last_error" gorm:"size:1024"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type WebhookPayload struct {
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type WebhookUserData struct {
+	UserID      int    `json:"user_id"`
+	Nickname    string `json:"nickname,omitempty"`
+	OldNickname string `json:"old_nickname,omitempty"`
+}
+
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	return slices.Contains(s.EventTypes, WebhookEventAll) || slices.Contains(s.EventTypes, eventType)
+}
+
+// EmitWebhookEvent 在事务中为所有订阅了该事件的 webhook 创建投递记录，
+// 实际发送由 WebhookDeliveryTask 异步完成，保证事件与业务数据一同提交
+func EmitWebhookEvent(tx *gorm.DB, eventType string, data any) error {
+	var subscriptions []WebhookSubscription
+	err := tx.Where("is_active = true").Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+
+	payload := WebhookPayload{
+		EventID:   randstr.Hex(16),
+		EventType: eventType,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(subscriptions))
+	for i := range subscriptions {
+		if !subscriptions[i].Subscribes(eventType) {
+			continue
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			SubscriptionID: subscriptions[i].ID,
+			EventID:        payload.EventID,
+			EventType:      eventType,
+			Payload:        string(payloadBytes),
+			Status:         WebhookDeliveryPending,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return tx.Create(&deliveries).Error
+}
+
+// webhookRetryDelay 指数退避，30s, 1m, 2m ... 最长 6h
+func webhookRetryDelay(attempts int) time.Duration {
+	const maxDelay = 6 * time.Hour
+	delay := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+// WebhookDeliveryTask 定时投递到期的 webhook，直到 ctx 结束
+func WebhookDeliveryTask(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := DeliverDueWebhooks()
+			if err != nil {
+				log.Err(err).Msg("deliver webhooks failed")
+			}
+		}
+	}
+}
+
+func DeliverDueWebhooks() error {
+	var deliveries []WebhookDelivery
+	err := DB.
+		Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at asc").
+		Limit(100).
+		Find(&deliveries).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		// claim the delivery, so that other instances won't send it concurrently
+		result := DB.Model(&WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, WebhookDeliveryPending, delivery.NextAttemptAt).
+			Update("next_attempt_at", time.Now().Add(time.Minute))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		err = DeliverWebhook(delivery)
+		if err != nil {
+			log.Warn().Err(err).
+				Int("delivery_id", delivery.ID).
+				Int("subscription_id", delivery.SubscriptionID).
+				Str("event_type", delivery.EventType).
+				Msg("webhook delivery failed")
+		}
+	}
+	return nil
+}
+
+// ClaimRedelivery 将投递重置为 pending 并占用，与 DeliverDueWebhooks 相同，只有读取后没有被其他实例修改时才能成功
+func (delivery *WebhookDelivery) ClaimRedelivery() (bool, error) {
+	nextAttemptAt := time.Now().Add(time.Minute)
+	result := DB.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ? AND next_attempt_at = ?",
+			delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt).
+		Updates(map[string]any{"status": WebhookDeliveryPending, "attempts": 0, "next_attempt_at": nextAttemptAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	delivery.Status = WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = nextAttemptAt
+	return true, nil
+}
+
+// DeliverWebhook 发送一次投递并记录结果，失败时按照指数退避安排重试
+func DeliverWebhook(delivery *WebhookDelivery) error {
+	var subscription WebhookSubscription
+	err := DB.Take(&subscription, delivery.SubscriptionID).Error
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	if subscription.IsActive {
+		delivery.ResponseStatus, sendErr = webhook.Send(
+			subscription.URL,
+			subscription.Secret,
+			delivery.EventType,
+			fmt.Sprintf("%s-%d", delivery.EventID, delivery.ID),
+			[]byte(delivery.Payload),
+		)
+	} else {
+		sendErr = fmt.Errorf("webhook subscription %d is inactive", subscription.ID)
+	}
+
+	delivery.Attempts++
+	if sendErr == nil {
+		now := time.Now()
+		delivery.Status = WebhookDeliverySuccess
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = sendErr.Error()
+		if len(delivery.LastError) > 1024 {
+			delivery.LastError = delivery.LastError[:1024]
+		}
+		if delivery.Attempts >= config.Config.WebhookMaxAttempts || !subscription.IsActive {
+			delivery.Status = WebhookDeliveryFailed
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(webhookRetryDelay(delivery.Attempts))
+		}
+	}
+
+	err = DB.Select("Status", "Attempts", "NextAttemptAt", "ResponseStatus", "LastError", "DeliveredAt").
+		Updates(delivery).Error
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestWebhookClaimRedelivery(t *testing.T) {
+	config.Config.Mode = "test"
+	ConnectDB()
+
+	delivery := WebhookDelivery{
+		SubscriptionID: 1,
+		EventID:        "event",
+		EventType:      "user.created",
+		Status:         WebhookDeliveryFailed,
+		Attempts:       5,
+		NextAttemptAt:  time.Now().Add(-time.Hour),
+	}
+	err := DB.Create(&delivery).Error
+	assert.Equal(t, err, nil)
+
+	var loaded, other WebhookDelivery
+	err = DB.Take(&loaded, delivery.ID).Error
+	assert.Equal(t, err, nil)
+	err = DB.Take(&other, delivery.ID).Error
+	assert.Equal(t, err, nil)
+
+	// only one of the admins redelivering at the same time claims the delivery
+	ok, err := loaded.ClaimRedelivery()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, loaded.Status, WebhookDeliveryPending)
+	assert.Equal(t, loaded.Attempts, 0)
+	ok, err = other.ClaimRedelivery()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, false)
+
+	// the delivery task doesn't send a claimed delivery before it's due
+	err = DeliverDueWebhooks()
+	assert.Equal(t, err, nil)
+	err = DB.Take(&other, delivery.ID).Error
+	assert.Equal(t, err, nil)
+	assert.Equal(t, other.Status, WebhookDeliveryPending)
+	assert.Equal(t, other.Attempts, 0)
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"strings"
+
+	"auth_next/config"
+)
+
+// CanonicalizeEmail 规范化邮箱，用于计算 identifier 和验证码的 key.
+// 去除首尾空白并转为小写，按照 EMAIL_DOMAIN_ALIASES 替换域名别名，
+// 如果开启 EMAIL_STRIP_PLUS_TAG，去除用户名中 + 及之后的部分
+func CanonicalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	index := strings.LastIndexByte(email, '@')
+	if index < 0 {
+		return email
+	}
+	localPart, domain := email[:index], email[index+1:]
+
+	if alias, ok := config.Config.EmailDomainAliases[domain]; ok {
+		domain = strings.ToLower(alias)
+	}
+
+	if config.Config.EmailStripPlusTag {
+		if plusIndex := strings.IndexByte(localPart, '+'); plusIndex > 0 {
+			localPart = localPart[:plusIndex]
+		}
+	}
+
+	return localPart + "@" + domain
+}
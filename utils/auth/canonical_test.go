@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestCanonicalizeEmail(t *testing.T) {
+	config.Config.EmailDomainAliases = map[string]string{"m.fudan.edu.cn": "fudan.edu.cn"}
+	config.Config.EmailStripPlusTag = false
+	assert.Equal(t, CanonicalizeEmail(" Foo@Fudan.edu.cn "), "foo@fudan.edu.cn")
+	assert.Equal(t, CanonicalizeEmail("foo@m.fudan.edu.cn"), "foo@fudan.edu.cn")
+	assert.Equal(t, CanonicalizeEmail("foo+bar@fudan.edu.cn"), "foo+bar@fudan.edu.cn")
+
+	config.Config.EmailStripPlusTag = true
+	assert.Equal(t, CanonicalizeEmail("foo+bar@fudan.edu.cn"), "foo@fudan.edu.cn")
+	assert.Equal(t, CanonicalizeEmail("+bar@fudan.edu.cn"), "+bar@fudan.edu.cn")
+
+	assert.Equal(t, MakeIdentifier("Foo@m.fudan.edu.cn"), MakeIdentifier("foo@fudan.edu.cn"))
+	assert.Equal(t, IdentifierCandidates("foo@fudan.edu.cn"), []string{MakeIdentifier("foo@fudan.edu.cn")})
+	assert.Equal(t, len(IdentifierCandidates("Foo@fudan.edu.cn")), 2)
+}
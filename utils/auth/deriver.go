@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/hmac"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/sha3"
+
+	"auth_next/config"
+)
+
+// identifierLength 各算法输出的字节数，hex 编码后为 128 个字符
+const identifierLength = 64
+
+// IdentifierDeriver 由邮箱（或旧版本的 identifier）计算 identifier 的哈希部分
+type IdentifierDeriver interface {
+	Derive(input []byte) []byte
+}
+
+func NewIdentifierDeriver(salt config.IdentifierSaltConfig) IdentifierDeriver {
+	switch salt.Algorithm {
+	case config.IdentifierAlgorithmHMAC:
+		return &HMACDeriver{Key: salt.Salt}
+	case config.IdentifierAlgorithmArgon2id:
+		return &Argon2idDeriver{Salt: salt.Salt, Time: salt.Time, Memory: salt.Memory, Threads: salt.Threads}
+	default:
+		return &PBKDF2Deriver{Salt: salt.Salt}
+	}
+}
+
+// PBKDF2Deriver PBKDF2-SHA3-512 一次迭代，等价于一次加盐哈希，仅用于兼容旧数据
+type PBKDF2Deriver struct {
+	Salt []byte
+}
+
+func (d *PBKDF2Deriver) Derive(input []byte) []byte {
+	return pbkdf2.Key(input, d.Salt, 1, identifierLength, sha3.New512)
+}
+
+// HMACDeriver HMAC-SHA3-512，安全性取决于密钥是否与数据库一同泄露
+type HMACDeriver struct {
+	Key []byte
+}
+
+func (d *HMACDeriver) Derive(input []byte) []byte {
+	mac := hmac.New(sha3.New512, d.Key)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+// Argon2idDeriver argon2id，salt 泄露时每次猜测邮箱都需要 Memory KiB 内存和相应的时间
+type Argon2idDeriver struct {
+	Salt    []byte
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (d *Argon2idDeriver) Derive(input []byte) []byte {
+	return argon2.IDKey(input, d.Salt, d.Time, d.Memory, d.Threads, identifierLength)
+}
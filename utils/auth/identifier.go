@@ -13,21 +13,122 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/pbkdf2"
-	"golang.org/x/crypto/sha3"
 
 	"auth_next/config"
 )
 
+// identifier 格式：版本 1 为 salt 哈希的 hex 编码，兼容旧数据；之后的版本为 "v{版本号}$" + hex 编码
+const identifierVersionPrefix = "v"
+const identifierVersionSeparator = "$"
+
+// currentIdentifierVersion 新 identifier 使用的 salt 版本，未加载配置时为 1
+func currentIdentifierVersion() int {
+	if config.IdentifierSaltVersion == 0 {
+		return 1
+	}
+	return config.IdentifierSaltVersion
+}
+
+// IdentifierVersions 所有可用的 salt 版本，当前版本在最前面
+func IdentifierVersions() []int {
+	current := currentIdentifierVersion()
+	versions := []int{current}
+	for _, version := range config.IdentifierSaltVersions {
+		if version != current {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// IdentifierVersion 返回 identifier 的 salt 版本，无法解析时返回 0
+func IdentifierVersion(identifier string) int {
+	if !strings.HasPrefix(identifier, identifierVersionPrefix) {
+		return 1
+	}
+	versionString, _, found := strings.Cut(identifier[len(identifierVersionPrefix):], identifierVersionSeparator)
+	if !found {
+		return 0
+	}
+	version, err := strconv.Atoi(versionString)
+	if err != nil || version <= 1 {
+		return 0
+	}
+	return version
+}
+
+// IdentifierVersionPattern 返回匹配某个版本 identifier 的 SQL LIKE 模式，版本 1 没有前缀
+func IdentifierVersionPattern(version int) string {
+	return identifierVersionPrefix + strconv.Itoa(version) + identifierVersionSeparator + "%"
+}
+
+// deriveIdentifier 计算某个版本 identifier 的哈希部分，不包含版本前缀
+func deriveIdentifier(version int, email string) []byte {
+	salt := config.IdentifierSalts[version]
+	input := []byte(email)
+	if salt.Wrap != 0 {
+		input = []byte(hex.EncodeToString(deriveIdentifier(salt.Wrap, email)))
+	}
+	return NewIdentifierDeriver(salt).Derive(input)
+}
+
+func formatIdentifier(version int, hash []byte) string {
+	if version == 1 {
+		return hex.EncodeToString(hash)
+	}
+	return identifierVersionPrefix + strconv.Itoa(version) + identifierVersionSeparator + hex.EncodeToString(hash)
+}
+
+func makeIdentifier(version int, email string) string {
+	return formatIdentifier(version, deriveIdentifier(version, email))
+}
+
+// WrapIdentifier 不经过邮箱，直接将旧版本的 identifier 转换为 version 版本，version 必须配置了 wrap 为旧版本
+func WrapIdentifier(version int, identifier string) (string, error) {
+	salt, ok := config.IdentifierSalts[version]
+	if !ok {
+		return "", fmt.Errorf("identifier salt version %d not found", version)
+	}
+	if salt.Wrap == 0 {
+		return "", fmt.Errorf("identifier salt version %d doesn't wrap an older version", version)
+	}
+	if IdentifierVersion(identifier) != salt.Wrap {
+		return "", fmt.Errorf("identifier is not of version %d", salt.Wrap)
+	}
+
+	// strip the version prefix
+	_, hash, found := strings.Cut(identifier, identifierVersionSeparator)
+	if !found {
+		hash = identifier
+	}
+	if len(hash) != 2*identifierLength {
+		return "", fmt.Errorf("invalid identifier length %d", len(hash))
+	}
+	return formatIdentifier(version, NewIdentifierDeriver(salt).Derive([]byte(hash))), nil
+}
+
+// MakeIdentifier 使用当前版本的 salt 计算规范化后邮箱的 identifier
 func MakeIdentifier(email string) string {
-	return hex.EncodeToString(
-		pbkdf2.Key(
-			[]byte(email),
-			config.DecryptedIdentifierSalt,
-			1,
-			64,
-			sha3.New512,
-		),
-	)
+	return makeIdentifier(currentIdentifierVersion(), CanonicalizeEmail(email))
+}
+
+// MakeRawIdentifier 使用当前版本的 salt 计算未经规范化的邮箱的 identifier
+func MakeRawIdentifier(email string) string {
+	return makeIdentifier(currentIdentifierVersion(), email)
+}
+
+// IdentifierCandidates 查找用户时可能匹配的所有 identifier，第一个为当前的 identifier.
+// 包括所有可用版本的 salt 计算的规范化和未规范化（规范化之前注册的用户）的 identifier
+func IdentifierCandidates(email string) []string {
+	canonicalEmail := CanonicalizeEmail(email)
+	candidates := make([]string, 0, 2)
+	for _, version := range IdentifierVersions() {
+		candidates = append(candidates, makeIdentifier(version, canonicalEmail))
+		if canonicalEmail != email {
+			candidates = append(candidates, makeIdentifier(version, email))
+		}
+	}
+	return candidates
 }
 
 func passwordHash(bytePassword, salt []byte, iterations, KeyLen int, hash func() hash.Hash) string {
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+
+	"auth_next/config"
+)
+
+// 比较各算法计算一个 identifier 的开销，也即攻击者在 salt 泄露后枚举一个邮箱的开销
+//
+//	go test -run ^$ -bench Derive -benchmem ./utils/auth
+func BenchmarkDerive(b *testing.B) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	derivers := []struct {
+		name    string
+		deriver IdentifierDeriver
+	}{
+		{"pbkdf2", &PBKDF2Deriver{Salt: key}},
+		{"hmac", &HMACDeriver{Key: key}},
+		{"argon2id-t1-m64m-p4", &Argon2idDeriver{Salt: key, Time: 1, Memory: 64 * 1024, Threads: 4}},
+		{"argon2id-t3-m64m-p1", &Argon2idDeriver{Salt: key, Time: 3, Memory: 64 * 1024, Threads: 1}},
+		{"argon2id-t1-m16m-p1", &Argon2idDeriver{Salt: key, Time: 1, Memory: 16 * 1024, Threads: 1}},
+	}
+	for _, d := range derivers {
+		b.Run(d.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				d.deriver.Derive([]byte(fmt.Sprintf("%d@fudan.edu.cn", 21300000000+i)))
+			}
+		})
+	}
+}
+
+// 登录时查找用户的开销，随可用版本数量增长
+func BenchmarkIdentifierCandidates(b *testing.B) {
+	salts, err := config.ParseIdentifierSalts(
+		"1 MTIzNDU2\n" +
+			"2 hmac MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY= wrap=1\n" +
+			"3 argon2id YWJjZGVmZ2g= wrap=1\n",
+	)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		config.IdentifierSalts = nil
+		config.IdentifierSaltVersions = nil
+		config.IdentifierSaltVersion = 0
+	}()
+	config.IdentifierSalts = salts
+	config.IdentifierSaltVersions = []int{3, 2, 1}
+	config.IdentifierSaltVersion = 3
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IdentifierCandidates(fmt.Sprintf("%d@fudan.edu.cn", 21300000000+i))
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestIdentifierVersions(t *testing.T) {
+	salts, err := config.ParseIdentifierSalts("# comment\n2 MTIzNDU2Nzg=\n\n3 YWJjZGVmZ2g=\n")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(salts), 2)
+	_, err = config.ParseIdentifierSalts("2 MTIzNDU2Nzg=\n2 YWJjZGVmZ2g=")
+	assert.NotEqual(t, err, nil)
+	_, err = config.ParseIdentifierSalts("v2 MTIzNDU2Nzg=")
+	assert.NotEqual(t, err, nil)
+
+	defer func() {
+		config.IdentifierSalts = nil
+		config.IdentifierSaltVersions = nil
+		config.IdentifierSaltVersion = 0
+	}()
+	config.Config.EmailDomainAliases = nil
+	config.Config.EmailStripPlusTag = false
+	salts[1] = config.IdentifierSaltConfig{Algorithm: config.IdentifierAlgorithmPBKDF2, Salt: []byte("123456")}
+	config.IdentifierSalts = salts
+	config.IdentifierSaltVersions = []int{3, 2, 1}
+
+	// legacy identifiers are not prefixed
+	config.IdentifierSaltVersion = 1
+	legacy := MakeIdentifier("foo@fudan.edu.cn")
+	assert.Equal(t, len(legacy), 128)
+	assert.Equal(t, IdentifierVersion(legacy), 1)
+
+	// rotate to version 2
+	config.IdentifierSaltVersion = 2
+	identifier := MakeIdentifier("foo@fudan.edu.cn")
+	assert.Equal(t, strings.HasPrefix(identifier, "v2$"), true)
+	assert.Equal(t, IdentifierVersion(identifier), 2)
+	assert.Equal(t, IdentifierVersions(), []int{2, 3, 1})
+
+	candidates := IdentifierCandidates("foo@fudan.edu.cn")
+	assert.Equal(t, len(candidates), 3)
+	assert.Equal(t, candidates[0], identifier)
+	assert.Equal(t, candidates[2], legacy)
+	assert.Equal(t, len(IdentifierCandidates("Foo@fudan.edu.cn")), 6)
+
+	assert.Equal(t, IdentifierVersion("v$abc"), 0)
+	assert.Equal(t, IdentifierVersion("v1$abc"), 0)
+	assert.Equal(t, IdentifierVersionPattern(2), "v2$%")
+}
+
+func TestWrapIdentifier(t *testing.T) {
+	salts, err := config.ParseIdentifierSalts(
+		"1 MTIzNDU2\n" +
+			"2 hmac MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY= wrap=1\n" +
+			"3 argon2id YWJjZGVmZ2g= wrap=2,t=1,m=1024,p=1\n",
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, salts[3].Memory, uint32(1024))
+	_, err = config.ParseIdentifierSalts("2 hmac c2hvcnQ=")
+	assert.NotEqual(t, err, nil)
+	_, err = config.ParseIdentifierSalts("2 argon2id c2hvcnQ= wrap=2")
+	assert.NotEqual(t, err, nil)
+	_, err = config.ParseIdentifierSalts("2 sha1 c2hvcnQ=")
+	assert.NotEqual(t, err, nil)
+
+	defer func() {
+		config.IdentifierSalts = nil
+		config.IdentifierSaltVersions = nil
+		config.IdentifierSaltVersion = 0
+	}()
+	config.Config.EmailDomainAliases = nil
+	config.Config.EmailStripPlusTag = false
+	config.IdentifierSalts = salts
+	config.IdentifierSaltVersions = []int{3, 2, 1}
+	config.IdentifierSaltVersion = 3
+
+	legacy := makeIdentifier(1, "foo@fudan.edu.cn")
+	wrapped, err := WrapIdentifier(2, legacy)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, wrapped, makeIdentifier(2, "foo@fudan.edu.cn"))
+
+	// wrapping is chained
+	wrapped, err = WrapIdentifier(3, wrapped)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, wrapped, MakeIdentifier("foo@fudan.edu.cn"))
+	assert.Equal(t, IdentifierVersion(wrapped), 3)
+
+	_, err = WrapIdentifier(3, legacy)
+	assert.NotEqual(t, err, nil)
+	_, err = WrapIdentifier(1, legacy)
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisSchemeSentinel    = "redis+sentinel"
+	redisSchemeSentinelTLS = "rediss+sentinel"
+	redisSchemeCluster     = "redis+cluster"
+	redisSchemeClusterTLS  = "rediss+cluster"
+)
+
+// NewRedisClient 根据 REDIS_URL 创建 redis 客户端，支持以下格式：
+//
+//	host:port                                             单机，兼容旧配置
+//	redis://[[user]:password@]host:port[/db][?options]    单机，rediss:// 使用 TLS，options 见 redis.ParseURL
+//	redis+sentinel://[[user]:password@]host:port[,host:port...][/db]?master=name[&sentinel_username=...&sentinel_password=...]
+//	redis+cluster://[[user]:password@]host:port[?addr=host:port&addr=...]
+//
+// sentinel 和 cluster 同样可以使用 rediss+ 前缀启用 TLS
+func NewRedisClient(redisURL string) (redis.UniversalClient, error) {
+	scheme, _, found := strings.Cut(redisURL, "://")
+	if !found {
+		return redis.NewClient(&redis.Options{Addr: redisURL}), nil
+	}
+
+	switch scheme {
+	case "redis", "rediss":
+		options, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClient(options), nil
+	case redisSchemeCluster, redisSchemeClusterTLS:
+		options, err := redis.ParseClusterURL(strings.TrimSuffix(scheme, "+cluster") + redisURL[len(scheme):])
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClusterClient(options), nil
+	case redisSchemeSentinel, redisSchemeSentinelTLS:
+		options, err := parseSentinelURL(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewFailoverClient(options), nil
+	default:
+		return nil, fmt.Errorf("redis: invalid URL scheme: %s", scheme)
+	}
+}
+
+func parseSentinelURL(redisURL string) (*redis.FailoverOptions, error) {
+	// url.Parse doesn't accept a list of hosts, take them out first
+	scheme, rest, _ := strings.Cut(redisURL, "://")
+	authorityEnd := strings.IndexAny(rest, "/?")
+	if authorityEnd == -1 {
+		authorityEnd = len(rest)
+	}
+	userInfo, hosts := "", rest[:authorityEnd]
+	if at := strings.LastIndex(hosts, "@"); at != -1 {
+		userInfo, hosts = hosts[:at+1], hosts[at+1:]
+	}
+	u, err := url.Parse(scheme + "://" + userInfo + "sentinel" + rest[authorityEnd:])
+	if err != nil {
+		return nil, err
+	}
+
+	options := &redis.FailoverOptions{}
+	for _, addr := range strings.Split(hosts, ",") {
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, ":") {
+			addr += ":26379"
+		}
+		options.SentinelAddrs = append(options.SentinelAddrs, addr)
+	}
+	if len(options.SentinelAddrs) == 0 {
+		return nil, fmt.Errorf("redis: sentinel address not set")
+	}
+
+	if u.User != nil {
+		options.Username = u.User.Username()
+		options.Password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		options.DB, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid database number: %q", db)
+		}
+	}
+
+	query := u.Query()
+	options.MasterName = query.Get("master")
+	if options.MasterName == "" {
+		return nil, fmt.Errorf("redis: sentinel master name not set")
+	}
+	options.SentinelUsername = query.Get("sentinel_username")
+	options.SentinelPassword = query.Get("sentinel_password")
+
+	if u.Scheme == redisSchemeSentinelTLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return options, nil
+}
@@ -2,7 +2,11 @@ package auth
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"time"
@@ -18,35 +22,93 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"auth_next/config"
+	"auth_next/utils/i18n"
+	"auth_next/utils/metrics"
 )
 
 var verificationCodeCache *cache.Cache[string]
 
+// verificationAttemptCounter 验证码尝试次数计数器，需要原子递增，因此直接使用底层的缓存
+var verificationAttemptCounter attemptCounter
+
+type attemptCounter interface {
+	// Incr 将 key 加一并返回新值，key 不存在时从 0 开始，并设置过期时间
+	Incr(ctx context.Context, key string, expiration time.Duration) (int64, error)
+}
+
+type redisAttemptCounter struct {
+	client redis.UniversalClient
+}
+
+func (c *redisAttemptCounter) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	attempts, err := c.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if attempts == 1 {
+		err = c.client.Expire(ctx, key, expiration).Err()
+	}
+	return attempts, err
+}
+
+type goCacheAttemptCounter struct {
+	client *gocache.Cache
+}
+
+func (c *goCacheAttemptCounter) Incr(_ context.Context, key string, expiration time.Duration) (int64, error) {
+	// Add fails if the key exists, which is expected
+	_ = c.client.Add(key, int64(0), expiration)
+	return c.client.IncrementInt64(key, 1)
+}
+
+// InitVerificationCodeCache 初始化验证码缓存.
+// 设置了 REDIS_URL 时使用 redis，启动时检查连接，失败时仅在 dev 和 test 模式下使用 gocache
 func InitVerificationCodeCache() {
 	if config.Config.RedisUrl != "" {
-		verificationCodeCache = cache.New[string](
-			redisStore.NewRedis(
-				redis.NewClient(
-					&redis.Options{
-						Addr: config.Config.RedisUrl,
-					},
-				),
-			),
-		)
-		log.Info().Msg("verification code cache: redis")
-	} else {
-		verificationCodeCache = cache.New[string](
-			gocacheStore.NewGoCache(
-				gocache.New(
-					time.Duration(config.Config.VerificationCodeExpires)*time.Minute,
-					20*time.Minute),
-			),
-		)
-		log.Info().Msg("verification code cache: gocache")
+		client, err := NewRedisClient(config.Config.RedisUrl)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = client.Ping(ctx).Err()
+			cancel()
+		}
+		if err == nil {
+			verificationCodeCache = cache.New[string](redisStore.NewRedis(client))
+			verificationAttemptCounter = &redisAttemptCounter{client: client}
+			log.Info().Msg("verification code cache: redis")
+			return
+		}
+
+		if config.Config.Mode != "dev" && config.Config.Mode != "test" {
+			log.Fatal().Err(err).Msg("connect to redis failed")
+		}
+		if client != nil {
+			_ = client.Close()
+		}
+		log.Warn().Err(err).Msg("connect to redis failed, fall back to gocache in " + config.Config.Mode + " mode")
 	}
+
+	client := gocache.New(
+		time.Duration(config.Config.VerificationCodeExpires)*time.Minute,
+		20*time.Minute,
+	)
+	verificationCodeCache = cache.New[string](gocacheStore.NewGoCache(client))
+	verificationAttemptCounter = &goCacheAttemptCounter{client: client}
+	log.Info().Msg("verification code cache: gocache")
+}
+
+// verificationCodeKey 使用 VERIFICATION_KEY 计算规范化后邮箱的 HMAC，
+// 不使用 identifier，避免每次发送和检查验证码都计算 argon2
+func verificationCodeKey(email, scope string) string {
+	mac := hmac.New(sha256.New, config.VerificationKey)
+	mac.Write([]byte(CanonicalizeEmail(email)))
+	return fmt.Sprintf("%v-%v", scope, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func verificationAttemptsKey(email, scope string) string {
+	return verificationCodeKey(email, scope) + "-attempts"
 }
 
-// SetVerificationCode 缓存中设置验证码，key = {scope}-{many_hashes(email)}
+// SetVerificationCode 缓存中设置验证码，key = {scope}-{hmac(email)}，并重置尝试次数
 func SetVerificationCode(email, scope string) (string, error) {
 	codeInt, err := rand.Int(rand.Reader, big.NewInt(1000000))
 	if err != nil {
@@ -54,28 +116,71 @@ func SetVerificationCode(email, scope string) (string, error) {
 	}
 	code := fmt.Sprintf("%06d", codeInt.Uint64())
 
-	return code, verificationCodeCache.Set(
+	err = verificationCodeCache.Set(
 		context.Background(),
-		fmt.Sprintf("%v-%v", scope, MakeIdentifier(email)),
+		verificationCodeKey(email, scope),
 		code,
 		store.WithExpiration(time.Duration(config.Config.VerificationCodeExpires)*time.Minute),
 	)
+	if err != nil {
+		return "", err
+	}
+	return code, verificationCodeCache.Delete(context.Background(), verificationAttemptsKey(email, scope))
 }
 
-// CheckVerificationCode 检查验证码
-func CheckVerificationCode(email, scope, code string) bool {
-	storedCode, err := verificationCodeCache.Get(
-		context.Background(),
-		fmt.Sprintf("%v-%v", scope, MakeIdentifier(email)),
+// CheckVerificationCode 检查验证码.
+// 每次检查都会先增加尝试次数，失败达到 VERIFICATION_CODE_MAX_ATTEMPTS 次后验证码失效，需要重新获取
+func CheckVerificationCode(email, scope, code string) error {
+	ctx := context.Background()
+	storedCode, err := verificationCodeCache.Get(ctx, verificationCodeKey(email, scope))
+	if err != nil {
+		// not sent, expired or invalidated
+		metrics.VerificationCodeChecksTotal.WithLabelValues(scope, "missing").Inc()
+		return i18n.BadRequest(i18n.CodeVerificationCodeInvalid)
+	}
+
+	// count the attempt before comparing, so that concurrent guesses are all counted
+	attempts, err := verificationAttemptCounter.Incr(
+		ctx,
+		verificationAttemptsKey(email, scope),
+		time.Duration(config.Config.VerificationCodeExpires)*time.Minute,
 	)
-	return err == nil && storedCode == code
+	if err != nil {
+		return err
+	}
+	maxAttempts := int64(config.Config.VerificationCodeMaxAttempts)
+	if attempts > maxAttempts {
+		metrics.VerificationCodeChecksTotal.WithLabelValues(scope, "locked").Inc()
+		return i18n.BadRequest(i18n.CodeVerificationCodeLocked)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(storedCode), []byte(code)) == 1 {
+		metrics.VerificationCodeChecksTotal.WithLabelValues(scope, "success").Inc()
+		return nil
+	}
+
+	if attempts < maxAttempts {
+		log.Info().Str("scope", scope).Int64("attempts", attempts).Msg("verification code check failed")
+		metrics.VerificationCodeChecksTotal.WithLabelValues(scope, "failed").Inc()
+		return i18n.BadRequest(i18n.CodeVerificationCodeInvalid)
+	}
+
+	// too many failures, invalidate the code
+	log.Warn().Str("scope", scope).Int64("attempts", attempts).Msg("verification code invalidated after too many failed attempts")
+	metrics.VerificationCodeChecksTotal.WithLabelValues(scope, "invalidated").Inc()
+	err = verificationCodeCache.Delete(context.Background(), verificationCodeKey(email, scope))
+	if err != nil {
+		return err
+	}
+	return i18n.BadRequest(i18n.CodeVerificationCodeLocked)
 }
 
 func DeleteVerificationCode(email, scope string) error {
-	return verificationCodeCache.Delete(
-		context.Background(),
-		fmt.Sprintf("%v-%v", scope, MakeIdentifier(email)),
-	)
+	err := verificationCodeCache.Delete(context.Background(), verificationCodeKey(email, scope))
+	if err != nil {
+		return err
+	}
+	return verificationCodeCache.Delete(context.Background(), verificationAttemptsKey(email, scope))
 }
 
 func CheckApikey(key string) bool {
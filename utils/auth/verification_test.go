@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+	"auth_next/utils/i18n"
+)
+
+func errorCode(err error) string {
+	var e *i18n.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func TestCheckVerificationCode(t *testing.T) {
+	config.Config.RedisUrl = ""
+	config.Config.VerificationCodeExpires = 10
+	config.Config.VerificationCodeMaxAttempts = 3
+	InitVerificationCodeCache()
+
+	email := "foo@fudan.edu.cn"
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "register", "000000")), i18n.CodeVerificationCodeInvalid)
+
+	code, err := SetVerificationCode(email, "register")
+	assert.Equal(t, err, nil)
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "000001"
+	}
+
+	// wrong code, then correct code within the limit
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "register", wrongCode)), i18n.CodeVerificationCodeInvalid)
+	assert.Equal(t, CheckVerificationCode(email, "register", code), nil)
+
+	// codes of other scopes are not affected
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "reset", code)), i18n.CodeVerificationCodeInvalid)
+
+	// the code is invalidated after max attempts, even if the correct one is tried later
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "register", wrongCode)), i18n.CodeVerificationCodeLocked)
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "register", code)), i18n.CodeVerificationCodeInvalid)
+
+	// a new code resets the attempts
+	code, err = SetVerificationCode(email, "register")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, CheckVerificationCode(email, "register", code), nil)
+	assert.Equal(t, DeleteVerificationCode(email, "register"), nil)
+	assert.Equal(t, errorCode(CheckVerificationCode(email, "register", code)), i18n.CodeVerificationCodeInvalid)
+}
+
+func TestVerificationCodeKey(t *testing.T) {
+	config.VerificationKey = []byte("verification key")
+	defer func() {
+		config.VerificationKey = nil
+	}()
+
+	key := verificationCodeKey("Foo@fudan.edu.cn", "register")
+	assert.Equal(t, key, verificationCodeKey("foo@fudan.edu.cn", "register"))
+	assert.NotEqual(t, key, verificationCodeKey("foo@fudan.edu.cn", "reset"))
+
+	// a different key gives different cache keys
+	config.VerificationKey = []byte("another key")
+	assert.NotEqual(t, key, verificationCodeKey("foo@fudan.edu.cn", "register"))
+}
@@ -1,9 +1,6 @@
 package utils
 
 import (
-	"crypto/tls"
-	"net/smtp"
-
 	"github.com/jordan-wright/email"
 	"github.com/opentreehole/go-common"
 
@@ -11,31 +8,43 @@ import (
 )
 
 func SendEmail(subject, content string, receiver []string) error {
-	if config.Config.EmailServerNoReplyUrl.String() == "" {
-		return common.InternalServerError("failed to send email: email_server_no_reply_url not set")
+	return SendEmailWithHTML(subject, content, "", receiver)
+}
+
+// SendEmailWithHTML 发送带有 HTML 正文的邮件，html 为空时只发送纯文本
+func SendEmailWithHTML(subject, text, html string, receiver []string) error {
+	if DefaultMailer == nil {
+		return common.InternalServerError("failed to send email: mailer not initialized")
 	}
-	if config.Config.EmailDomain == "" {
-		return common.InternalServerError("failed to send email: email_domain not set")
+	from, err := emailFrom()
+	if err != nil {
+		return err
 	}
-	emailUsername := config.Config.EmailServerNoReplyUrl.User.Username() + "@" + config.Config.EmailDomain
-	emailPassword, _ := config.Config.EmailServerNoReplyUrl.User.Password()
 	e := &email.Email{
 		To:      receiver,
-		From:    emailUsername,
+		From:    from,
 		Subject: subject,
-		Text:    []byte(content),
+		Text:    []byte(text),
+	}
+	if html != "" {
+		e.HTML = []byte(html)
 	}
 
-	return e.SendWithTLS(
-		config.Config.EmailServerNoReplyUrl.Host,
-		smtp.PlainAuth(
-			"",
-			emailUsername,
-			emailPassword,
-			config.Config.EmailServerNoReplyUrl.Hostname(),
-		),
-		&tls.Config{
-			ServerName: config.Config.EmailServerNoReplyUrl.Hostname(),
-		},
-	)
+	return DefaultMailer.Send(e)
+}
+
+func emailFrom() (string, error) {
+	domain := config.Config.EmailDomain
+	if domain == "" {
+		// local transports don't need a real sender
+		if config.Config.EmailTransport != EmailTransportFile && config.Config.EmailTransport != EmailTransportMemory {
+			return "", common.InternalServerError("failed to send email: email_domain not set")
+		}
+		domain = "localhost"
+	}
+	username := config.Config.EmailServerNoReplyUrl.User.Username()
+	if username == "" {
+		username = "no-reply"
+	}
+	return username + "@" + domain, nil
 }
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"auth_next/config"
+)
+
+// emailBlocklistEntry 黑名单条目，支持三种格式:
+// 域名，如 example.com，匹配该域名及其子域名；
+// 通配符，如 *.example.*，使用 path.Match 匹配域名；
+// 正则表达式，以 re: 开头，如 re:^temp.*mail，匹配域名
+type emailBlocklistEntry struct {
+	raw     string
+	glob    bool
+	pattern *regexp.Regexp
+}
+
+// normalizeEmailBlocklistEntry 域名和通配符统一为小写；正则表达式保持原样，
+// 转为小写会改变 \D、\S、\W、\B 等的含义，匹配时使用 (?i) 忽略大小写
+func normalizeEmailBlocklistEntry(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if len(raw) >= 3 && strings.EqualFold(raw[:3], "re:") {
+		return "re:" + raw[3:]
+	}
+	return strings.ToLower(raw)
+}
+
+func parseEmailBlocklistEntry(raw string) (*emailBlocklistEntry, error) {
+	raw = normalizeEmailBlocklistEntry(raw)
+	if raw == "" {
+		return nil, errors.New("empty email blocklist entry")
+	}
+
+	entry := emailBlocklistEntry{raw: raw}
+	if strings.HasPrefix(raw, "re:") {
+		pattern, err := regexp.Compile("(?i)" + raw[3:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid email blocklist entry %s: %w", raw, err)
+		}
+		entry.pattern = pattern
+	} else if strings.ContainsAny(raw, "*?[") {
+		_, err := path.Match(raw, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid email blocklist entry %s: %w", raw, err)
+		}
+		entry.glob = true
+	} else if strings.ContainsAny(raw, "@/ ") {
+		return nil, fmt.Errorf("invalid email blocklist entry %s: should be a domain", raw)
+	}
+	return &entry, nil
+}
+
+func (entry *emailBlocklistEntry) Match(domain string) bool {
+	if entry.pattern != nil {
+		return entry.pattern.MatchString(domain)
+	}
+	if entry.glob {
+		ok, _ := path.Match(entry.raw, domain)
+		return ok
+	}
+	return domain == entry.raw || strings.HasSuffix(domain, "."+entry.raw)
+}
+
+// GlobalEmailBlocklist 保存在本实例的文件中，多个实例之间不会同步，
+// 多实例部署时需共享文件并在每个实例上重新加载
+var GlobalEmailBlocklist struct {
+	sync.RWMutex
+	Entries []*emailBlocklistEntry
+}
+
+// InitEmailBlocklist 从 EMAIL_BLOCKLIST_FILE 加载黑名单，文件不存在时黑名单为空
+func InitEmailBlocklist() error {
+	entries, err := loadEmailBlocklist(config.Config.EmailBlocklistFile)
+	if err != nil {
+		return err
+	}
+
+	GlobalEmailBlocklist.Lock()
+	GlobalEmailBlocklist.Entries = entries
+	GlobalEmailBlocklist.Unlock()
+
+	log.Info().Int("entries", len(entries)).Msg("email blocklist loaded")
+	return nil
+}
+
+func loadEmailBlocklist(filename string) ([]*emailBlocklistEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Warn().Str("filename", filename).Msg("email blocklist file not found")
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var entries []*emailBlocklistEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseEmailBlocklistEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func saveEmailBlocklist(filename string, entries []*emailBlocklistEntry) error {
+	var builder strings.Builder
+	builder.WriteString("# email blocklist, one domain, glob (*.example.*) or regexp (re:...) per line\n")
+	builder.WriteString("# managed by /api/email/blocklist, manual changes need POST /api/email/blocklist/_reload\n")
+	for _, entry := range entries {
+		builder.WriteString(entry.raw)
+		builder.WriteString("\n")
+	}
+
+	// write to a temporary file then rename, so that a crash won't leave a truncated blocklist
+	tempFilename := filename + ".tmp"
+	err := os.WriteFile(tempFilename, []byte(builder.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFilename, filename)
+}
+
+// IsEmailBlocked 检查邮箱域名是否在黑名单中，返回匹配的条目
+func IsEmailBlocked(email string) (bool, string) {
+	index := strings.LastIndexByte(email, '@')
+	if index < 0 {
+		return false, ""
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[index+1:]))
+
+	GlobalEmailBlocklist.RLock()
+	defer GlobalEmailBlocklist.RUnlock()
+	for _, entry := range GlobalEmailBlocklist.Entries {
+		if entry.Match(domain) {
+			return true, entry.raw
+		}
+	}
+	return false, ""
+}
+
+func ListEmailBlocklist() []string {
+	GlobalEmailBlocklist.RLock()
+	defer GlobalEmailBlocklist.RUnlock()
+	entries := make([]string, 0, len(GlobalEmailBlocklist.Entries))
+	for _, entry := range GlobalEmailBlocklist.Entries {
+		entries = append(entries, entry.raw)
+	}
+	return entries
+}
+
+// AddEmailBlocklistEntries 添加黑名单条目并保存到文件，已存在的条目会被忽略
+func AddEmailBlocklistEntries(rawEntries []string) error {
+	newEntries := make([]*emailBlocklistEntry, 0, len(rawEntries))
+	for _, raw := range rawEntries {
+		entry, err := parseEmailBlocklistEntry(raw)
+		if err != nil {
+			return err
+		}
+		newEntries = append(newEntries, entry)
+	}
+
+	GlobalEmailBlocklist.Lock()
+	defer GlobalEmailBlocklist.Unlock()
+
+	entries := make([]*emailBlocklistEntry, len(GlobalEmailBlocklist.Entries), len(GlobalEmailBlocklist.Entries)+len(newEntries))
+	copy(entries, GlobalEmailBlocklist.Entries)
+NEW_ENTRIES:
+	for _, newEntry := range newEntries {
+		for _, entry := range entries {
+			if entry.raw == newEntry.raw {
+				continue NEW_ENTRIES
+			}
+		}
+		entries = append(entries, newEntry)
+	}
+
+	err := saveEmailBlocklist(config.Config.EmailBlocklistFile, entries)
+	if err != nil {
+		return err
+	}
+	GlobalEmailBlocklist.Entries = entries
+	return nil
+}
+
+// RemoveEmailBlocklistEntries 删除黑名单条目并保存到文件
+func RemoveEmailBlocklistEntries(rawEntries []string) error {
+	removed := make([]string, len(rawEntries))
+	for i, raw := range rawEntries {
+		removed[i] = normalizeEmailBlocklistEntry(raw)
+	}
+
+	GlobalEmailBlocklist.Lock()
+	defer GlobalEmailBlocklist.Unlock()
+
+	entries := make([]*emailBlocklistEntry, 0, len(GlobalEmailBlocklist.Entries))
+	for _, entry := range GlobalEmailBlocklist.Entries {
+		if !InUnorderedSlice(removed, entry.raw) {
+			entries = append(entries, entry)
+		}
+	}
+
+	err := saveEmailBlocklist(config.Config.EmailBlocklistFile, entries)
+	if err != nil {
+		return err
+	}
+	GlobalEmailBlocklist.Entries = entries
+	return nil
+}
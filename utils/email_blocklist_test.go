@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestEmailBlocklist(t *testing.T) {
+	config.Config.EmailBlocklistFile = filepath.Join(t.TempDir(), "email_blocklist.txt")
+	err := InitEmailBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = AddEmailBlocklistEntries([]string{"mailinator.com", "*.tempmail.*", "re:^throwaway[0-9]+\\.net$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked, _ := IsEmailBlocked("abc@mailinator.com")
+	assert.Equal(t, blocked, true)
+	blocked, _ = IsEmailBlocked("abc@sub.Mailinator.com")
+	assert.Equal(t, blocked, true)
+	blocked, _ = IsEmailBlocked("abc@notmailinator.com")
+	assert.Equal(t, blocked, false)
+	blocked, _ = IsEmailBlocked("abc@x.tempmail.org")
+	assert.Equal(t, blocked, true)
+	blocked, _ = IsEmailBlocked("abc@throwaway12.net")
+	assert.Equal(t, blocked, true)
+	blocked, _ = IsEmailBlocked("abc@fudan.edu.cn")
+	assert.Equal(t, blocked, false)
+
+	assert.NotEqual(t, AddEmailBlocklistEntries([]string{"re:("}), nil)
+
+	// 正则表达式保持原样，\D 不能被转为 \d，匹配时忽略大小写
+	err = AddEmailBlocklistEntries([]string{"RE:^Spam\\D+\\.com$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, _ = IsEmailBlocked("abc@SPAM-box.com")
+	assert.Equal(t, blocked, true)
+	blocked, _ = IsEmailBlocked("abc@spam123.com")
+	assert.Equal(t, blocked, false)
+
+	removed := []string{" Mailinator.com ", "re:^Spam\\D+\\.com$"}
+
+	// reload from file
+	err = RemoveEmailBlocklistEntries(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, removed, []string{" Mailinator.com ", "re:^Spam\\D+\\.com$"})
+	err = InitEmailBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ListEmailBlocklist(), []string{"*.tempmail.*", "re:^throwaway[0-9]+\\.net$"})
+}
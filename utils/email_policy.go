@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/opentreehole/go-common"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"auth_next/config"
+	"auth_next/utils/i18n"
+)
+
+const (
+	EmailPolicyAllow = "allow"
+	EmailPolicyDeny  = "deny"
+)
+
+// EmailPolicyRule 邮箱规则，Domain 或 DomainPattern 与 LocalPart 同时匹配时生效
+type EmailPolicyRule struct {
+	// 规则名称，用于日志
+	Name string `json:"name" yaml:"name"`
+
+	// 完整域名，不区分大小写，与 DomainPattern 二选一
+	Domain string `json:"domain" yaml:"domain" validate:"required_without=DomainPattern"`
+
+	// 域名正则表达式，与 Domain 二选一
+	DomainPattern string `json:"domain_pattern" yaml:"domain_pattern"`
+
+	// 邮箱用户名（@ 之前的部分）正则表达式，为空时匹配所有
+	LocalPart string `json:"local_part" yaml:"local_part"`
+
+	// 匹配时的动作，allow 或 deny
+	Action string `json:"action" yaml:"action" validate:"oneof=allow deny"`
+
+	// 拒绝时返回给用户的错误信息，默认语言
+	Message string `json:"message" yaml:"message"`
+
+	// 其他语言的错误信息，key 为语言，如 en
+	Messages map[string]string `json:"messages" yaml:"messages"`
+
+	domainRegexp    *regexp.Regexp
+	localPartRegexp *regexp.Regexp
+}
+
+// EmailPolicy 邮箱规则文件 schema.
+// 检查时先检查例外地址，再由上到下匹配规则，第一条匹配的规则决定结果，没有匹配的规则时允许
+type EmailPolicy struct {
+	// 例外地址，不检查任何规则
+	Exceptions []string `json:"exceptions" yaml:"exceptions"`
+
+	// 规则列表
+	Rules []EmailPolicyRule `json:"rules" yaml:"rules" validate:"dive"`
+}
+
+var GlobalEmailPolicy struct {
+	sync.RWMutex
+	Policy *EmailPolicy
+}
+
+func (rule *EmailPolicyRule) compile() (err error) {
+	if rule.DomainPattern != "" {
+		rule.domainRegexp, err = regexp.Compile(rule.DomainPattern)
+		if err != nil {
+			return err
+		}
+	}
+	if rule.LocalPart != "" {
+		rule.localPartRegexp, err = regexp.Compile(rule.LocalPart)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rule *EmailPolicyRule) Match(localPart, domain string) bool {
+	if rule.Domain != "" && !strings.EqualFold(rule.Domain, domain) {
+		return false
+	}
+	if rule.domainRegexp != nil && !rule.domainRegexp.MatchString(domain) {
+		return false
+	}
+	if rule.localPartRegexp != nil && !rule.localPartRegexp.MatchString(localPart) {
+		return false
+	}
+	return true
+}
+
+func LoadEmailPolicy(filename string) (*EmailPolicy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy EmailPolicy
+	err = yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = common.ValidateStruct(&policy)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range policy.Rules {
+		err = policy.Rules[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d %s: %w", i, policy.Rules[i].Name, err)
+		}
+	}
+
+	for i := range policy.Exceptions {
+		policy.Exceptions[i] = strings.ToLower(strings.TrimSpace(policy.Exceptions[i]))
+	}
+
+	return &policy, nil
+}
+
+// InitEmailPolicy 加载邮箱规则文件，文件不存在时不检查任何规则.
+// 已废弃的 VALIDATE_EMAIL_WHITELIST 中的邮箱加入例外地址，与旧版本的行为一致
+func InitEmailPolicy() error {
+	policy, err := LoadEmailPolicy(config.Config.EmailPolicyFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		log.Warn().Str("filename", config.Config.EmailPolicyFile).Msg("email policy file not found, no rules applied")
+		policy = &EmailPolicy{}
+	}
+	for _, email := range config.Config.ValidateEmailWhitelist {
+		policy.Exceptions = append(policy.Exceptions, strings.ToLower(strings.TrimSpace(email)))
+	}
+
+	GlobalEmailPolicy.Lock()
+	GlobalEmailPolicy.Policy = policy
+	GlobalEmailPolicy.Unlock()
+
+	log.Info().Int("rules", len(policy.Rules)).Int("exceptions", len(policy.Exceptions)).Msg("email policy loaded")
+	return nil
+}
+
+// Check 检查邮箱是否符合规则，不符合时返回规则中的错误信息
+func (policy *EmailPolicy) Check(email string) error {
+	emailSplit := strings.Split(email, "@")
+	if len(emailSplit) != 2 {
+		return i18n.BadRequest(i18n.CodeEmailInvalid)
+	}
+
+	if InUnorderedSlice(policy.Exceptions, strings.ToLower(email)) {
+		return nil
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !rule.Match(emailSplit[0], emailSplit[1]) {
+			continue
+		}
+		if rule.Action == EmailPolicyDeny {
+			err := i18n.BadRequest(i18n.CodeEmailNotAllowed)
+			if rule.Message != "" {
+				err.Messages = map[string]string{i18n.DefaultLocale: rule.Message}
+				for locale, message := range rule.Messages {
+					err.Messages[locale] = message
+				}
+			}
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+// CheckEmailPolicy 使用当前加载的规则检查邮箱
+func CheckEmailPolicy(email string) error {
+	GlobalEmailPolicy.RLock()
+	policy := GlobalEmailPolicy.Policy
+	GlobalEmailPolicy.RUnlock()
+	if policy == nil {
+		return nil
+	}
+	return policy.Check(email)
+}
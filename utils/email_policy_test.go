@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestEmailPolicy(t *testing.T) {
+	policy, err := LoadEmailPolicy("../data/email_policy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Exceptions = append(policy.Exceptions, "21307130002@fudan.edu.cn")
+
+	assert.Equal(t, policy.Check("21307130001@m.fudan.edu.cn"), nil)
+	assert.NotEqual(t, policy.Check("21307130001@fudan.edu.cn"), nil)
+	assert.NotEqual(t, policy.Check("20307130001@m.fudan.edu.cn"), nil)
+	assert.Equal(t, policy.Check("20307130001@fudan.edu.cn"), nil)
+	assert.Equal(t, policy.Check("abcd@fudan.edu.cn"), nil)
+	assert.Equal(t, policy.Check("21307130002@FUDAN.edu.cn"), nil)
+	assert.Equal(t, policy.Check("21307130001@qq.com"), nil)
+}
+
+func TestEmailPolicyLegacyWhitelist(t *testing.T) {
+	config.Config.EmailPolicyFile = "../data/email_policy.yaml"
+	config.Config.ValidateEmailWhitelist = []string{"21307130003@Fudan.edu.cn"}
+	defer func() {
+		config.Config.ValidateEmailWhitelist = nil
+	}()
+	err := InitEmailPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, CheckEmailPolicy("21307130003@fudan.edu.cn"), nil)
+	assert.NotEqual(t, CheckEmailPolicy("21307130001@fudan.edu.cn"), nil)
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	textTemplate "text/template"
+
+	"github.com/rs/zerolog/log"
+
+	"auth_next/config"
+	"auth_next/utils/i18n"
+)
+
+const (
+	EmailPurposeRegister = "register"
+	EmailPurposeReset    = "reset"
+	EmailPurposeElevate  = "elevate"
+)
+
+// RequiredEmailPurposes 启动时必须存在默认语言模板的邮件用途
+var RequiredEmailPurposes = []string{EmailPurposeRegister, EmailPurposeReset, EmailPurposeElevate}
+
+// EmailTemplateData 邮件模板中可以使用的变量
+type EmailTemplateData struct {
+	SiteName string
+	Email    string
+	Code     string
+	Expires  int // 验证码有效期，单位分钟
+}
+
+// EmailTemplate 一种用途、一种语言的邮件模板.
+// 模板目录中的文件命名为 {purpose}.{locale}.{subject|txt|html}.tmpl，
+// 其中 subject 和 txt 必须存在，html 可选
+type EmailTemplate struct {
+	Subject *textTemplate.Template
+	Text    *textTemplate.Template
+	HTML    *htmlTemplate.Template
+}
+
+type RenderedEmail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+var GlobalEmailTemplates struct {
+	sync.RWMutex
+	Templates map[string]*EmailTemplate // key: {purpose}.{locale}
+}
+
+func emailTemplateKey(purpose, locale string) string {
+	return purpose + "." + locale
+}
+
+func InitEmailTemplates() error {
+	dirname := config.Config.EmailTemplateDir
+	dir, err := os.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+
+	newTemplates := make(map[string]*EmailTemplate)
+	for _, file := range dir {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".tmpl") {
+			continue
+		}
+
+		// {purpose}.{locale}.{part}.tmpl
+		nameSplit := strings.Split(strings.TrimSuffix(file.Name(), ".tmpl"), ".")
+		if len(nameSplit) != 3 {
+			log.Warn().Str("filename", file.Name()).Msg("invalid email template filename")
+			continue
+		}
+		purpose, locale, part := nameSplit[0], nameSplit[1], nameSplit[2]
+
+		data, err := os.ReadFile(filepath.Join(dirname, file.Name()))
+		if err != nil {
+			return fmt.Errorf("read email template %s failed: %w", file.Name(), err)
+		}
+
+		key := emailTemplateKey(purpose, locale)
+		emailTemplate, ok := newTemplates[key]
+		if !ok {
+			emailTemplate = new(EmailTemplate)
+			newTemplates[key] = emailTemplate
+		}
+
+		switch part {
+		case "subject":
+			emailTemplate.Subject, err = textTemplate.New(file.Name()).Option("missingkey=error").Parse(string(data))
+		case "txt":
+			emailTemplate.Text, err = textTemplate.New(file.Name()).Option("missingkey=error").Parse(string(data))
+		case "html":
+			emailTemplate.HTML, err = htmlTemplate.New(file.Name()).Option("missingkey=error").Parse(string(data))
+		default:
+			log.Warn().Str("filename", file.Name()).Msg("unknown email template part")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("parse email template %s failed: %w", file.Name(), err)
+		}
+	}
+
+	// validate templates by rendering with sample data
+	sample := EmailTemplateData{
+		SiteName: config.Config.SiteName,
+		Email:    "example@example.com",
+		Code:     "123456",
+		Expires:  config.Config.VerificationCodeExpires,
+	}
+	for key, emailTemplate := range newTemplates {
+		if emailTemplate.Subject == nil || emailTemplate.Text == nil {
+			return fmt.Errorf("email template %s: subject and txt are required", key)
+		}
+		_, err = emailTemplate.Render(sample)
+		if err != nil {
+			return fmt.Errorf("email template %s: %w", key, err)
+		}
+	}
+
+	for _, purpose := range RequiredEmailPurposes {
+		if _, ok := newTemplates[emailTemplateKey(purpose, i18n.DefaultLocale)]; !ok {
+			return fmt.Errorf("email template %s not found", emailTemplateKey(purpose, i18n.DefaultLocale))
+		}
+	}
+
+	GlobalEmailTemplates.Lock()
+	GlobalEmailTemplates.Templates = newTemplates
+	GlobalEmailTemplates.Unlock()
+
+	log.Info().Int("count", len(newTemplates)).Msg("email templates loaded")
+	return nil
+}
+
+func (t *EmailTemplate) Render(data any) (*RenderedEmail, error) {
+	var subject, text, html strings.Builder
+
+	err := t.Subject.Execute(&subject, data)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Text.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+	if t.HTML != nil {
+		err = t.HTML.Execute(&html, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RenderedEmail{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// RenderEmail 使用指定用途和语言的模板渲染邮件，找不到对应语言时使用默认语言
+func RenderEmail(purpose, locale string, data any) (*RenderedEmail, error) {
+	GlobalEmailTemplates.RLock()
+	emailTemplate, ok := GlobalEmailTemplates.Templates[emailTemplateKey(purpose, locale)]
+	if !ok {
+		emailTemplate, ok = GlobalEmailTemplates.Templates[emailTemplateKey(purpose, i18n.DefaultLocale)]
+	}
+	GlobalEmailTemplates.RUnlock()
+	if !ok {
+		return nil, errors.New("email template not found: " + purpose)
+	}
+
+	return emailTemplate.Render(data)
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestRenderEmail(t *testing.T) {
+	config.Config.EmailTemplateDir = "../data/email_templates"
+	config.Config.SiteName = "Open Tree Hole"
+	err := InitEmailTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := EmailTemplateData{SiteName: "Open Tree Hole", Code: "012345", Expires: 10}
+	rendered, err := RenderEmail(EmailPurposeRegister, "en", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rendered.Subject, "Open Tree Hole Registration Verification")
+	assert.Equal(t, strings.Contains(rendered.Text, "012345"), true)
+	assert.Equal(t, strings.Contains(rendered.HTML, "012345"), true)
+
+	// fallback to default locale
+	rendered, err = RenderEmail(EmailPurposeReset, "fr", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rendered.Subject, "Open Tree Hole 重置密码")
+}
@@ -0,0 +1,172 @@
+package i18n
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+	"gorm.io/gorm"
+)
+
+// Error 可本地化的错误，返回时由 ErrorHandler 根据请求语言选择文本
+type Error struct {
+	// HTTP 状态码
+	Status int
+
+	// 错误码，见 catalog
+	Code string
+
+	// 文本格式化参数
+	Args []any
+
+	// 覆盖 catalog 中的文本，key 为语言，用于规则文件等外部配置的错误信息
+	Messages map[string]string
+}
+
+func (e *Error) Error() string {
+	return e.Localize(DefaultLocale)
+}
+
+// Localize 返回指定语言的错误文本
+func (e *Error) Localize(locale string) string {
+	if message, ok := e.Messages[locale]; ok {
+		return message
+	}
+	if message, ok := e.Messages[DefaultLocale]; ok {
+		return message
+	}
+	return T(locale, e.Code, e.Args...)
+}
+
+func NewError(status int, code string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Args: args}
+}
+
+func BadRequest(code string, args ...any) *Error {
+	return NewError(400, code, args...)
+}
+
+func Unauthorized(code string, args ...any) *Error {
+	return NewError(401, code, args...)
+}
+
+func Forbidden(code string, args ...any) *Error {
+	return NewError(403, code, args...)
+}
+
+func NotFound(code string, args ...any) *Error {
+	return NewError(404, code, args...)
+}
+
+func Conflict(code string, args ...any) *Error {
+	return NewError(409, code, args...)
+}
+
+func TooManyRequests(code string, args ...any) *Error {
+	return NewError(429, code, args...)
+}
+
+func InternalServerError(code string, args ...any) *Error {
+	return NewError(500, code, args...)
+}
+
+// HttpError 错误响应，在 common.HttpError 的基础上增加稳定的错误码，客户端可以据此自行本地化
+type HttpError struct {
+	Code      int                 `json:"code,omitempty"`
+	ErrorCode string              `json:"error_code,omitempty"`
+	Message   string              `json:"message,omitempty"`
+	Detail    *common.ErrorDetail `json:"detail,omitempty"`
+}
+
+// defaultMessages common 中各状态码的默认错误信息，这些错误没有具体信息，按照状态码本地化
+var defaultMessages = map[string]bool{
+	"Bad Request":           true,
+	"Invalid JWT Token":     true,
+	"Unauthorized":          true,
+	"Forbidden":             true,
+	"Not Found":             true,
+	"Internal Server Error": true,
+}
+
+func statusCode(status int) string {
+	switch status {
+	case 400:
+		return CodeBadRequest
+	case 401:
+		return CodeUnauthorized
+	case 403:
+		return CodeForbidden
+	case 404:
+		return CodeNotFound
+	default:
+		return CodeInternalServerError
+	}
+}
+
+// ErrorHandler 替代 common.ErrorHandler，按照请求的语言返回错误信息和错误码
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	locale := GetLocale(c)
+	httpError := HttpError{
+		Code:    500,
+		Message: err.Error(),
+	}
+
+	var localizedError *Error
+	var commonError *common.HttpError
+	var fiberError *fiber.Error
+	var errorDetail *common.ErrorDetail
+	if errors.As(err, &localizedError) {
+		httpError.Code = localizedError.Status
+		httpError.ErrorCode = localizedError.Code
+		httpError.Message = localizedError.Localize(locale)
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		httpError.Code = 404
+		httpError.ErrorCode = CodeNotFound
+		httpError.Message = T(locale, CodeNotFound)
+	} else if errors.As(err, &commonError) {
+		httpError.Code = commonError.Code
+		httpError.Message = commonError.Message
+		httpError.Detail = commonError.Detail
+	} else if errors.As(err, &fiberError) {
+		httpError.Code = fiberError.Code
+	} else if errors.As(err, &errorDetail) {
+		httpError.Code = 400
+		httpError.ErrorCode = CodeValidationFailed
+		httpError.Detail = errorDetail
+	} else if multiError, ok := err.(fiber.MultiError); ok {
+		httpError.Code = 400
+		httpError.ErrorCode = CodeValidationFailed
+		httpError.Message = ""
+		for _, err = range multiError {
+			httpError.Message += err.Error() + "\n"
+		}
+	}
+
+	// parse status code
+	// when status code is 400xxx to 599xxx, use leading 3 numbers instead
+	// else use 500
+	status := httpError.Code
+	statusString := strconv.Itoa(status)
+	if len(statusString) > 3 {
+		newStatus, err := strconv.Atoi(statusString[:3])
+		if err == nil && newStatus >= 400 && newStatus < 600 {
+			status = newStatus
+		} else {
+			status = 500
+		}
+	}
+
+	if httpError.ErrorCode == "" {
+		httpError.ErrorCode = statusCode(status)
+		if defaultMessages[httpError.Message] {
+			httpError.Message = T(locale, httpError.ErrorCode)
+		}
+	}
+
+	return c.Status(status).JSON(&httpError)
+}
@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/opentreehole/go-common"
+)
+
+func TestCatalog(t *testing.T) {
+	for code, messages := range catalog {
+		for _, locale := range SupportedLocales {
+			if messages[locale] == "" {
+				t.Errorf("code %s missing locale %s", code, locale)
+			}
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/localized", func(c *fiber.Ctx) error {
+		return BadRequest(CodeVerificationCodeInvalid)
+	})
+	app.Get("/args", func(c *fiber.Ctx) error {
+		return BadRequest(CodeQuestionNotFound, 3)
+	})
+	app.Get("/override", func(c *fiber.Ctx) error {
+		err := BadRequest(CodeEmailNotAllowed)
+		err.Messages = map[string]string{LocaleZh: "不允许"}
+		return err
+	})
+	app.Get("/common", func(c *fiber.Ctx) error {
+		return common.Forbidden()
+	})
+	app.Get("/common_message", func(c *fiber.Ctx) error {
+		return common.BadRequest("custom")
+	})
+
+	tests := []struct {
+		path           string
+		acceptLanguage string
+		status         int
+		errorCode      string
+		message        string
+	}{
+		{"/localized", "", 400, CodeVerificationCodeInvalid, "验证码错误，请多次尝试或者重新获取验证码"},
+		{"/localized", "en-US,en;q=0.9", 400, CodeVerificationCodeInvalid, "Invalid verification code, please try again or request a new one"},
+		{"/localized?lang=en", "zh-CN", 400, CodeVerificationCodeInvalid, "Invalid verification code, please try again or request a new one"},
+		{"/args", "en", 400, CodeQuestionNotFound, "Question id 3 not found"},
+		{"/override", "en", 400, CodeEmailNotAllowed, "不允许"},
+		{"/common", "en", 403, CodeForbidden, "Forbidden"},
+		{"/common", "zh", 403, CodeForbidden, "没有权限"},
+		{"/common_message", "en", 400, CodeBadRequest, "custom"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set(fiber.HeaderAcceptLanguage, tt.acceptLanguage)
+		resp, err := app.Test(req)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, resp.StatusCode, tt.status)
+
+		var body HttpError
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, body.ErrorCode, tt.errorCode)
+		assert.Equal(t, body.Message, tt.message)
+	}
+}
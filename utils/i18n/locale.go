@@ -0,0 +1,45 @@
+package i18n
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/language"
+)
+
+const (
+	LocaleZh = "zh"
+	LocaleEn = "en"
+
+	DefaultLocale = LocaleZh
+)
+
+// SupportedLocales 支持的语言，第一个为默认语言
+var SupportedLocales = []string{LocaleZh, LocaleEn}
+
+var matcher = language.NewMatcher([]language.Tag{
+	language.Chinese,
+	language.English,
+})
+
+// MatchLocale 从 Accept-Language 格式的字符串中选择最合适的语言
+func MatchLocale(acceptLanguage string) string {
+	if acceptLanguage == "" {
+		return DefaultLocale
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return SupportedLocales[index]
+}
+
+// GetLocale 获取请求的语言，query 参数 lang 优先，其次是 Accept-Language 请求头
+func GetLocale(c *fiber.Ctx) string {
+	if lang := c.Query("lang"); lang != "" {
+		return MatchLocale(lang)
+	}
+	return MatchLocale(c.Get(fiber.HeaderAcceptLanguage))
+}
@@ -0,0 +1,15 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestMatchLocale(t *testing.T) {
+	assert.Equal(t, MatchLocale(""), LocaleZh)
+	assert.Equal(t, MatchLocale("en-US,en;q=0.9"), LocaleEn)
+	assert.Equal(t, MatchLocale("zh-CN,zh;q=0.9,en;q=0.8"), LocaleZh)
+	assert.Equal(t, MatchLocale("fr-FR,en;q=0.5"), LocaleEn)
+	assert.Equal(t, MatchLocale("invalid;;"), LocaleZh)
+}
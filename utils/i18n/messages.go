@@ -0,0 +1,352 @@
+package i18n
+
+import "fmt"
+
+// 错误码，作为 error_code 返回给客户端，一经发布不可修改
+const (
+	// 通用错误，用于没有指定错误码的错误
+	CodeBadRequest          = "bad_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeInternalServerError = "internal_server_error"
+	CodeValidationFailed    = "validation_failed"
+	CodeInvalidRequest      = "invalid_request"
+	CodeTooManyRequests     = "too_many_requests"
+
+	// 权限
+	CodeAdminRequired       = "admin_required"
+	CodeShamirAdminRequired = "shamir_admin_required"
+
+	// 账户
+	CodeUserNotFound            = "user_not_found"
+	CodeUserNotRegistered       = "user_not_registered"
+	CodeUserRegistered          = "user_registered"
+	CodeUserRegisteredUseReset  = "user_registered_use_reset"
+	CodeRegisterAfterDeleted    = "register_after_deleted"
+	CodeAccountDeleted          = "account_deleted"
+	CodeAccountDeletedNoChange  = "account_deleted_password_change"
+	CodePasswordIncorrect       = "password_incorrect"
+	CodeCannotDeleteSelf        = "cannot_delete_self"
+	CodeRefreshTokenInvalid     = "refresh_token_invalid"
+	CodeVerificationCodeInvalid = "verification_code_invalid"
+	CodeVerificationCodeLocked  = "verification_code_locked"
+	CodeQuickLoginDisabled      = "quick_login_disabled"
+	CodeElevationRequired       = "elevation_required"
+	CodeTOTPNotEnabled          = "totp_not_enabled"
+	CodeEmailNotMatch           = "email_not_match"
+
+	// 邮箱
+	CodeEmailInvalid             = "email_invalid"
+	CodeEmailNotAllowed          = "email_not_allowed"
+	CodeEmailDomainBlocked       = "email_domain_blocked"
+	CodeEmailBlocklistEntryError = "email_blocklist_entry_invalid"
+	CodeEmailLookupCSVInvalid    = "email_lookup_csv_invalid"
+	CodeEmailRegistered          = "email_registered"
+	CodeEmailNotRegistered       = "email_not_registered"
+	CodeVerificationEmailSent    = "verification_email_sent"
+
+	// 问卷
+	CodeQuestionVersionNotFound  = "question_version_not_found"
+	CodeQuestionConfigInvalid    = "question_config_invalid"
+	CodeQuestionNotFound         = "question_not_found"
+	CodeQuestionNumberMismatch   = "question_number_mismatch"
+	CodeRequiredQuestionMismatch = "required_question_number_mismatch"
+
+	// shamir
+	CodeShamirInfoNotFound        = "shamir_info_not_found"
+	CodeShamirUpdating            = "shamir_updating"
+	CodeShamirSessionNotFound     = "shamir_session_not_found"
+	CodeShamirAlreadyUploaded     = "shamir_already_uploaded"
+	CodeShamirPublicKeyInvalid    = "shamir_public_key_invalid"
+	CodeShamirPublicKeyCount      = "shamir_public_key_count"
+	CodeShamirSharesNotEnough     = "shamir_shares_not_enough"
+	CodeShamirPublicKeysNotEnough = "shamir_public_keys_not_enough"
+	CodeShamirDecryptFailed       = "shamir_decrypt_failed"
+	CodeShamirDecryptRetry        = "shamir_decrypt_retry"
+	CodeShamirShareInvalid        = "shamir_share_invalid"
+	CodeShamirShareMismatch       = "shamir_share_mismatch"
+	CodeShamirChunkOutOfOrder     = "shamir_chunk_out_of_order"
+	CodeShamirChunkUserOrder      = "shamir_chunk_user_order"
+	CodeShamirRequestExists       = "shamir_request_exists"
+	CodeShamirRequesterOnly       = "shamir_requester_only"
+	CodeShamirAlreadyDecrypted    = "shamir_already_decrypted"
+	CodeShamirRequesterApproval   = "shamir_requester_approval"
+	CodeShamirAlreadyApproved     = "shamir_already_approved"
+	CodeShamirUpdateStarted       = "shamir_update_started"
+	CodeShamirUpdateResumed       = "shamir_update_resumed"
+
+	// webhook
+	CodeWebhookDeliveryInProgress = "webhook_delivery_in_progress"
+
+	// 其他
+	CodeReloadFailed = "reload_failed"
+)
+
+// catalog 错误码对应的各语言文本，文本为 fmt 格式字符串，每个错误码必须包含默认语言
+var catalog = map[string]map[string]string{
+	CodeBadRequest: {
+		LocaleZh: "请求错误",
+		LocaleEn: "Bad Request",
+	},
+	CodeUnauthorized: {
+		LocaleZh: "未登录或登录已过期",
+		LocaleEn: "Unauthorized",
+	},
+	CodeForbidden: {
+		LocaleZh: "没有权限",
+		LocaleEn: "Forbidden",
+	},
+	CodeNotFound: {
+		LocaleZh: "资源不存在",
+		LocaleEn: "Not Found",
+	},
+	CodeInternalServerError: {
+		LocaleZh: "服务器内部错误",
+		LocaleEn: "Internal Server Error",
+	},
+	CodeTooManyRequests: {
+		LocaleZh: "请求过于频繁，请稍后再试",
+		LocaleEn: "Too many requests, please try again later",
+	},
+	CodeValidationFailed: {
+		LocaleZh: "请求参数错误",
+		LocaleEn: "Validation Error",
+	},
+	CodeInvalidRequest: {
+		LocaleZh: "无效请求",
+		LocaleEn: "Invalid request",
+	},
+	CodeAdminRequired: {
+		LocaleZh: "仅管理员可以进行此操作",
+		LocaleEn: "Only admins can perform this operation",
+	},
+	CodeShamirAdminRequired: {
+		LocaleZh: "仅 Shamir 管理员可以进行此操作",
+		LocaleEn: "Only shamir admins can perform this operation",
+	},
+	CodeUserNotFound: {
+		LocaleZh: "用户不存在",
+		LocaleEn: "User not found",
+	},
+	CodeUserNotRegistered: {
+		LocaleZh: "该用户未注册",
+		LocaleEn: "This user is not registered",
+	},
+	CodeUserRegistered: {
+		LocaleZh: "用户已注册",
+		LocaleEn: "User already registered",
+	},
+	CodeUserRegisteredUseReset: {
+		LocaleZh: "该用户已注册，如果忘记密码，请使用忘记密码功能找回",
+		LocaleEn: "This user is already registered. If you forgot your password, please reset it",
+	},
+	CodeRegisterAfterDeleted: {
+		LocaleZh: "注销账号后禁止注册",
+		LocaleEn: "Registration is not allowed after the account has been deleted",
+	},
+	CodeAccountDeleted: {
+		LocaleZh: "账户已注销",
+		LocaleEn: "The account has been deleted",
+	},
+	CodeAccountDeletedNoChange: {
+		LocaleZh: "账户已注销，禁止修改密码",
+		LocaleEn: "The account has been deleted, the password can't be changed",
+	},
+	CodePasswordIncorrect: {
+		LocaleZh: "密码错误",
+		LocaleEn: "Incorrect password",
+	},
+	CodeCannotDeleteSelf: {
+		LocaleZh: "不能注销自己",
+		LocaleEn: "You can't delete your own account",
+	},
+	CodeRefreshTokenInvalid: {
+		LocaleZh: "refresh token 无效",
+		LocaleEn: "Invalid refresh token",
+	},
+	CodeVerificationCodeInvalid: {
+		LocaleZh: "验证码错误，请多次尝试或者重新获取验证码",
+		LocaleEn: "Invalid verification code, please try again or request a new one",
+	},
+	CodeVerificationCodeLocked: {
+		LocaleZh: "验证码错误次数过多，已失效，请重新获取验证码",
+		LocaleEn: "Too many failed attempts, the verification code is no longer valid, please request a new one",
+	},
+	CodeQuickLoginDisabled: {
+		LocaleZh: "快捷登录/注册已停用，请返回并使用旦挞账户直接登录。注册账户请前往 https://auth.fduhole.com",
+		LocaleEn: "Quick login and registration have been disabled, please log in with your account. To register, visit https://auth.fduhole.com",
+	},
+	CodeElevationRequired: {
+		LocaleZh: "此操作需要重新验证身份，请验证密码或邮箱后重试",
+		LocaleEn: "Please confirm your identity with your password or email again",
+	},
+	CodeTOTPNotEnabled: {
+		LocaleZh: "该账户未启用两步验证",
+		LocaleEn: "Two-factor authentication is not enabled for this account",
+	},
+	CodeEmailNotMatch: {
+		LocaleZh: "邮箱与当前账户不匹配",
+		LocaleEn: "The email doesn't match the current account",
+	},
+	CodeEmailInvalid: {
+		LocaleZh: "邮箱格式错误",
+		LocaleEn: "Invalid email",
+	},
+	CodeEmailNotAllowed: {
+		LocaleZh: "该邮箱不允许注册",
+		LocaleEn: "This email is not allowed",
+	},
+	CodeEmailDomainBlocked: {
+		LocaleZh: "该邮箱域名不允许注册，请使用学校邮箱",
+		LocaleEn: "This email domain is not allowed, please use your school email",
+	},
+	CodeEmailBlocklistEntryError: {
+		LocaleZh: "屏蔽规则无效：%v",
+		LocaleEn: "Invalid blocklist entry: %v",
+	},
+	CodeEmailLookupCSVInvalid: {
+		LocaleZh: "CSV 格式错误：%v",
+		LocaleEn: "Invalid CSV: %v",
+	},
+	CodeEmailRegistered: {
+		LocaleZh: "该邮箱已注册",
+		LocaleEn: "This email is registered",
+	},
+	CodeEmailNotRegistered: {
+		LocaleZh: "该邮箱未注册",
+		LocaleEn: "This email is not registered",
+	},
+	CodeVerificationEmailSent: {
+		LocaleZh: "验证邮件已发送，请查收\n如未收到，请检查邮件地址是否正确，检查垃圾箱，或重试",
+		LocaleEn: "The verification email has been sent\nIf you haven't received it, please check the address and your spam folder, or try again",
+	},
+	CodeQuestionVersionNotFound: {
+		LocaleZh: "问卷版本不存在",
+		LocaleEn: "Question version not found",
+	},
+	CodeQuestionConfigInvalid: {
+		LocaleZh: "问卷配置错误：%v",
+		LocaleEn: "Invalid question config: %v",
+	},
+	CodeQuestionNotFound: {
+		LocaleZh: "问题 %d 不存在",
+		LocaleEn: "Question id %d not found",
+	},
+	CodeQuestionNumberMismatch: {
+		LocaleZh: "问题数量 %d 不匹配",
+		LocaleEn: "Question number %d not match",
+	},
+	CodeRequiredQuestionMismatch: {
+		LocaleZh: "必答问题数量 %d 不匹配",
+		LocaleEn: "Required question number %d not match",
+	},
+	CodeShamirInfoNotFound: {
+		LocaleZh: "获取Shamir信息失败",
+		LocaleEn: "Failed to get shamir information",
+	},
+	CodeShamirUpdating: {
+		LocaleZh: "正在重新加解密，请不要重复操作",
+		LocaleEn: "Shamir update in progress, please try again later",
+	},
+	CodeShamirSessionNotFound: {
+		LocaleZh: "会话不存在或已结束",
+		LocaleEn: "The session doesn't exist or has finished",
+	},
+	CodeShamirAlreadyUploaded: {
+		LocaleZh: "您已经上传过，请不要重复上传",
+		LocaleEn: "You have already uploaded",
+	},
+	CodeShamirPublicKeyInvalid: {
+		LocaleZh: "公钥无效：%v",
+		LocaleEn: "Invalid public key: %v",
+	},
+	CodeShamirPublicKeyCount: {
+		LocaleZh: "需要上传 %d 个公钥",
+		LocaleEn: "%d public keys are required",
+	},
+	CodeShamirSharesNotEnough: {
+		LocaleZh: "坐标点数量不够，无法解密",
+		LocaleEn: "Not enough shares to decrypt",
+	},
+	CodeShamirPublicKeysNotEnough: {
+		LocaleZh: "公钥数量不够，无法重新加密",
+		LocaleEn: "Not enough public keys to re-encrypt",
+	},
+	CodeShamirDecryptFailed: {
+		LocaleZh: "无法解密",
+		LocaleEn: "Unable to decrypt",
+	},
+	CodeShamirDecryptRetry: {
+		LocaleZh: "解密失败，请重新输入坐标点",
+		LocaleEn: "Decryption failed, please upload the shares again",
+	},
+	CodeShamirShareMismatch: {
+		LocaleZh: "%s 上传的 %d 个坐标点不属于对应的用户或当前 key set，用户 ID：%v",
+		LocaleEn: "%s uploaded %d shares that don't belong to the user or the current key set, user IDs: %v",
+	},
+	CodeShamirRequestExists: {
+		LocaleZh: "用户 %d 已有进行中的解密申请 %s",
+		LocaleEn: "User %d already has a pending decrypt request %s",
+	},
+	CodeShamirRequesterOnly: {
+		LocaleZh: "只有申请人可以获取解密结果",
+		LocaleEn: "Only the requester can get the decrypted email",
+	},
+	CodeShamirAlreadyDecrypted: {
+		LocaleZh: "解密结果已被获取，每个申请只能获取一次，请重新申请",
+		LocaleEn: "The decrypted email has been retrieved, each request can be retrieved only once",
+	},
+	CodeShamirRequesterApproval: {
+		LocaleZh: "申请人不能批准自己的解密申请",
+		LocaleEn: "The requester can't approve their own decrypt request",
+	},
+	CodeShamirAlreadyApproved: {
+		LocaleZh: "你已经批准过该申请，每个管理员只能批准一次",
+		LocaleEn: "You already approved this request, each admin can approve only once",
+	},
+	CodeShamirUpdateStarted: {
+		LocaleZh: "触发成功，正在尝试更新shamir信息，请访问/shamir/status获取更多信息",
+		LocaleEn: "Shamir update started, see /shamir/status for progress",
+	},
+	CodeShamirUpdateResumed: {
+		LocaleZh: "正在从中断处继续更新shamir信息，请访问/shamir/status获取更多信息",
+		LocaleEn: "Resuming the stalled shamir update from the checkpoint, see /shamir/status for progress",
+	},
+	CodeShamirChunkOutOfOrder: {
+		LocaleZh: "请按顺序上传，下一块为第 %d 块",
+		LocaleEn: "Chunks must be uploaded in order, the next chunk is %d",
+	},
+	CodeShamirChunkUserOrder: {
+		LocaleZh: "请按用户 ID 递增分块上传，本块的用户 ID 须大于 %d",
+		LocaleEn: "Chunks must be sorted by user ID, user IDs of this chunk must be larger than %d",
+	},
+	CodeShamirShareInvalid: {
+		LocaleZh: "%s 上传的 %d 个坐标点与承诺不一致，用户 ID：%v",
+		LocaleEn: "%s uploaded %d shares inconsistent with the commitments, user IDs: %v",
+	},
+	CodeWebhookDeliveryInProgress: {
+		LocaleZh: "该投递正在发送中，请稍后再试",
+		LocaleEn: "The delivery is being sent, please try again later",
+	},
+	CodeReloadFailed: {
+		LocaleZh: "重新加载失败：%v",
+		LocaleEn: "Reload failed: %v",
+	},
+}
+
+// T 返回错误码在指定语言下的文本，语言不存在时使用默认语言，错误码不存在时返回错误码本身
+func T(locale, code string, args ...any) string {
+	messages, ok := catalog[code]
+	if !ok {
+		return code
+	}
+	message, ok := messages[locale]
+	if !ok {
+		message = messages[DefaultLocale]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/rs/zerolog/log"
+	"github.com/thanhpk/randstr"
+
+	"auth_next/config"
+)
+
+const (
+	EmailTransportSMTPS    = "smtps"
+	EmailTransportStartTLS = "starttls"
+	EmailTransportSMTP     = "smtp"
+	EmailTransportFile     = "file"
+	EmailTransportMemory   = "memory"
+)
+
+// Mailer 邮件发送接口，由 EMAIL_TRANSPORT 选择实现
+type Mailer interface {
+	Send(e *email.Email) error
+}
+
+var DefaultMailer Mailer
+
+func InitMailer() {
+	switch config.Config.EmailTransport {
+	case EmailTransportSMTPS, EmailTransportStartTLS, EmailTransportSMTP:
+		DefaultMailer = NewSMTPMailer(config.Config.EmailTransport)
+	case EmailTransportFile:
+		DefaultMailer = &FileMailer{Dir: config.Config.EmailFileDir}
+	case EmailTransportMemory:
+		DefaultMailer = NewMemoryMailer()
+	default:
+		log.Fatal().Str("email_transport", config.Config.EmailTransport).Msg("unknown email transport")
+	}
+	log.Info().Msgf("email transport: %s", config.Config.EmailTransport)
+}
+
+/* smtp */
+
+// SMTPMailer 通过 EMAIL_SERVER_NO_REPLY_URL 指定的 SMTP 服务器发送邮件.
+// smtps: 隐式 TLS，通常为 465 端口；
+// starttls: 强制 STARTTLS，通常为 587 端口；
+// smtp: 服务器支持时使用 STARTTLS，否则明文发送，仅用于内网中继
+type SMTPMailer struct {
+	Transport string
+}
+
+func NewSMTPMailer(transport string) *SMTPMailer {
+	return &SMTPMailer{Transport: transport}
+}
+
+func (m *SMTPMailer) Send(e *email.Email) error {
+	serverUrl := config.Config.EmailServerNoReplyUrl
+	if serverUrl.String() == "" {
+		return fmt.Errorf("failed to send email: email_server_no_reply_url not set")
+	}
+	password, _ := serverUrl.User.Password()
+	auth := smtp.PlainAuth("", e.From, password, serverUrl.Hostname())
+	tlsConfig := &tls.Config{ServerName: serverUrl.Hostname()}
+
+	switch m.Transport {
+	case EmailTransportSMTPS:
+		return e.SendWithTLS(serverUrl.Host, auth, tlsConfig)
+	case EmailTransportStartTLS:
+		return e.SendWithStartTLS(serverUrl.Host, auth, tlsConfig)
+	default:
+		if serverUrl.User.Username() == "" {
+			auth = nil
+		}
+		return e.Send(serverUrl.Host, auth)
+	}
+}
+
+/* file */
+
+// FileMailer 将邮件以 .eml 格式写入目录，用于本地开发
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(e *email.Email) error {
+	content, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000"), randstr.Hex(4))
+	err = os.WriteFile(filepath.Join(m.Dir, filename), content, 0644)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Strs("to", e.To).Str("subject", e.Subject).Str("filename", filename).Msg("email saved")
+	return nil
+}
+
+/* memory */
+
+// MemoryMailer 将邮件保存在内存中，用于测试
+type MemoryMailer struct {
+	sync.Mutex
+	messages []*email.Email
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{messages: make([]*email.Email, 0, 10)}
+}
+
+func (m *MemoryMailer) Send(e *email.Email) error {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, e)
+	return nil
+}
+
+// Messages 返回所有已发送邮件的副本
+func (m *MemoryMailer) Messages() []*email.Email {
+	m.Lock()
+	defer m.Unlock()
+	messages := make([]*email.Email, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
+
+// LastTo 返回最后一封发送给 receiver 的邮件，没有则返回 nil
+func (m *MemoryMailer) LastTo(receiver string) *email.Email {
+	m.Lock()
+	defer m.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if InUnorderedSlice(m.messages[i].To, receiver) {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
+
+var verificationCodeRegexp = regexp.MustCompile(`\b\d{6}\b`)
+
+// VerificationCode 返回最后一封发送给 receiver 的邮件中的验证码，没有则返回空字符串
+func (m *MemoryMailer) VerificationCode(receiver string) string {
+	e := m.LastTo(receiver)
+	if e == nil {
+		return ""
+	}
+	return verificationCodeRegexp.FindString(string(e.Text))
+}
+
+func (m *MemoryMailer) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = m.messages[:0]
+}
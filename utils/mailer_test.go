@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"auth_next/config"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	config.Config.EmailTransport = EmailTransportMemory
+	mailer := NewMemoryMailer()
+	DefaultMailer = mailer
+
+	err := SendEmail("verify", "您的验证码是: 012345", []string{"abcd@fudan.edu.cn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(mailer.Messages()), 1)
+	assert.Equal(t, mailer.VerificationCode("abcd@fudan.edu.cn"), "012345")
+	assert.Equal(t, mailer.VerificationCode("efgh@fudan.edu.cn"), "")
+
+	mailer.Reset()
+	assert.Equal(t, len(mailer.Messages()), 0)
+}
+
+func TestFileMailer(t *testing.T) {
+	config.Config.EmailTransport = EmailTransportFile
+	dir := t.TempDir()
+	DefaultMailer = &FileMailer{Dir: dir}
+
+	err := SendEmailWithHTML("verify", "text", "<p>html</p>", []string{"abcd@fudan.edu.cn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(files), 1)
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "auth"
+
+// EmailBlockedTotal 被邮箱黑名单拒绝的次数，source 为拒绝发生的接口
+var EmailBlockedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "email_blocked_total",
+	Help:      "Number of emails rejected by the email blocklist.",
+}, []string{"source"})
+
+// VerificationCodeChecksTotal 验证码检查次数，result 为 success, failed, invalidated, locked 或 missing
+var VerificationCodeChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "verification_code_checks_total",
+	Help:      "Number of verification code checks by scope and result.",
+}, []string{"scope", "result"})
@@ -0,0 +1,99 @@
+package shamir
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func envelopeShare(t *testing.T) Share {
+	shares, _, err := EncryptVerifiable("user@example.com", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := shares[1]
+	share.Meta = &ShareMeta{KeySetID: 3, UserID: 42, Index: 2, Threshold: 2}
+	return share
+}
+
+func TestEnvelope(t *testing.T) {
+	share := envelopeShare(t)
+	text := share.ToString()
+	if !strings.HasPrefix(text, "shamir-share v1\n") || !strings.Contains(text, "\nchecksum: ") {
+		t.Fatalf("unexpected envelope %q", text)
+	}
+
+	// surrounding whitespace and CRLF from copy and paste are accepted
+	parsed, err := FromString("\n" + strings.ReplaceAll(text, "\n", "\r\n") + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed.Meta != *share.Meta || parsed.X.Cmp(share.X) != 0 || parsed.Y.Cmp(share.Y) != 0 || parsed.T.Cmp(share.T) != 0 {
+		t.Fatalf("parsed share %v differs from %v", parsed, share)
+	}
+
+	// json keeps the envelope
+	data, err := json.Marshal(map[int]Share{42: share})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shares map[int]Share
+	err = json.Unmarshal(data, &shares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shares[42].Meta == nil || shares[42].Meta.UserID != 42 {
+		t.Fatalf("meta lost after json round trip: %v", shares[42])
+	}
+}
+
+func TestEnvelopeRejected(t *testing.T) {
+	text := envelopeShare(t).ToString()
+	lines := strings.Split(text, "\n")
+
+	// truncated paste
+	_, err := FromString(strings.Join(lines[:len(lines)-2], "\n"))
+	if !errors.Is(err, ErrShareTruncated) {
+		t.Fatalf("expect ErrShareTruncated, got %v", err)
+	}
+
+	// one digit changed
+	tampered := strings.Replace(text, "user: 42", "user: 43", 1)
+	_, err = FromString(tampered)
+	if !errors.Is(err, ErrShareChecksum) {
+		t.Fatalf("expect ErrShareChecksum, got %v", err)
+	}
+
+	// y cut short but checksum line kept
+	yLine := lines[6]
+	_, err = FromString(strings.Replace(text, yLine, yLine[:len(yLine)-3], 1))
+	if !errors.Is(err, ErrShareChecksum) {
+		t.Fatalf("expect ErrShareChecksum, got %v", err)
+	}
+
+	// unknown version
+	_, err = FromString(strings.Replace(text, "shamir-share v1", "shamir-share v9", 1))
+	if !errors.Is(err, ErrShareUnsupported) {
+		t.Fatalf("expect ErrShareUnsupported, got %v", err)
+	}
+}
+
+func TestLegacyShareWithoutMeta(t *testing.T) {
+	shares, err := Encrypt("user@example.com", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[0].ToString()
+	if strings.HasPrefix(text, "shamir-share") {
+		t.Fatal("share without meta should use the legacy format")
+	}
+	parsed, err := FromString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Meta != nil || parsed.X.Cmp(shares[0].X) != 0 || parsed.Y.Cmp(shares[0].Y) != 0 {
+		t.Fatalf("unexpected share %v", parsed)
+	}
+}
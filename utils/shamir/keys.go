@@ -0,0 +1,110 @@
+package shamir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+// ManifestFile cmd/shamir-keygen 生成的公钥清单文件名
+const ManifestFile = "manifest.json"
+
+// demoKeyFingerprints data/{1..7}-public.key 的指纹，私钥也在仓库中，只能用于开发和测试
+var demoKeyFingerprints = []string{
+	"a4f2189bac40015f6e685c6d40851f018304e2d4",
+	"5179ef91562a8578aedf441d14499202c98a0a9f",
+	"fa080c873774ca023dd9c8bcfbb2205fccfbdfc1",
+	"b722f2f73d9f40b65e692da11ebe5d5770bc0189",
+	"cbe9d1cd15c213a135d297f31c29032378017d3a",
+	"039b0e7e0266667b623cfcf407464b2622459bca",
+	"f36ae51a949df0a0aaf7f5f8a1254c3e7ebbba9e",
+}
+
+var ErrDemoKey = errors.New("bundled demo key, its private key is public")
+
+// KeyManifest 一次密钥仪式生成的公钥清单，按 Index 排列
+type KeyManifest struct {
+	CreatedAt time.Time     `json:"created_at"`
+	Keys      []ManifestKey `json:"keys"`
+}
+
+type ManifestKey struct {
+	Index          int    `json:"index"` // 从 1 开始
+	IdentityName   string `json:"identity_name"`
+	Fingerprint    string `json:"fingerprint"`
+	Algorithm      string `json:"algorithm"`
+	PublicKeyFile  string `json:"public_key_file"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+}
+
+// IsDemoKey 检查公钥是否为仓库中的示例公钥
+func IsDemoKey(fingerprint string) bool {
+	return slices.Contains(demoKeyFingerprints, fingerprint)
+}
+
+// CheckDemoKeys 任何一个公钥是示例公钥时返回 ErrDemoKey
+func CheckDemoKeys(armoredPublicKeys []string) error {
+	for i, armoredPublicKey := range armoredPublicKeys {
+		key, err := crypto.NewKeyFromArmored(armoredPublicKey)
+		if err != nil {
+			return err
+		}
+		if IsDemoKey(key.GetFingerprint()) {
+			return fmt.Errorf("public key %d (%s): %w", i+1, key.GetEntity().PrimaryIdentity().Name, ErrDemoKey)
+		}
+	}
+	return nil
+}
+
+// LoadKeyManifest 读取 dir 中的清单和公钥，检查指纹和 identity，返回按 Index 排列的公钥
+func LoadKeyManifest(dir string) (*KeyManifest, []string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest KeyManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if len(manifest.Keys) == 0 {
+		return nil, nil, errors.New("no keys in manifest")
+	}
+
+	armoredPublicKeys := make([]string, len(manifest.Keys))
+	identityNames := make(map[string]bool, len(manifest.Keys))
+	for i, manifestKey := range manifest.Keys {
+		if manifestKey.Index != i+1 {
+			return nil, nil, fmt.Errorf("key %d: unexpected index %d", i+1, manifestKey.Index)
+		}
+		if identityNames[manifestKey.IdentityName] {
+			return nil, nil, fmt.Errorf("key %d: duplicate identity %q", manifestKey.Index, manifestKey.IdentityName)
+		}
+		identityNames[manifestKey.IdentityName] = true
+
+		armoredPublicKey, err := os.ReadFile(filepath.Join(dir, manifestKey.PublicKeyFile))
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %d: %w", manifestKey.Index, err)
+		}
+		key, err := crypto.NewKeyFromArmored(string(armoredPublicKey))
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %d: %w", manifestKey.Index, err)
+		}
+		if key.GetFingerprint() != manifestKey.Fingerprint {
+			return nil, nil, fmt.Errorf("key %d: fingerprint %s doesn't match manifest %s",
+				manifestKey.Index, key.GetFingerprint(), manifestKey.Fingerprint)
+		}
+		if key.GetEntity().PrimaryIdentity().Name != manifestKey.IdentityName {
+			return nil, nil, fmt.Errorf("key %d: identity %q doesn't match manifest %q",
+				manifestKey.Index, key.GetEntity().PrimaryIdentity().Name, manifestKey.IdentityName)
+		}
+		armoredPublicKeys[i] = string(armoredPublicKey)
+	}
+	return &manifest, armoredPublicKeys, nil
+}
@@ -0,0 +1,104 @@
+package shamir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/gopenpgp/v2/helper"
+	"github.com/goccy/go-json"
+)
+
+// writeTestManifest 生成 n 个公钥和清单，返回目录
+func writeTestManifest(t *testing.T, n int) string {
+	dir := t.TempDir()
+	var manifest KeyManifest
+	for i := 1; i <= n; i++ {
+		armoredPrivateKey, err := helper.GenerateKey(fmt.Sprintf("trustee%d", i), fmt.Sprintf("trustee%d@example.com", i), []byte("passphrase"), "x25519", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := crypto.NewKeyFromArmored(armoredPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		armoredPublicKey, err := key.GetArmoredPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifestKey := ManifestKey{
+			Index:         i,
+			IdentityName:  key.GetEntity().PrimaryIdentity().Name,
+			Fingerprint:   key.GetFingerprint(),
+			Algorithm:     "x25519",
+			PublicKeyFile: fmt.Sprintf("%d-public.key", i),
+		}
+		err = os.WriteFile(filepath.Join(dir, manifestKey.PublicKeyFile), []byte(armoredPublicKey), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Keys = append(manifest.Keys, manifestKey)
+	}
+	writeManifest(t, dir, manifest)
+	return dir
+}
+
+func writeManifest(t *testing.T, dir string, manifest KeyManifest) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, ManifestFile), data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeyManifest(t *testing.T) {
+	dir := writeTestManifest(t, 3)
+	manifest, armoredPublicKeys, err := LoadKeyManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(armoredPublicKeys) != 3 || manifest.Keys[2].IdentityName != "trustee3 <trustee3@example.com>" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if CheckDemoKeys(armoredPublicKeys) != nil {
+		t.Fatal("generated keys are not demo keys")
+	}
+
+	// a public key replaced after the ceremony
+	replaced := *manifest
+	replaced.Keys = append([]ManifestKey(nil), manifest.Keys...)
+	replaced.Keys[0].Fingerprint, replaced.Keys[1].Fingerprint = manifest.Keys[1].Fingerprint, manifest.Keys[0].Fingerprint
+	writeManifest(t, dir, replaced)
+	_, _, err = LoadKeyManifest(dir)
+	if err == nil {
+		t.Fatal("expect fingerprint mismatch")
+	}
+
+	// keys out of order
+	reordered := *manifest
+	reordered.Keys = []ManifestKey{manifest.Keys[1], manifest.Keys[0], manifest.Keys[2]}
+	writeManifest(t, dir, reordered)
+	_, _, err = LoadKeyManifest(dir)
+	if err == nil {
+		t.Fatal("expect index mismatch")
+	}
+}
+
+func TestCheckDemoKeys(t *testing.T) {
+	for i := 1; i <= len(demoKeyFingerprints); i++ {
+		armoredPublicKey, err := os.ReadFile(fmt.Sprintf("../../data/%d-public.key", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckDemoKeys([]string{string(armoredPublicKey)})
+		if !errors.Is(err, ErrDemoKey) {
+			t.Fatalf("demo key %d not detected: %v", i, err)
+		}
+	}
+}
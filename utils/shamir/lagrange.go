@@ -0,0 +1,168 @@
+package shamir
+
+import (
+	"errors"
+	. "math/big"
+)
+
+// ErrDuplicateX 坐标点的 x 在 GF(P) 中重复，无法插值
+var ErrDuplicateX = errors.New("duplicate x coordinates")
+
+// Interpolator 固定 x 集合的拉格朗日插值，预先计算权重，可以对不同的 y 重复使用.
+//
+// w_i = 1 / ∏_{j≠i} (x_i - x_j)，所有逆元用 Montgomery 批量求逆一次求出；
+// 多项式在 x 处的值为 Σ y_i * w_i * ∏_{j≠i} (x - x_j)。
+// 不能并发使用，内部复用临时变量
+type Interpolator struct {
+	xs      []*Int
+	weights []*Int
+	zero    []*Int // 常数项的拉格朗日系数 λ_i = w_i * ∏_{j≠i} (0 - x_j)
+
+	// scratch
+	prefix []*Int
+	term   *Int
+	sum    *Int
+}
+
+// NewInterpolator 为 xs 预计算权重，x 重复时返回 ErrDuplicateX
+func NewInterpolator(xs []*Int) (*Interpolator, error) {
+	n := len(xs)
+	ip := &Interpolator{
+		xs:      make([]*Int, n),
+		weights: make([]*Int, n),
+		zero:    make([]*Int, n),
+		prefix:  make([]*Int, n+1),
+		term:    new(Int),
+		sum:     new(Int),
+	}
+	for i := range xs {
+		ip.xs[i] = new(Int).Mod(xs[i], P)
+	}
+	for i := range ip.prefix {
+		ip.prefix[i] = new(Int)
+	}
+
+	// denominators ∏_{j≠i} (x_i - x_j)
+	diff := new(Int)
+	for i := range ip.xs {
+		denominator := NewInt(1)
+		for j := range ip.xs {
+			if i == j {
+				continue
+			}
+			diff.Sub(ip.xs[i], ip.xs[j])
+			denominator.Mul(denominator, diff)
+			denominator.Mod(denominator, P)
+		}
+		ip.weights[i] = denominator
+	}
+	err := batchInverse(ip.weights)
+	if err != nil {
+		return nil, err
+	}
+
+	ip.evaluateBasis(new(Int), ip.zero)
+	return ip, nil
+}
+
+// evaluateBasis 计算 x 处的拉格朗日基 basis_i = w_i * ∏_{j≠i} (x - x_j)，x 不能在 xs 中
+func (ip *Interpolator) evaluateBasis(x *Int, basis []*Int) {
+	n := len(ip.xs)
+
+	// prefix[i] = ∏_{j<i} (x - x_j)
+	ip.prefix[0].SetInt64(1)
+	for j := 0; j < n; j++ {
+		ip.term.Sub(x, ip.xs[j])
+		ip.prefix[j+1].Mul(ip.prefix[j], ip.term)
+		ip.prefix[j+1].Mod(ip.prefix[j+1], P)
+	}
+
+	// suffix ∏_{j>i} (x - x_j), accumulated from the end
+	suffix := ip.sum.SetInt64(1)
+	for i := n - 1; i >= 0; i-- {
+		if basis[i] == nil {
+			basis[i] = new(Int)
+		}
+		basis[i].Mul(ip.prefix[i], suffix)
+		basis[i].Mod(basis[i], P)
+		basis[i].Mul(basis[i], ip.weights[i])
+		basis[i].Mod(basis[i], P)
+
+		ip.term.Sub(x, ip.xs[i])
+		suffix.Mul(suffix, ip.term)
+		suffix.Mod(suffix, P)
+	}
+}
+
+// Secret 计算过 (xs[i], ys[i]) 的多项式的常数项
+func (ip *Interpolator) Secret(ys []*Int) *Int {
+	return ip.combine(ip.zero, ys)
+}
+
+// At 计算过 (xs[i], ys[i]) 的多项式在 x 处的值
+func (ip *Interpolator) At(ys []*Int, x *Int) *Int {
+	x = new(Int).Mod(x, P)
+	for i := range ip.xs {
+		if ip.xs[i].Cmp(x) == 0 {
+			return new(Int).Mod(ys[i], P)
+		}
+	}
+	basis := make([]*Int, len(ip.xs))
+	ip.evaluateBasis(x, basis)
+	return ip.combine(basis, ys)
+}
+
+func (ip *Interpolator) combine(basis, ys []*Int) *Int {
+	s := new(Int)
+	for i := range basis {
+		ip.term.Mul(ys[i], basis[i])
+		s.Add(s, ip.term)
+	}
+	return s.Mod(s, P)
+}
+
+// batchInverse 用 Montgomery 技巧原地求所有数模 P 的逆元，只做一次模逆运算；有 0 时返回 ErrDuplicateX
+func batchInverse(values []*Int) error {
+	n := len(values)
+	if n == 0 {
+		return nil
+	}
+
+	// prefix[i] = values[0] * ... * values[i]
+	prefix := make([]*Int, n)
+	accumulator := NewInt(1)
+	for i, value := range values {
+		if value.Sign() == 0 {
+			return ErrDuplicateX
+		}
+		accumulator = new(Int).Mod(new(Int).Mul(accumulator, value), P)
+		prefix[i] = accumulator
+	}
+
+	inverse := new(Int).ModInverse(prefix[n-1], P)
+	if inverse == nil {
+		return ErrDuplicateX
+	}
+
+	// walk back: values[i]^{-1} = inverse(prefix[i]) * prefix[i-1]
+	tmp := new(Int)
+	for i := n - 1; i > 0; i-- {
+		tmp.Mul(inverse, prefix[i-1])
+		inverse.Mul(inverse, values[i])
+		inverse.Mod(inverse, P)
+		values[i].Mod(tmp, P)
+	}
+	values[0].Set(inverse)
+	return nil
+}
+
+// sharesXY 拆分坐标点的 x 和 y
+func sharesXY(shares []Share) ([]*Int, []*Int) {
+	xs := make([]*Int, len(shares))
+	ys := make([]*Int, len(shares))
+	for i := range shares {
+		xs[i] = shares[i].X
+		ys[i] = shares[i].Y
+	}
+	return xs, ys
+}
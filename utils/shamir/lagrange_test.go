@@ -0,0 +1,228 @@
+package shamir
+
+import (
+	"fmt"
+	. "math/big"
+	"math/rand"
+	"testing"
+)
+
+// interpolateAtSlow 逐项求逆计算多项式在 x 处的值，作为 Interpolator.At 的参照
+func interpolateAtSlow(shares []Share, x *Int) *Int {
+	s := NewInt(0)
+	for i := range shares {
+		pi := NewInt(1)
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			numerator := new(Int).Sub(shares[j].X, x)
+			denominator := ModularMultiplicativeInverse(new(Int).Sub(shares[j].X, shares[i].X))
+			pi = new(Int).Mod(new(Int).Mul(pi, new(Int).Mul(numerator, denominator)), P)
+		}
+		s = new(Int).Mod(new(Int).Add(s, new(Int).Mul(shares[i].Y, pi)), P)
+	}
+	return s
+}
+
+func randomInt(r *rand.Rand, max *Int) *Int {
+	return new(Int).Rand(r, max)
+}
+
+// randomShares 生成 n 个随机坐标点，y 不一定在同一个低次多项式上；
+// 包含小的 x、负数、大于 P 的数等边界情况
+func randomShares(r *rand.Rand, n int) []Share {
+	shares := make([]Share, n)
+	for i := range shares {
+		var x *Int
+		switch r.Intn(4) {
+		case 0:
+			x = NewInt(int64(i + 1))
+		case 1:
+			x = new(Int).Neg(randomInt(r, P))
+		case 2:
+			x = new(Int).Add(randomInt(r, P), P)
+		default:
+			x = randomInt(r, P)
+		}
+		shares[i] = Share{X: x, Y: randomInt(r, new(Int).Lsh(P, 1))}
+	}
+	return shares
+}
+
+func TestLagrangeMatchesReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 100; round++ {
+		shares := randomShares(r, 1+r.Intn(8))
+		got := Lagrange(shares)
+		want := lagrangeSlow(shares)
+		if got.Cmp(want) != 0 {
+			t.Fatalf("round %d: Lagrange = %d, reference = %d, shares = %v", round, got, want, shares)
+		}
+	}
+
+	// duplicate x falls back to the reference
+	shares := randomShares(r, 4)
+	shares[3].X = new(Int).Add(shares[1].X, P)
+	if Lagrange(shares).Cmp(lagrangeSlow(shares)) != 0 {
+		t.Fatal("Lagrange with duplicate x differs from reference")
+	}
+
+	// no shares
+	if Lagrange(nil).Cmp(lagrangeSlow(nil)) != 0 {
+		t.Fatal("Lagrange without shares differs from reference")
+	}
+}
+
+func TestInterpolatorMatchesReference(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for round := 0; round < 50; round++ {
+		shares := randomShares(r, 1+r.Intn(8))
+		xs, ys := sharesXY(shares)
+		ip, err := NewInterpolator(xs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// reuse the interpolator for several y sets and points
+		for reuse := 0; reuse < 3; reuse++ {
+			if ip.Secret(ys).Cmp(lagrangeSlow(shares)) != 0 {
+				t.Fatalf("round %d: Secret differs from reference", round)
+			}
+			x := randomInt(r, P)
+			if ip.At(ys, x).Cmp(interpolateAtSlow(shares, x)) != 0 {
+				t.Fatalf("round %d: At differs from reference", round)
+			}
+			for i := range ys {
+				ys[i] = randomInt(r, P)
+				shares[i].Y = ys[i]
+			}
+		}
+
+		// at a known point the value is the share itself
+		if ip.At(ys, shares[0].X).Cmp(new(Int).Mod(ys[0], P)) != 0 {
+			t.Fatalf("round %d: At(x_0) != y_0", round)
+		}
+	}
+}
+
+func TestInterpolatorRecoversSecret(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for round := 0; round < 100; round++ {
+		threshold := 1 + r.Intn(8)
+		secret := randomInt(r, P)
+		shares, err := Generate(secret, threshold+r.Intn(4), threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Lagrange(shares[:threshold]).Cmp(secret) != 0 {
+			t.Fatalf("round %d: secret not recovered", round)
+		}
+		if Lagrange(shares).Cmp(secret) != 0 {
+			t.Fatalf("round %d: secret not recovered with all shares", round)
+		}
+	}
+}
+
+func TestBatchInverse(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	values := make([]*Int, 20)
+	originals := make([]*Int, len(values))
+	for i := range values {
+		values[i] = new(Int).Add(randomInt(r, new(Int).Sub(P, NewInt(1))), NewInt(1))
+		originals[i] = new(Int).Set(values[i])
+	}
+	err := batchInverse(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		product := new(Int).Mod(new(Int).Mul(values[i], originals[i]), P)
+		if product.Cmp(NewInt(1)) != 0 {
+			t.Fatalf("values[%d] is not the inverse", i)
+		}
+	}
+
+	err = batchInverse([]*Int{NewInt(3), NewInt(0)})
+	if err != ErrDuplicateX {
+		t.Fatalf("expect ErrDuplicateX, got %v", err)
+	}
+}
+
+func benchmarkShares(b *testing.B, num, threshold int) []Share {
+	shares, err := Encrypt("user@example.com", num, threshold)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return shares[:threshold]
+}
+
+func BenchmarkLagrange(b *testing.B) {
+	for _, threshold := range []int{2, 4, 7, 16} {
+		shares := benchmarkShares(b, threshold, threshold)
+		b.Run(fmt.Sprintf("t=%d/interpolator", threshold), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Lagrange(shares)
+			}
+		})
+		b.Run(fmt.Sprintf("t=%d/reference", threshold), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lagrangeSlow(shares)
+			}
+		})
+	}
+}
+
+func BenchmarkInterpolatorSecret(b *testing.B) {
+	shares := benchmarkShares(b, 7, 4)
+	xs, ys := sharesXY(shares)
+	ip, err := NewInterpolator(xs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ip.Secret(ys)
+	}
+}
+
+func BenchmarkRobustDecrypt(b *testing.B) {
+	shares, err := Encrypt("user@example.com", 7, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	shares[0].Y = NewInt(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err = RobustDecrypt(shares, 4)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncryptVerifiable(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, err := EncryptVerifiable("user@example.com", 7, 4)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	shares, commitments, err := EncryptVerifiable("user@example.com", 7, 4)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		commitments.Verify(shares[i%len(shares)])
+	}
+}
@@ -0,0 +1,88 @@
+package shamir
+
+import (
+	"errors"
+	. "math/big"
+)
+
+// ErrNotRecoverable 一致的坐标点不够，无法确定秘密
+var ErrNotRecoverable = errors.New("not enough consistent shares to recover the secret")
+
+// RobustLagrange 从可能包含错误坐标点的 shares 中恢复常数项，返回与恢复的多项式不一致的坐标点下标.
+//
+// 依次尝试 threshold 个坐标点的组合，插值后统计其他坐标点是否在多项式上；
+// 错误坐标点不超过 (n - threshold) / 2 个时，结果唯一。
+// 坐标点数量等于门限时无法发现错误，直接插值
+func RobustLagrange(shares []Share, threshold int) (*Int, []int, error) {
+	n := len(shares)
+	if threshold <= 0 || n < threshold {
+		return nil, nil, ErrNotRecoverable
+	}
+	if n == threshold {
+		return Lagrange(shares), nil, nil
+	}
+
+	maxErrors := (n - threshold) / 2
+	subset := make([]int, threshold)
+	for i := range subset {
+		subset[i] = i
+	}
+	xs := make([]*Int, threshold)
+	ys := make([]*Int, threshold)
+	y := new(Int)
+
+	for {
+		for i, index := range subset {
+			xs[i] = shares[index].X
+			ys[i] = shares[index].Y
+		}
+		ip, err := NewInterpolator(xs)
+		if err == nil {
+			inconsistent := make([]int, 0, maxErrors)
+			next := 0
+			for k := 0; k < n && len(inconsistent) <= maxErrors; k++ {
+				if next < threshold && subset[next] == k {
+					next++
+					continue
+				}
+				y.Mod(shares[k].Y, P)
+				if ip.At(ys, shares[k].X).Cmp(y) != 0 {
+					inconsistent = append(inconsistent, k)
+				}
+			}
+			if len(inconsistent) <= maxErrors {
+				return ip.Secret(ys), inconsistent, nil
+			}
+		}
+
+		if !nextCombination(subset, n) {
+			return nil, nil, ErrNotRecoverable
+		}
+	}
+}
+
+// RobustDecrypt 同 Decrypt，容忍错误的坐标点，并返回不一致的坐标点下标
+func RobustDecrypt(shares []Share, threshold int) (string, []int, error) {
+	secret, inconsistent, err := RobustLagrange(shares, threshold)
+	if err != nil {
+		return "", nil, err
+	}
+	return intToSecret(secret), inconsistent, nil
+}
+
+// nextCombination 按字典序生成下一个组合，已是最后一个时返回 false
+func nextCombination(subset []int, n int) bool {
+	k := len(subset)
+	i := k - 1
+	for i >= 0 && subset[i] == n-k+i {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	subset[i]++
+	for j := i + 1; j < k; j++ {
+		subset[j] = subset[j-1] + 1
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package shamir
+
+import (
+	. "math/big"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestRobustDecrypt(t *testing.T) {
+	secret := "user@example.com"
+	shares, err := Encrypt(secret, 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no faulty shares
+	result, inconsistent, err := RobustDecrypt(shares, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != secret || len(inconsistent) != 0 {
+		t.Fatalf("unexpected result %q, inconsistent %v", result, inconsistent)
+	}
+
+	// up to (7 - 3) / 2 = 2 faulty shares are corrected and identified
+	corrupted := make([]Share, len(shares))
+	copy(corrupted, shares)
+	corrupted[0] = Share{X: shares[0].X, Y: new(Int).Add(shares[0].Y, NewInt(1))}
+	corrupted[4] = Share{X: shares[4].X, Y: NewInt(42)}
+	result, inconsistent, err = RobustDecrypt(corrupted, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != secret {
+		t.Fatalf("expect %q, got %q", secret, result)
+	}
+	if !slices.Equal(inconsistent, []int{0, 4}) {
+		t.Fatalf("expect inconsistent [0 4], got %v", inconsistent)
+	}
+	if Decrypt(corrupted) == secret {
+		t.Fatal("plain decrypt should be affected by faulty shares")
+	}
+
+	// too many faulty shares
+	corrupted[2] = Share{X: shares[2].X, Y: NewInt(43)}
+	_, _, err = RobustDecrypt(corrupted, 3)
+	if err != ErrNotRecoverable {
+		t.Fatalf("expect ErrNotRecoverable, got %v", err)
+	}
+
+	// not enough shares
+	_, _, err = RobustDecrypt(shares[:2], 3)
+	if err != ErrNotRecoverable {
+		t.Fatalf("expect ErrNotRecoverable, got %v", err)
+	}
+
+	// exactly threshold shares can't be checked
+	result, inconsistent, err = RobustDecrypt(shares[:3], 3)
+	if err != nil || result != secret || len(inconsistent) != 0 {
+		t.Fatalf("unexpected result %q, inconsistent %v, err %v", result, inconsistent, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	. "math/big"
+	"strings"
 
 	"auth_next/utils"
 )
@@ -20,20 +21,51 @@ func init() {
 type Share struct {
 	X *Int `json:"x"`
 	Y *Int `json:"y"`
+	T *Int `json:"t,omitempty"` // Pedersen 盲化值，用于验证坐标点，旧的坐标点没有
+
+	Meta *ShareMeta `json:"meta,omitempty"` // 来源信息，有时使用信封格式，见 envelope.go
 }
 
 type Shares []Share
 
+// ToString 有 Meta 时使用带校验和的信封格式；
+// 否则为旧格式，每行一个数，依次为 x, y 和可选的盲化值 t
 func (share Share) ToString() string {
+	if share.Meta != nil {
+		return share.envelope()
+	}
+	if share.T != nil {
+		return fmt.Sprintf("%d\n%d\n%d", share.X, share.Y, share.T)
+	}
 	return fmt.Sprintf("%d\n%d", share.X, share.Y)
 }
 
+// FromString 解析信封格式或旧格式的坐标点，信封被截断或改动时返回错误
 func FromString(rawShare string) (Share, error) {
-	share := Share{new(Int), new(Int)}
-	_, err := fmt.Sscanf(rawShare, "%v\n%v", share.X, share.Y)
+	if isEnvelope(rawShare) {
+		return parseEnvelope(rawShare)
+	}
+
+	share := Share{X: new(Int), Y: new(Int)}
+	lines := strings.Split(strings.TrimSpace(rawShare), "\n")
+	if len(lines) != 2 && len(lines) != 3 {
+		return share, fmt.Errorf("share should have 2 or 3 lines, got %d", len(lines))
+	}
+	_, err := fmt.Sscan(lines[0], share.X)
 	if err != nil {
 		return share, err
 	}
+	_, err = fmt.Sscan(lines[1], share.Y)
+	if err != nil {
+		return share, err
+	}
+	if len(lines) == 3 {
+		share.T = new(Int)
+		_, err = fmt.Sscan(lines[2], share.T)
+		if err != nil {
+			return share, err
+		}
+	}
 	return share, nil
 }
 
@@ -46,6 +78,30 @@ func (share Share) MarshalText() ([]byte, error) {
 	return []byte(share.ToString()), nil
 }
 
+// Zero 将坐标点的数值覆盖为 0，用完后调用，避免明文坐标点留在内存中直到被回收
+func (share Share) Zero() {
+	for _, i := range []*Int{share.X, share.Y, share.T} {
+		zeroInt(i)
+	}
+}
+
+// Zero 覆盖所有坐标点
+func (shares Shares) Zero() {
+	for _, share := range shares {
+		share.Zero()
+	}
+}
+
+// zeroInt 覆盖底层数组后置 0；SetInt64 只修改长度，不会清除原来的数据
+func zeroInt(i *Int) {
+	if i == nil {
+		return
+	}
+	words := i.Bits()
+	clear(words[:cap(words)])
+	i.SetInt64(0)
+}
+
 // extendedGCD 扩展欧几里得算法，求不定方程ax + by = 1的可行x, y值，非递归线性解法
 // https://zhuanlan.zhihu.com/p/58241990
 func extendedGCD(a *Int, b *Int) (*Int, *Int) {
@@ -69,6 +125,17 @@ func ModularMultiplicativeInverse(x *Int) *Int {
 
 // Lagrange 计算拉格朗日差值多项式的常数项 a0
 func Lagrange(shares []Share) *Int {
+	xs, ys := sharesXY(shares)
+	ip, err := NewInterpolator(xs)
+	if err != nil {
+		// x 重复时逆元不存在，保持逐项求逆的结果
+		return lagrangeSlow(shares)
+	}
+	return ip.Secret(ys)
+}
+
+// lagrangeSlow 逐项求逆计算常数项，每对坐标点求一次逆元
+func lagrangeSlow(shares []Share) *Int {
 	s := NewInt(0)
 	xArray := make([]*Int, len(shares))
 	for i := range xArray {
@@ -89,21 +156,23 @@ func Lagrange(shares []Share) *Int {
 	return s
 }
 
-func Generate(secret *Int, num, threshold int) ([]Share, error) {
-	evaluate := func(coefficients []*Int, x *Int) *Int {
-		ans := NewInt(0)
-		power := NewInt(1)
-		for _, c := range coefficients {
-			// ans = (ans + c * power) % P
-			ans = new(Int).Mod(new(Int).Add(ans, new(Int).Mul(c, power)), P)
-			// power = (power * x) % P
-			power = new(Int).Mod(new(Int).Mul(power, x), P)
-		}
-		return ans
+// evaluate 计算多项式在 x 处的值
+func evaluate(coefficients []*Int, x *Int) *Int {
+	ans := NewInt(0)
+	power := NewInt(1)
+	for _, c := range coefficients {
+		// ans = (ans + c * power) % P
+		ans = new(Int).Mod(new(Int).Add(ans, new(Int).Mul(c, power)), P)
+		// power = (power * x) % P
+		power = new(Int).Mod(new(Int).Mul(power, x), P)
 	}
+	return ans
+}
 
+// randomPolynomial 生成常数项为 constant 的 threshold - 1 次随机多项式的系数
+func randomPolynomial(constant *Int, threshold int) ([]*Int, error) {
 	coefficient := make([]*Int, threshold)
-	coefficient[0] = new(Int).Set(secret)
+	coefficient[0] = new(Int).Set(constant)
 	for i := 1; i < threshold; i++ {
 		c, err := rand.Int(rand.Reader, P)
 		if err != nil {
@@ -111,6 +180,14 @@ func Generate(secret *Int, num, threshold int) ([]Share, error) {
 		}
 		coefficient[i] = c
 	}
+	return coefficient, nil
+}
+
+func Generate(secret *Int, num, threshold int) ([]Share, error) {
+	coefficient, err := randomPolynomial(secret, threshold)
+	if err != nil {
+		return nil, err
+	}
 
 	shares := make([]Share, num)
 	for i := range shares {
@@ -118,27 +195,40 @@ func Generate(secret *Int, num, threshold int) ([]Share, error) {
 		if err != nil {
 			return nil, err
 		}
-		shares[i] = Share{x, evaluate(coefficient, x)}
+		shares[i] = Share{X: x, Y: evaluate(coefficient, x)}
 	}
 	return shares, nil
 }
 
-func Encrypt(secret string, num, threshold int) ([]Share, error) {
+// secretToInt 将秘密转换为 GF(P) 中的数，并检查门限，门限为 0 时使用 num/2 + 1
+func secretToInt(secret string, num, threshold int) (*Int, int, error) {
 	if len(secret) > MaxLength {
-		return nil, errors.New(fmt.Sprintf("length of secret should less than %d", MaxLength))
+		return nil, 0, errors.New(fmt.Sprintf("length of secret should less than %d", MaxLength))
 	}
 	s := new(Int).SetBytes(utils.SliceReverse([]byte(secret)))
 	if s.Cmp(P) >= 0 {
-		return nil, errors.New(fmt.Sprintf("secret should not bigger than P = %d", P))
+		return nil, 0, errors.New(fmt.Sprintf("secret should not bigger than P = %d", P))
 	}
 	if threshold == 0 {
 		threshold = num/2 + 1
 	} else if threshold > num {
-		return nil, errors.New("threshold is bigger than num, secret could not be recovered")
+		return nil, 0, errors.New("threshold is bigger than num, secret could not be recovered")
+	}
+	return s, threshold, nil
+}
+
+func Encrypt(secret string, num, threshold int) ([]Share, error) {
+	s, threshold, err := secretToInt(secret, num, threshold)
+	if err != nil {
+		return nil, err
 	}
 	return Generate(s, num, threshold)
 }
 
 func Decrypt(shares []Share) string {
-	return string(utils.SliceReverse(Lagrange(shares).Bytes()))
+	return intToSecret(Lagrange(shares))
+}
+
+func intToSecret(secret *Int) string {
+	return string(utils.SliceReverse(secret.Bytes()))
 }
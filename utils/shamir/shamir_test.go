@@ -32,3 +32,22 @@ func TestEncryptAndDecrypt(t *testing.T) {
 func TestModularMultiplicativeInverse(t *testing.T) {
 	fmt.Println(ModularMultiplicativeInverse(NewInt(100)))
 }
+
+func TestShare_Zero(t *testing.T) {
+	shares, err := Encrypt("user@example.com", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := shares[0].Y.Bits()
+	Shares(shares).Zero()
+	for _, share := range shares {
+		if share.X.Sign() != 0 || share.Y.Sign() != 0 {
+			t.Fatalf("share not zeroed: %v", share)
+		}
+	}
+	for _, word := range words[:cap(words)] {
+		if word != 0 {
+			t.Fatal("underlying words not zeroed")
+		}
+	}
+}
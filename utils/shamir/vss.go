@@ -0,0 +1,137 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	. "math/big"
+	"strings"
+)
+
+// Pedersen 可验证秘密分享.
+// 坐标点在 GF(P) 上，承诺在 Z_Q* 中阶为 P 的子群上计算，Q = 336 * P + 1 为素数；
+// 承诺 C_j = G^{a_j} * H^{b_j} mod Q，a_j 为秘密多项式的系数，b_j 为盲化多项式的系数。
+// 不使用 Feldman 承诺：G^{secret} 会公开，邮箱可以被穷举
+var (
+	Q *Int // 承诺所在群的模数
+	G *Int // 阶为 P 的生成元
+	H *Int // 阶为 P 的生成元，与 G 的离散对数未知
+)
+
+const groupCofactor = 336
+
+func init() {
+	Q = new(Int).Add(new(Int).Mul(P, NewInt(groupCofactor)), NewInt(1))
+	G = hashToGroup("auth_next shamir pedersen G")
+	H = hashToGroup("auth_next shamir pedersen H")
+}
+
+// hashToGroup 由公开字符串生成子群元素，任何人都不知道 G 和 H 之间的离散对数
+func hashToGroup(seed string) *Int {
+	cofactor := NewInt(groupCofactor)
+	for counter := 0; ; counter++ {
+		first := sha512.Sum512([]byte(fmt.Sprintf("%s/%d/0", seed, counter)))
+		second := sha512.Sum512([]byte(fmt.Sprintf("%s/%d/1", seed, counter)))
+		u := new(Int).SetBytes(append(first[:], second[:]...))
+		u.Mod(u, Q)
+		element := new(Int).Exp(u, cofactor, Q)
+		if element.Cmp(NewInt(1)) > 0 {
+			return element
+		}
+	}
+}
+
+// Commitments 一个秘密的 Pedersen 承诺，长度等于门限
+type Commitments []*Int
+
+func (commitments Commitments) ToString() string {
+	lines := make([]string, len(commitments))
+	for i, commitment := range commitments {
+		lines[i] = commitment.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func CommitmentsFromString(rawCommitments string) (Commitments, error) {
+	fields := strings.Fields(rawCommitments)
+	if len(fields) == 0 {
+		return nil, errors.New("empty commitments")
+	}
+	commitments := make(Commitments, len(fields))
+	for i, field := range fields {
+		commitment, ok := new(Int).SetString(field, 10)
+		if !ok || commitment.Sign() <= 0 || commitment.Cmp(Q) >= 0 {
+			return nil, fmt.Errorf("invalid commitment %q", field)
+		}
+		commitments[i] = commitment
+	}
+	return commitments, nil
+}
+
+// Verify 检查坐标点是否在承诺的多项式上：G^y * H^t == ∏ C_j^{x^j} mod Q；没有盲化值的坐标点无法验证
+func (commitments Commitments) Verify(share Share) bool {
+	if share.X == nil || share.Y == nil || share.T == nil || len(commitments) == 0 {
+		return false
+	}
+
+	left := new(Int).Exp(G, new(Int).Mod(share.Y, P), Q)
+	left.Mul(left, new(Int).Exp(H, new(Int).Mod(share.T, P), Q))
+	left.Mod(left, Q)
+
+	right := NewInt(1)
+	power := NewInt(1)
+	x := new(Int).Mod(share.X, P)
+	for _, commitment := range commitments {
+		right.Mul(right, new(Int).Exp(commitment, power, Q))
+		right.Mod(right, Q)
+		power = new(Int).Mod(new(Int).Mul(power, x), P)
+	}
+	return left.Cmp(right) == 0
+}
+
+// GenerateVerifiable 拆分秘密，同时生成盲化值和 Pedersen 承诺
+func GenerateVerifiable(secret *Int, num, threshold int) ([]Share, Commitments, error) {
+	coefficients, err := randomPolynomial(secret, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	blinding, err := rand.Int(rand.Reader, P)
+	if err != nil {
+		return nil, nil, err
+	}
+	blindingCoefficients, err := randomPolynomial(blinding, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commitments := make(Commitments, threshold)
+	for j := range commitments {
+		commitment := new(Int).Exp(G, coefficients[j], Q)
+		commitment.Mul(commitment, new(Int).Exp(H, blindingCoefficients[j], Q))
+		commitments[j] = commitment.Mod(commitment, Q)
+	}
+
+	shares := make([]Share, num)
+	for i := range shares {
+		x, err := rand.Int(rand.Reader, P)
+		if err != nil {
+			return nil, nil, err
+		}
+		shares[i] = Share{
+			X: x,
+			Y: evaluate(coefficients, x),
+			T: evaluate(blindingCoefficients, x),
+		}
+	}
+	return shares, commitments, nil
+}
+
+// EncryptVerifiable 同 Encrypt，坐标点包含盲化值，并返回用于验证坐标点的承诺
+func EncryptVerifiable(secret string, num, threshold int) ([]Share, Commitments, error) {
+	s, threshold, err := secretToInt(secret, num, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	return GenerateVerifiable(s, num, threshold)
+}
@@ -0,0 +1,81 @@
+package shamir
+
+import (
+	. "math/big"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	if !Q.ProbablyPrime(32) {
+		t.Fatal("Q is not prime")
+	}
+	for _, generator := range []*Int{G, H} {
+		if generator.Cmp(NewInt(1)) <= 0 || new(Int).Exp(generator, P, Q).Cmp(NewInt(1)) != 0 {
+			t.Fatalf("%d is not a generator of order P", generator)
+		}
+	}
+}
+
+func TestEncryptVerifiable(t *testing.T) {
+	secret := "user@example.com"
+	shares, commitments, err := EncryptVerifiable(secret, 7, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commitments) != 4 {
+		t.Fatalf("expect 4 commitments, got %d", len(commitments))
+	}
+
+	commitments, err = CommitmentsFromString(commitments.ToString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range shares {
+		share, err := FromString(shares[i].ToString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !commitments.Verify(share) {
+			t.Fatalf("share %d should be valid", i)
+		}
+	}
+	if Decrypt(shares[:4]) != secret {
+		t.Fatal("decrypt failed")
+	}
+
+	tampered := Share{X: shares[0].X, Y: new(Int).Add(shares[0].Y, NewInt(1)), T: shares[0].T}
+	if commitments.Verify(tampered) {
+		t.Fatal("tampered y should be invalid")
+	}
+	tampered = Share{X: shares[0].X, Y: shares[0].Y, T: new(Int).Add(shares[0].T, NewInt(1))}
+	if commitments.Verify(tampered) {
+		t.Fatal("tampered t should be invalid")
+	}
+	if commitments.Verify(Share{X: shares[0].X, Y: shares[0].Y}) {
+		t.Fatal("share without t should be invalid")
+	}
+
+	// shares of another secret don't match
+	otherShares, _, err := EncryptVerifiable(secret, 7, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitments.Verify(otherShares[0]) {
+		t.Fatal("share of another polynomial should be invalid")
+	}
+}
+
+func TestFromStringLegacy(t *testing.T) {
+	share, err := FromString("123\n456\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.T != nil || share.X.Int64() != 123 || share.Y.Int64() != 456 {
+		t.Fatalf("unexpected share %v", share)
+	}
+
+	_, err = FromString("123")
+	if err == nil {
+		t.Fatal("share with one line should be invalid")
+	}
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"golang.org/x/exp/slices"
-	"strconv"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -30,32 +29,6 @@ func ValidateEmail(email string) bool {
 	return slices.Contains(config.Config.EmailWhitelist, emailSplit[1])
 }
 
-func ValidateEmailFudan(email string) error {
-	year, err := strconv.Atoi(email[:2])
-	if err != nil {
-		return nil
-	}
-	emailSplit := strings.Split(email, "@")
-
-	const messageSuffix = `如果您的邮箱不满足此规则，可以尝试邮箱别名，或发送您的学邮和情况说明到 dev@danta.tech ，我们为您手动处理`
-
-	if emailSplit[1] == "fudan.edu.cn" {
-		if year >= 21 {
-			// check in whitelist
-			if !InUnorderedSlice(config.Config.ValidateEmailWhitelist, email) {
-				return common.BadRequest("21级及以后的同学请使用m.fudan.edu.cn邮箱。" + messageSuffix)
-			}
-		}
-	} else if emailSplit[1] == "m.fudan.edu.cn" {
-		if year <= 20 {
-			if !InUnorderedSlice(config.Config.ValidateEmailWhitelist, email) {
-				return common.BadRequest("20级及以前的同学请使用fudan.edu.cn邮箱。" + messageSuffix)
-			}
-		}
-	}
-	return nil
-}
-
 func ValidateEmailFunc(fl validator.FieldLevel) bool {
 	return ValidateEmail(fl.Field().String())
 }
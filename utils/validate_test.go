@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"github.com/opentreehole/go-common"
 	"testing"
 
@@ -17,14 +16,6 @@ func TestValidateEmail(t *testing.T) {
 	assert.Equal(t, ValidateEmail("123345"), false)
 }
 
-func TestValidateEmailFudan(t *testing.T) {
-	fmt.Println(ValidateEmailFudan("21307130001@m.fudan.edu.cn"))
-	fmt.Println(ValidateEmailFudan("21307130001@fudan.edu.cn"))
-	fmt.Println(ValidateEmailFudan("20307130001@m.fudan.edu.cn"))
-	fmt.Println(ValidateEmailFudan("20307130001@fudan.edu.cn"))
-	fmt.Println(ValidateEmailFudan("abcd@fudan.edu.cn"))
-}
-
 func TestValidateAll(t *testing.T) {
 	type TempStruct struct {
 		Email string `validate:"isValidEmail"`
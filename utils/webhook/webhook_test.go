@@ -0,0 +1,15 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"event_type":"user.deleted","data":{"user_id":1}}`)
+	signature := Sign("0123456789abcdef", 1700000000, body)
+	assert.Equal(t, Verify("0123456789abcdef", 1700000000, body, signature), true)
+	assert.Equal(t, Verify("0123456789abcdef", 1700000001, body, signature), false)
+	assert.Equal(t, Verify("another secret!!", 1700000000, body, signature), false)
+}